@@ -0,0 +1,336 @@
+package wosl
+
+import (
+	"sync"
+
+	"github.com/zeebo/wosl/internal/node"
+	"github.com/zeebo/wosl/internal/node/entry"
+	"github.com/zeebo/wosl/lease"
+)
+
+// When flushing asynchronously, a full root with children to flush into is
+// queued and replaced with an empty one, and a background goroutine flushes the queued roots into their
+// children, oldest first. The queued roots are searched after the root and
+// before any children. Everything below the root, including the queued
+// roots, is only used while holding the lower mutex, which the background
+// goroutine holds while it flushes.
+//
+// If a background flush fails, the root stays queued and the goroutine waits
+// until the error has been returned by WaitFlushed, SetAsyncFlush, Close or
+// a write that had to wait for room in the queue. Each error is returned
+// once, after which the root is flushed again, so a caller can retry once
+// whatever caused the error has been fixed.
+
+// SetAsyncFlush configures the skip list to flush full roots in a background
+// goroutine, and returns any error from earlier background flushes. Writes
+// only block once pending roots are waiting to be flushed. Zero stops the
+// background goroutine, flushes the queued roots, and then goes back to
+// flushing synchronously. While enabled, the merge operator and clock must
+// be safe for concurrent use, and the skip list must not be configured
+// further.
+func (t *T) SetAsyncFlush(pending int) error {
+	if pending < 0 {
+		return Error.New("invalid number of pending roots: %d", pending)
+	}
+
+	if t.cond == nil {
+		t.cond = sync.NewCond(&t.mu)
+	}
+
+	switch {
+	case pending > 0 && t.async == 0:
+		t.mu.Lock()
+		t.running, t.done = true, make(chan struct{})
+		t.mu.Unlock()
+		go t.flushQueue()
+
+	case pending == 0:
+		return t.stop()
+	}
+
+	t.async = pending
+	return t.WaitFlushed()
+}
+
+// Close stops the background goroutine started by SetAsyncFlush, if any, and
+// flushes every root still queued. It returns the error from a background
+// flush that has not yet been returned, or else any error from flushing the
+// queued roots. The skip list must not be used after it is closed.
+func (t *T) Close() error {
+	return t.stop()
+}
+
+// stop stops the background goroutine and flushes the remaining queued
+// roots in the calling goroutine, going back to flushing synchronously.
+func (t *T) stop() error {
+	if t.async == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	t.running = false
+	t.cond.Broadcast()
+	t.mu.Unlock()
+	<-t.done
+	t.async = 0
+
+	t.lower.Lock()
+	defer t.lower.Unlock()
+
+	t.mu.Lock()
+	err := t.takeErr()
+	t.mu.Unlock()
+
+	if derr := t.drain(); err == nil {
+		err = derr
+	}
+	return err
+}
+
+// WaitFlushed blocks until every root queued to be flushed in the background
+// has been flushed. It returns the error from a background flush if one
+// failed and the error has not yet been returned, in which case some roots
+// are still queued.
+func (t *T) WaitFlushed() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.queue) > 0 && t.ferr == nil && t.running {
+		t.cond.Wait()
+	}
+	return t.takeErr()
+}
+
+// takeErr returns the error from a background flush and clears it so that
+// the background goroutine tries the flush again. It must be called while
+// holding the mutex.
+func (t *T) takeErr() error {
+	err := t.ferr
+	if err != nil {
+		t.ferr = nil
+		t.cond.Broadcast()
+	}
+	return err
+}
+
+// swapRoot queues the root to be flushed in the background and replaces it
+// with an empty one, first waiting for the queue to have room.
+func (t *T) swapRoot() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for len(t.queue) >= t.async && t.ferr == nil {
+		t.cond.Wait()
+	}
+	if t.ferr != nil {
+		return t.takeErr()
+	}
+
+	root := node.New(t.root.Height())
+	root.SetPivot(t.root.Pivot())
+	root.SetCompressor(t.comp)
+	root.SetFanout(t.fanout)
+	root.SetBloomBits(t.bits)
+	root.SetWatermark(t.Watermark())
+
+	t.queue = append(t.queue, t.root)
+	t.root = root
+	t.cond.Broadcast()
+	return nil
+}
+
+// queued returns the roots waiting to be flushed, newest first. It must be
+// called while holding the lower mutex.
+func (t *T) queued() []*node.T {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	queued := make([]*node.T, len(t.queue))
+	for i, n := range t.queue {
+		queued[len(queued)-1-i] = n
+	}
+	return queued
+}
+
+// flushQueue flushes the queued roots in order until it is stopped. A root
+// is only removed from the queue once it has been flushed so that reads
+// keep finding its entries until they are in the children.
+func (t *T) flushQueue() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer close(t.done)
+
+	for t.running {
+		if len(t.queue) == 0 || t.ferr != nil {
+			t.cond.Wait()
+			continue
+		}
+		n := t.queue[0]
+		t.mu.Unlock()
+
+		t.lower.Lock()
+		_, _, err := t.flush(n, rootBlock, nil)
+		t.mu.Lock()
+		if err != nil {
+			t.ferr = Error.Wrap(err)
+		} else {
+			t.queue[0] = nil
+			t.queue = t.queue[1:]
+		}
+		t.lower.Unlock()
+
+		t.cond.Broadcast()
+	}
+}
+
+// drain flushes the queued roots, oldest first, in the calling goroutine,
+// stopping at the first error. It must be called while holding the lower
+// mutex when the background goroutine is not running.
+func (t *T) drain() error {
+	for {
+		t.mu.Lock()
+		if len(t.queue) == 0 {
+			t.mu.Unlock()
+			return nil
+		}
+		n := t.queue[0]
+		t.mu.Unlock()
+
+		if _, _, err := t.flush(n, rootBlock, nil); err != nil {
+			return Error.Wrap(err)
+		}
+
+		t.mu.Lock()
+		t.queue[0] = nil
+		t.queue = t.queue[1:]
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	}
+}
+
+// cursor walks the nodes that may have an entry for a key from the newest to
+// the oldest: the root, the queued roots, and then the children along the
+// path to the key. It holds the lower mutex once it leaves the root. With
+// partial reads, the children the cache does not hold are only read in parts
+// instead.
+type cursor struct {
+	t      *T
+	n      *node.T       // current node, unless it was read in parts
+	p      *node.Partial // current child if it was read in parts
+	le     lease.T
+	queued []*node.T
+	locked bool
+}
+
+// cursor returns a cursor positioned at the root.
+func (t *T) cursor() *cursor {
+	return &cursor{t: t, n: t.root}
+}
+
+// next moves the cursor to the next node, which is the child block if there
+// are no more queued roots. It returns false if there is no next node.
+func (c *cursor) next(child uint32) (bool, error) {
+	if !c.locked {
+		c.t.lower.Lock()
+		c.queued, c.locked = c.t.queued(), true
+	}
+	if len(c.queued) > 0 {
+		c.n, c.queued = c.queued[0], c.queued[1:]
+		return true, nil
+	}
+	if c.height() == 0 || child == noBlock || child == invalidBlock {
+		return false, nil
+	}
+
+	if c.t.partial {
+		// the cache may hold a newer version of the child than the disk.
+		if le, ok := c.t.cached(child); ok {
+			c.le.Close()
+			c.le = le
+			if le.Node().Height() != c.height()-1 {
+				return false, Error.New(
+					"invalid child height at block %d: %d != %d",
+					child, le.Node().Height(), c.height()-1)
+			}
+			c.n, c.p = le.Node(), nil
+			return true, nil
+		}
+
+		p, err := c.t.loadPartial(c.height(), child)
+		if err != nil {
+			return false, err
+		}
+		c.le.Close()
+		c.n, c.p = nil, p
+		return true, nil
+	}
+
+	n, err := c.t.descend(c.n, child, &c.le)
+	if err != nil {
+		return false, err
+	}
+	c.n = n
+	return true, nil
+}
+
+// height returns the height of the current node.
+func (c *cursor) height() uint32 {
+	if c.p != nil {
+		return c.p.Height()
+	}
+	return c.n.Height()
+}
+
+// search finds the newest version of the entry for the key in the current
+// node as of the sequence number, or the child that would contain it.
+func (c *cursor) search(key []byte, seq uint64) (
+	ent entry.T, value []byte, child uint32, ok bool, err error) {
+
+	if c.p == nil {
+		ent, value, child, ok = search(c.n, key, seq)
+		return ent, value, child, ok, nil
+	}
+
+	ent, ok, err = c.p.Find(key, seq)
+	if err != nil {
+		return entry.T{}, nil, 0, false, Error.Wrap(err)
+	} else if !ok {
+		child, err = c.childOf(key)
+		return entry.T{}, nil, child, false, err
+	}
+	value, err = c.p.Value(ent)
+	if err != nil {
+		return entry.T{}, nil, 0, false, Error.Wrap(err)
+	}
+	return ent, value, noBlock, true, nil
+}
+
+// childOf returns the child of the current node whose range contains the
+// key.
+func (c *cursor) childOf(key []byte) (uint32, error) {
+	if c.p == nil {
+		return c.n.ChildOf(key), nil
+	}
+	child, err := c.p.ChildOf(key)
+	return child, Error.Wrap(err)
+}
+
+// covering returns the sequence number of the newest range tombstone in the
+// current node as of the sequence number that contains the key, and false
+// if there is none.
+func (c *cursor) covering(key []byte, seq uint64) (uint64, bool, error) {
+	if c.p == nil {
+		rseq, ok := c.n.Covering(key, seq)
+		return rseq, ok, nil
+	}
+	rseq, ok, err := c.p.Covering(key, seq)
+	return rseq, ok, Error.Wrap(err)
+}
+
+// close releases the lease and the lower mutex held by the cursor.
+func (c *cursor) close() {
+	c.le.Close()
+	if c.locked {
+		c.t.lower.Unlock()
+	}
+}
@@ -0,0 +1,219 @@
+package wosl
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/lease"
+)
+
+// gateCache blocks getting nodes while its mutex is held.
+type gateCache struct {
+	*memCache
+	gate sync.Mutex
+}
+
+func (g *gateCache) Get(block uint32) (lease.T, error) {
+	g.gate.Lock()
+	defer g.gate.Unlock()
+	return g.memCache.Get(block)
+}
+
+func TestAsyncFlush(t *testing.T) {
+	m := &gateCache{memCache: newTestCache()}
+	sl, err := New(m)
+	assert.NoError(t, err)
+	assert.NoError(t, sl.SetAsyncFlush(2))
+
+	value := func(i int) []byte { return []byte(fmt.Sprintf("%0100d", i)) }
+
+	queued := func() int {
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		return len(sl.queue)
+	}
+
+	// with the children blocked, writes continue until too many roots are
+	// queued, and then block.
+	m.gate.Lock()
+	progress := make(chan int)
+	go func() {
+		defer close(progress)
+		for i := 0; i < 1200; i++ {
+			if err := sl.Insert(testKey(i), value(i)); err != nil {
+				panic(err)
+			}
+			progress <- i
+		}
+	}()
+
+	last := -1
+	for blocked := false; !blocked; {
+		select {
+		case i, ok := <-progress:
+			assert.That(t, ok)
+			last = i
+		case <-time.After(100 * time.Millisecond):
+			blocked = true
+		}
+	}
+	assert.That(t, last > 0 && last < 1199)
+	assert.Equal(t, queued(), 2)
+
+	m.gate.Unlock()
+	for range progress {
+	}
+
+	// reads see the keys whether they are in the root, queued, or flushed.
+	check := func(t *testing.T) {
+		t.Helper()
+		keys := make([][]byte, 0, 1200)
+		for i := 0; i < 1200; i++ {
+			got, err := sl.Read(testKey(i))
+			assert.NoError(t, err)
+			assert.Equal(t, string(got), string(value(i)))
+			keys = append(keys, testKey(i))
+		}
+
+		values, err := sl.ReadMany(keys)
+		assert.NoError(t, err)
+		for i := range keys {
+			assert.Equal(t, string(values[i]), string(value(i)))
+		}
+
+		var skey []byte
+		for i := 0; i < 1200; i++ {
+			skey, _, err = sl.Successor(skey, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, string(skey), string(testKey(i)))
+		}
+	}
+	check(t)
+
+	assert.NoError(t, sl.WaitFlushed())
+	assert.Equal(t, queued(), 0)
+	check(t)
+
+	// going back to flushing synchronously stops the flusher.
+	assert.NoError(t, sl.SetAsyncFlush(0))
+	for i := 0; i < 1200; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), value(i+1)))
+	}
+	got, err := sl.Read(testKey(10))
+	assert.NoError(t, err)
+	assert.Equal(t, string(got), string(value(11)))
+}
+
+// failCache fails to get nodes while fail is set.
+type failCache struct {
+	*memCache
+	mu   sync.Mutex
+	fail bool
+}
+
+func (f *failCache) setFail(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail = fail
+}
+
+func (f *failCache) Get(block uint32) (lease.T, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return lease.T{}, Error.New("get failed")
+	}
+	return f.memCache.Get(block)
+}
+
+func TestAsyncFlushFresh(t *testing.T) {
+	sl, _ := newTestList(t)
+	assert.NoError(t, sl.SetAsyncFlush(2))
+
+	// only use keys that do not grow the root, so that it fills up many
+	// times without having any children to flush into.
+	var keys [][]byte
+	for i := 0; len(keys) < 2000; i++ {
+		if key := testKey(i); sl.height(key) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	for i, key := range keys {
+		assert.NoError(t, sl.Insert(key, testValue(i)))
+	}
+	assert.NoError(t, sl.WaitFlushed())
+	assert.Equal(t, sl.root.Height(), 1)
+
+	for i, key := range keys {
+		got, err := sl.Read(key)
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(testValue(i)))
+	}
+	assert.NoError(t, sl.Close())
+}
+
+func TestAsyncFlushErrors(t *testing.T) {
+	m := &failCache{memCache: newTestCache()}
+	sl, err := New(m)
+	assert.NoError(t, err)
+	assert.NoError(t, sl.SetAsyncFlush(1))
+
+	value := func(i int) []byte { return []byte(fmt.Sprintf("%0100d", i)) }
+
+	state := func() (int, error) {
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		return len(sl.queue), sl.ferr
+	}
+
+	// writes that wait for room in the queue return the flush error once.
+	m.setFail(true)
+	n := 0
+	for ; n < 1200; n++ {
+		if err := sl.Insert(testKey(n), value(n)); err != nil {
+			break
+		}
+	}
+	assert.That(t, n < 1200)
+	queued, ferr := state()
+	assert.Equal(t, queued, 1)
+	assert.NoError(t, ferr)
+
+	// once the cause is fixed, the failed root is flushed again.
+	m.setFail(false)
+	assert.NoError(t, sl.WaitFlushed())
+	queued, _ = state()
+	assert.Equal(t, queued, 0)
+
+	// close returns an error that was not returned yet, and still flushes
+	// the queued roots.
+	m.setFail(true)
+	for queued == 0 {
+		n++
+		assert.NoError(t, sl.Insert(testKey(n), value(n)))
+		queued, _ = state()
+	}
+	for ferr == nil {
+		time.Sleep(time.Millisecond)
+		_, ferr = state()
+	}
+	m.setFail(false)
+	assert.Error(t, sl.Close())
+	queued, _ = state()
+	assert.Equal(t, queued, 0)
+
+	select {
+	case <-sl.done:
+	default:
+		t.Fatal("background flusher still running")
+	}
+
+	for i := 0; i <= n; i++ {
+		got, err := sl.Read(testKey(i))
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(value(i)))
+	}
+}
@@ -0,0 +1,42 @@
+package wosl
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	sl, m := newTestList(t)
+	sl.SetBloomFilter(10)
+
+	for i := 0; i < 1500; i += 2 {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	for i := 0; i < 1500; i += 10 {
+		assert.NoError(t, sl.Delete(testKey(i)))
+	}
+	assert.NoError(t, m.Flush())
+
+	for i := 0; i < 1500; i++ {
+		got, err := sl.Read(testKey(i))
+		assert.NoError(t, err)
+		if i%2 == 1 || i%10 == 0 {
+			assert.Nil(t, got)
+		} else {
+			assert.Equal(t, string(got), string(testValue(i)))
+		}
+	}
+
+	// the written nodes skip almost every key they do not have.
+	assert.That(t, len(m.nodes) > 0)
+	for _, n := range m.nodes {
+		positives := 0
+		for i := 1; i < 1500; i += 2 {
+			if n.MayContain(testKey(i)) {
+				positives++
+			}
+		}
+		assert.That(t, positives < 50)
+	}
+}
@@ -20,6 +20,16 @@ type Cache interface {
 	Add(n *node.T, block uint32)
 }
 
+// PeekCache is an optional extension of Cache for caches that can return a
+// node they hold without loading it from the disk.
+type PeekCache interface {
+	Cache
+
+	// Peek returns a lease on the node the cache holds for the given block
+	// if it has one. It returns false instead of reading the disk.
+	Peek(block uint32) (lease.T, bool)
+}
+
 // Disk is an interface abstracting some persistent storage.
 type Disk interface {
 	// BlockSize returns the natural blocksize of the disk. Read and Write
@@ -51,3 +61,82 @@ type Disk interface {
 	// if the maximum block has been deleted.
 	MaxBlock() (uint32, error)
 }
+
+// Allocator chooses the block that a new node is written to, such as to
+// place it on a disk suited to its height.
+type Allocator interface {
+	// Alloc returns a block number that is not in use for a new node of the
+	// given height.
+	Alloc(height uint32) (uint32, error)
+}
+
+// RangeDisk is an optional extension of Disk for disks that can read part
+// of a block without reading all of it.
+type RangeDisk interface {
+	Disk
+
+	// ReadAt returns up to n bytes of the data associated with the given
+	// block number starting at the offset. It returns fewer bytes only if
+	// the data ends first. If there is no data for that block number, nil
+	// is returned with no error.
+	ReadAt(block uint32, off, n uint32) ([]byte, error)
+}
+
+// ListDisk is an optional extension of Disk for disks that can list the
+// blocks they store without trying every block up to the largest one.
+type ListDisk interface {
+	Disk
+
+	// Blocks returns the block numbers that have data, in increasing order.
+	Blocks() ([]uint32, error)
+}
+
+// eachBlock calls fn with every block that may have data on the disk, in
+// increasing order, stopping at the first error. It uses the blocks the
+// disk lists if it can, and otherwise every block up to the largest one.
+func eachBlock(disk Disk, fn func(block uint32) error) error {
+	if ld, ok := disk.(ListDisk); ok {
+		blocks, err := ld.Blocks()
+		if err != nil {
+			return Error.Wrap(err)
+		}
+		for _, block := range blocks {
+			if err := fn(block); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	maxBlock, err := disk.MaxBlock()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	for block := uint32(1); block <= maxBlock && block != invalidBlock; block++ {
+		if err := fn(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAt reads up to n bytes of the block starting at the offset, reading
+// the whole block if the disk can't read part of it.
+func readAt(disk Disk, block uint32, off, n uint32) ([]byte, error) {
+	if rd, ok := disk.(RangeDisk); ok {
+		return rd.ReadAt(block, off, n)
+	}
+
+	buf, err := disk.Read(block)
+	if err != nil || buf == nil {
+		return nil, err
+	}
+	if uint64(off) > uint64(len(buf)) {
+		return buf[len(buf):], nil
+	}
+	buf = buf[off:]
+	if uint64(n) < uint64(len(buf)) {
+		buf = buf[:n]
+	}
+	return buf, nil
+}
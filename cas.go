@@ -0,0 +1,87 @@
+package wosl
+
+import (
+	"bytes"
+
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// matches returns true if the current value of the key is equal to the
+// value. A nil value matches only a key that does not exist.
+func (t *T) matches(key, value []byte) (bool, error) {
+	current, ok, err := t.read(key, entry.MaxSeq)
+	if err != nil {
+		return false, Error.Wrap(err)
+	}
+	if value == nil {
+		return !ok, nil
+	}
+	return ok && bytes.Equal(current, value), nil
+}
+
+var compareAndSwapThunk mon.Thunk // timing for CompareAndSwap
+
+// CompareAndSwap associates the new value with the key if its current value
+// is equal to the old value, and returns whether it did. A nil old value
+// matches only a key that does not exist. It is not safe to modify the new
+// value slice.
+func (t *T) CompareAndSwap(key, old, new []byte) (bool, error) {
+	timer := compareAndSwapThunk.Start()
+
+	if ok, err := t.matches(key, old); err != nil || !ok {
+		timer.Stop()
+		return false, err
+	}
+	if err := t.insert(key, new, 0, t.next()); err != nil {
+		timer.Stop()
+		return false, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return true, nil
+}
+
+var insertIfAbsentThunk mon.Thunk // timing for InsertIfAbsent
+
+// InsertIfAbsent associates the value with the key if it does not exist,
+// and returns whether it did. It is not safe to modify the value slice.
+func (t *T) InsertIfAbsent(key, value []byte) (bool, error) {
+	timer := insertIfAbsentThunk.Start()
+
+	if ok, err := t.matches(key, nil); err != nil || !ok {
+		timer.Stop()
+		return false, err
+	}
+	if err := t.insert(key, value, 0, t.next()); err != nil {
+		timer.Stop()
+		return false, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return true, nil
+}
+
+var deleteIfEqualsThunk mon.Thunk // timing for DeleteIfEquals
+
+// DeleteIfEquals removes the key if its current value is equal to the value,
+// and returns whether it did.
+func (t *T) DeleteIfEquals(key, value []byte) (bool, error) {
+	timer := deleteIfEqualsThunk.Start()
+
+	if value == nil {
+		timer.Stop()
+		return false, nil
+	}
+	if ok, err := t.matches(key, value); err != nil || !ok {
+		timer.Stop()
+		return false, err
+	}
+	if err := t.delete(key, t.next()); err != nil {
+		timer.Stop()
+		return false, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package wosl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	sl, _ := newTestList(t)
+	sl.SetMergeOperator(counter{})
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	sl.SetClock(clock)
+
+	value := func(i, v int) []byte { return []byte(fmt.Sprint("value", i, "-", v)) }
+	count := func(n uint64) []byte { return binary.BigEndian.AppendUint64(nil, n) }
+
+	check := func(t *testing.T, k []byte, want []byte) {
+		t.Helper()
+		got, err := sl.Read(k)
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(want))
+	}
+	applied := func(t *testing.T, want bool) func(bool, error) {
+		return func(ok bool, err error) {
+			t.Helper()
+			assert.NoError(t, err)
+			assert.Equal(t, ok, want)
+		}
+	}
+
+	// every key is claimed exactly once, and pushed down the skip list by
+	// the later ones.
+	for i := 0; i < 1000; i++ {
+		applied(t, true)(sl.InsertIfAbsent(testKey(i), value(i, 0)))
+		applied(t, false)(sl.InsertIfAbsent(testKey(i), value(i, 1)))
+	}
+	for i := 0; i < 1000; i++ {
+		check(t, testKey(i), value(i, 0))
+	}
+
+	// swaps only apply to the current value, wherever it is buffered.
+	for i := 0; i < 1000; i += 3 {
+		applied(t, false)(sl.CompareAndSwap(testKey(i), value(i, 1), value(i, 2)))
+		applied(t, false)(sl.CompareAndSwap(testKey(i), nil, value(i, 2)))
+		applied(t, true)(sl.CompareAndSwap(testKey(i), value(i, 0), value(i, 1)))
+		check(t, testKey(i), value(i, 1))
+	}
+
+	// deletes only apply to the current value, and deleted keys are absent.
+	for i := 0; i < 1000; i += 5 {
+		want := value(i, 0)
+		if i%3 == 0 {
+			want = value(i, 1)
+		}
+		applied(t, false)(sl.DeleteIfEquals(testKey(i), value(i, 2)))
+		applied(t, true)(sl.DeleteIfEquals(testKey(i), want))
+		applied(t, false)(sl.DeleteIfEquals(testKey(i), want))
+		applied(t, true)(sl.InsertIfAbsent(testKey(i), value(i, 3)))
+		check(t, testKey(i), value(i, 3))
+	}
+
+	// range deleted, expired and merged keys are compared by their values.
+	assert.NoError(t, sl.DeleteRange(testKey(10), testKey(20)))
+	applied(t, true)(sl.InsertIfAbsent(testKey(11), value(11, 4)))
+
+	assert.NoError(t, sl.InsertWithTTL(testKey(2000), value(2000, 0), time.Minute))
+	applied(t, false)(sl.InsertIfAbsent(testKey(2000), value(2000, 1)))
+	clock.now = clock.now.Add(time.Minute)
+	applied(t, true)(sl.InsertIfAbsent(testKey(2000), value(2000, 1)))
+
+	assert.NoError(t, sl.Merge(testKey(3000), count(1)))
+	assert.NoError(t, sl.Merge(testKey(3000), count(1)))
+	applied(t, false)(sl.CompareAndSwap(testKey(3000), count(1), count(5)))
+	applied(t, true)(sl.CompareAndSwap(testKey(3000), count(2), count(5)))
+	check(t, testKey(3000), count(5))
+}
@@ -2,8 +2,10 @@ package wosl
 
 import (
 	"fmt"
+	"testing"
 	"time"
 
+	"github.com/zeebo/assert"
 	"github.com/zeebo/errs"
 	"github.com/zeebo/wosl/internal/node"
 	"github.com/zeebo/wosl/internal/pcg"
@@ -34,19 +36,21 @@ func init() {
 //
 
 type memCache struct {
-	disk   *memDisk
+	disk   Disk
 	nodes  map[uint32]*node.T
 	leases map[uint32]int
-	buf    []byte
 	cb     func(*node.T, uint32) error
 }
 
 func newMemCache(size uint32) *memCache {
+	return newDiskCache(newMemDisk(size))
+}
+
+func newDiskCache(disk Disk) *memCache {
 	m := &memCache{
-		disk:   newMemDisk(size),
+		disk:   disk,
 		nodes:  make(map[uint32]*node.T),
 		leases: make(map[uint32]int),
-		buf:    make([]byte, size),
 	}
 	m.cb = m.writeBack
 	return m
@@ -79,10 +83,10 @@ func (m *memCache) writeBack(n *node.T, block uint32) error {
 		return nil
 	}
 
-	var err error
-	if m.buf, err = n.Write(m.buf[:0]); err != nil {
+	buf, err := n.Write(nil)
+	if err != nil {
 		return errs.Wrap(err)
-	} else if err := m.disk.Write(block, m.buf); err != nil {
+	} else if err := m.disk.Write(block, buf); err != nil {
 		return errs.Wrap(err)
 	}
 	m.nodes[block] = n
@@ -143,10 +147,33 @@ func (m *memDisk) Delete(block uint32) error {
 }
 
 func (m *memDisk) Write(block uint32, data []byte) error {
-	m.blocks[block] = append([]byte(nil), data...)
+	m.blocks[block] = append([]byte{}, data...)
 	if block > m.max {
 		m.max = block
 	}
 
 	return nil
 }
+
+//
+// helpers shared by the tests
+//
+
+// testKey returns the i'th key used by the tests. Keys sort in the same order
+// as their index.
+func testKey(i int) []byte { return []byte(fmt.Sprintf("k%04d", i)) }
+
+// testValue returns the value the tests store for the i'th key.
+func testValue(i int) []byte { return []byte(fmt.Sprint("value", i)) }
+
+// newTestCache returns a fresh memory cache for the tests.
+func newTestCache() *memCache { return newMemCache(1 << 15) }
+
+// newTestList returns a skip list on a fresh memory cache, and the cache.
+func newTestList(t *testing.T) (*T, *memCache) {
+	t.Helper()
+	m := newTestCache()
+	sl, err := New(m)
+	assert.NoError(t, err)
+	return sl, m
+}
@@ -0,0 +1,14 @@
+package wosl
+
+import "github.com/zeebo/wosl/internal/node"
+
+// Compressor compresses the nodes of the skip list as they are written, and
+// decompresses them as they are loaded. The codec it reports is recorded in
+// every node it compresses, so it must be unique and stable.
+type Compressor = node.Compressor
+
+// FlateCompressor returns a Compressor that uses compress/flate at the given
+// level.
+func FlateCompressor(level int) Compressor {
+	return node.NewFlateCompressor(level)
+}
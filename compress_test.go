@@ -0,0 +1,39 @@
+package wosl
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestCompressor(t *testing.T) {
+	sl, m := newTestList(t)
+	assert.NoError(t, sl.SetCompressor(FlateCompressor(flate.BestSpeed)))
+
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprint(i)), 10)
+	}
+
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, sl.Insert([]byte(fmt.Sprint(i)), value(i)))
+	}
+
+	// the values compress well, so the root should be allowed to grow past
+	// the block size before flushing.
+	assert.That(t, sl.limit > uint64(sl.b))
+
+	for i := 0; i < 1000; i++ {
+		got, err := sl.Read([]byte(fmt.Sprint(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(value(i)))
+	}
+
+	// everything written to disk should be compressed.
+	assert.NoError(t, m.Flush())
+	for _, buf := range m.disk.(*memDisk).blocks {
+		assert.That(t, len(buf) < int(sl.b))
+	}
+}
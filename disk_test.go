@@ -0,0 +1,96 @@
+package wosl
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+// checkDisk asserts that the empty disk behaves as the Disk interface
+// documents.
+func checkDisk(t *testing.T, disk Disk) {
+	t.Helper()
+
+	assert.That(t, disk.BlockSize() > 0)
+
+	maxBlock, err := disk.MaxBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, maxBlock, 0)
+
+	buf, err := disk.Read(1)
+	assert.NoError(t, err)
+	assert.Nil(t, buf)
+
+	// writes are observed by reads, including empty ones.
+	assert.NoError(t, disk.Write(1, []byte("one")))
+	assert.NoError(t, disk.Write(3, []byte("three")))
+	assert.NoError(t, disk.Write(2, nil))
+
+	buf, err = disk.Read(1)
+	assert.NoError(t, err)
+	assert.Equal(t, string(buf), "one")
+	buf, err = disk.Read(2)
+	assert.NoError(t, err)
+	assert.NotNil(t, buf)
+	assert.Equal(t, len(buf), 0)
+
+	// the written data is copied.
+	data := []byte("data")
+	assert.NoError(t, disk.Write(4, data))
+	data[0] = 'x'
+	buf, err = disk.Read(4)
+	assert.NoError(t, err)
+	assert.Equal(t, string(buf), "data")
+
+	// overwrites replace the data.
+	assert.NoError(t, disk.Write(1, []byte("uno")))
+	buf, err = disk.Read(1)
+	assert.NoError(t, err)
+	assert.Equal(t, string(buf), "uno")
+
+	maxBlock, err = disk.MaxBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, maxBlock, 4)
+
+	// deletes remove the block without lowering the max block, and do
+	// nothing for blocks that do not exist.
+	assert.NoError(t, disk.Delete(4))
+	assert.NoError(t, disk.Delete(4))
+	assert.NoError(t, disk.Delete(100))
+
+	buf, err = disk.Read(4)
+	assert.NoError(t, err)
+	assert.Nil(t, buf)
+	buf, err = disk.Read(3)
+	assert.NoError(t, err)
+	assert.Equal(t, string(buf), "three")
+
+	maxBlock, err = disk.MaxBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, maxBlock, 4)
+
+	// the block can be written again after it is deleted.
+	assert.NoError(t, disk.Write(4, []byte("four")))
+	buf, err = disk.Read(4)
+	assert.NoError(t, err)
+	assert.Equal(t, string(buf), "four")
+}
+
+func TestDiskContract(t *testing.T) {
+	t.Run("Memory", func(t *testing.T) {
+		checkDisk(t, newMemDisk(1<<10))
+	})
+
+	t.Run("Tiered", func(t *testing.T) {
+		disk, err := NewTieredDisk(func(uint32) int { return 0 },
+			newMemDisk(1<<10), newMemDisk(1<<10))
+		assert.NoError(t, err)
+		checkDisk(t, disk)
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		disk, err := NewEncryptedDisk(newMemDisk(1<<10), 1, make([]byte, 32))
+		assert.NoError(t, err)
+		checkDisk(t, disk)
+	})
+}
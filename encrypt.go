@@ -0,0 +1,330 @@
+package wosl
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/zeebo/mon"
+)
+
+const encryptedHeaderSize = (0 +
+	4 + // key id
+	12 + // nonce
+	0)
+
+// EncryptedDisk is a Disk that encrypts every block stored on another disk
+// with AES-GCM. Each block is prefixed with the id of the key it was
+// encrypted with and a random nonce, and the block number and key id are
+// authenticated along with it, so a block can't be moved to another block
+// number or claim to use another key without failing to decrypt.
+//
+// Blocks are always written with the current key, and any key that has
+// been added can decrypt. Rotating to a new key re-encrypts every block of
+// the underlying disk in a background goroutine, after which the old key is
+// no longer needed. The blocks are listed if the underlying disk is a
+// ListDisk, and otherwise every block up to its largest one is tried.
+// Since blocks must be decrypted as a whole, it does not support ranged
+// reads. It is safe for concurrent use.
+type EncryptedDisk struct {
+	disk Disk
+
+	mu      sync.RWMutex           // protects the fields below
+	keys    map[uint32]cipher.AEAD // every key that can decrypt
+	current uint32                 // id of the key used to encrypt
+	cond    *sync.Cond             // signaled when a rotation pass ends
+	gen     uint64                 // incremented by every rotation
+	done    uint64                 // generation of the last finished pass
+	running bool                   // if the background goroutine is running
+	rerr    error                  // error from re-encrypting
+}
+
+var _ ListDisk = (*EncryptedDisk)(nil)
+
+// NewEncryptedDisk returns a disk that encrypts blocks stored on the disk
+// with the key, which must be 16, 24 or 32 bytes long. The key id is
+// stored with every block so that the key can be found after a rotation.
+func NewEncryptedDisk(disk Disk, id uint32, key []byte) (*EncryptedDisk, error) {
+	e := &EncryptedDisk{
+		disk: disk,
+		keys: make(map[uint32]cipher.AEAD),
+	}
+	e.cond = sync.NewCond(&e.mu)
+	if err := e.AddKey(id, key); err != nil {
+		return nil, err
+	}
+	e.current = id
+	return e, nil
+}
+
+// AddKey makes the key with the id available for decrypting blocks that
+// were written with it, such as by a rotation that did not finish.
+func (e *EncryptedDisk) AddKey(id uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.keys[id]; ok {
+		return Error.New("duplicate key id: %d", id)
+	}
+	e.keys[id] = aead
+	return nil
+}
+
+// Rotate adds the key with the id and makes it the current key, and starts
+// re-encrypting every block that was written with another key in the
+// background. Use WaitRotated to know when the older keys are unused.
+func (e *EncryptedDisk) Rotate(id uint32, key []byte) error {
+	if err := e.AddKey(id, key); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.current = id
+	e.gen++
+	e.rerr = nil
+	if !e.running {
+		e.running = true
+		go e.reencrypt()
+	}
+	return nil
+}
+
+// WaitRotated blocks until every block has been re-encrypted with the key
+// from the latest rotation, and returns any error from re-encrypting.
+func (e *EncryptedDisk) WaitRotated() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for e.done < e.gen && e.rerr == nil {
+		e.cond.Wait()
+	}
+	return e.rerr
+}
+
+// RemoveKey forgets the key with the id. It fails if it is the current key.
+// Blocks still encrypted with it can no longer be read.
+func (e *EncryptedDisk) RemoveKey(id uint32) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if id == e.current {
+		return Error.New("cannot remove the current key: %d", id)
+	}
+	delete(e.keys, id)
+	return nil
+}
+
+// reencrypt passes over every block, rewriting the ones that are not
+// encrypted with the current key, until a pass finishes without another
+// rotation having started.
+func (e *EncryptedDisk) reencrypt() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for e.done < e.gen {
+		gen := e.gen
+		e.mu.Unlock()
+		err := e.pass()
+		e.mu.Lock()
+
+		if err != nil {
+			e.rerr = err
+			break
+		}
+		e.done = gen
+	}
+
+	e.running = false
+	e.cond.Broadcast()
+}
+
+// pass rewrites every block that is not encrypted with the current key.
+func (e *EncryptedDisk) pass() error {
+	return eachBlock(e.disk, e.rewrite)
+}
+
+// rewrite re-encrypts the block with the current key if it was written with
+// another one. The mutex is held so that it can't race with a write.
+func (e *EncryptedDisk) rewrite(block uint32) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	buf, err := e.disk.Read(block)
+	if err != nil {
+		return Error.Wrap(err)
+	} else if buf == nil {
+		return nil
+	}
+	if len(buf) >= encryptedHeaderSize && binary.BigEndian.Uint32(buf[0:4]) == e.current {
+		return nil
+	}
+
+	aead, id, err := e.keyOf(block, buf)
+	if err != nil {
+		return err
+	}
+	data, err := decrypt(aead, id, block, buf)
+	if err != nil {
+		return err
+	}
+	sealed, err := e.seal(block, data)
+	if err != nil {
+		return err
+	}
+	return Error.Wrap(e.disk.Write(block, sealed))
+}
+
+// associated returns the data authenticated along with the block.
+func associated(block, id uint32) []byte {
+	var ad [8]byte
+	binary.BigEndian.PutUint32(ad[0:4], block)
+	binary.BigEndian.PutUint32(ad[4:8], id)
+	return ad[:]
+}
+
+// seal encrypts the data for the block with the current key. It must be
+// called while holding the mutex.
+func (e *EncryptedDisk) seal(block uint32, data []byte) ([]byte, error) {
+	aead := e.keys[e.current]
+
+	out := make([]byte, encryptedHeaderSize, encryptedHeaderSize+len(data)+aead.Overhead())
+	binary.BigEndian.PutUint32(out[0:4], e.current)
+	if _, err := io.ReadFull(rand.Reader, out[4:encryptedHeaderSize]); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return aead.Seal(out, out[4:encryptedHeaderSize], data, associated(block, e.current)), nil
+}
+
+// keyOf returns the key the stored block was written with, along with its
+// id. It must be called while holding the mutex.
+func (e *EncryptedDisk) keyOf(block uint32, buf []byte) (cipher.AEAD, uint32, error) {
+	if len(buf) < encryptedHeaderSize {
+		return nil, 0, Error.New("encrypted block %d too short: %d", block, len(buf))
+	}
+	id := binary.BigEndian.Uint32(buf[0:4])
+	aead, ok := e.keys[id]
+	if !ok {
+		return nil, 0, Error.New("unknown key id for block %d: %d", block, id)
+	}
+	return aead, id, nil
+}
+
+// decrypt decrypts the stored block with the key with the id it was written
+// with. The key is safe to use without holding the mutex.
+func decrypt(aead cipher.AEAD, id, block uint32, buf []byte) ([]byte, error) {
+	data, err := aead.Open(nil, buf[4:encryptedHeaderSize], buf[encryptedHeaderSize:], associated(block, id))
+	if err != nil {
+		return nil, Error.New("unable to decrypt block %d: %v", block, err)
+	}
+	if data == nil {
+		data = []byte{}
+	}
+	return data, nil
+}
+
+// BlockSize returns the natural block size of the underlying disk.
+func (e *EncryptedDisk) BlockSize() uint32 { return e.disk.BlockSize() }
+
+// MaxBlock returns the largest block ever written to the underlying disk.
+func (e *EncryptedDisk) MaxBlock() (uint32, error) {
+	maxBlock, err := e.disk.MaxBlock()
+	if err != nil {
+		return 0, Error.Wrap(err)
+	}
+	return maxBlock, nil
+}
+
+// Blocks returns the blocks of the underlying disk that may have data, in
+// increasing order. If it can't list them, every block up to its largest
+// one is returned.
+func (e *EncryptedDisk) Blocks() ([]uint32, error) {
+	var blocks []uint32
+	err := eachBlock(e.disk, func(block uint32) error {
+		blocks = append(blocks, block)
+		return nil
+	})
+	return blocks, err
+}
+
+var encryptedReadThunk mon.Thunk // timing info for EncryptedDisk.Read
+
+// Read returns the decrypted data for the block, or nil if it does not
+// exist. It returns an error if the block was modified or moved. The mutex
+// is held for reading until the key the block was written with is found,
+// so that a rotation can't rewrite the block and remove the key first, and
+// the block is decrypted after releasing it so that reads run in parallel.
+func (e *EncryptedDisk) Read(block uint32) ([]byte, error) {
+	timer := encryptedReadThunk.Start()
+
+	e.mu.RLock()
+	buf, err := e.disk.Read(block)
+	if err != nil {
+		e.mu.RUnlock()
+		timer.Stop()
+		return nil, Error.Wrap(err)
+	} else if buf == nil {
+		e.mu.RUnlock()
+		timer.Stop()
+		return nil, nil
+	}
+	aead, id, err := e.keyOf(block, buf)
+	e.mu.RUnlock()
+	if err != nil {
+		timer.Stop()
+		return nil, err
+	}
+
+	data, err := decrypt(aead, id, block, buf)
+	if err != nil {
+		timer.Stop()
+		return nil, err
+	}
+
+	timer.Stop()
+	return data, nil
+}
+
+var encryptedWriteThunk mon.Thunk // timing info for EncryptedDisk.Write
+
+// Write encrypts the data with the current key and stores it for the block.
+func (e *EncryptedDisk) Write(block uint32, data []byte) error {
+	timer := encryptedWriteThunk.Start()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sealed, err := e.seal(block, data)
+	if err != nil {
+		timer.Stop()
+		return err
+	}
+	if err := e.disk.Write(block, sealed); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return nil
+}
+
+// Delete removes the block from the underlying disk.
+func (e *EncryptedDisk) Delete(block uint32) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return Error.Wrap(e.disk.Delete(block))
+}
@@ -0,0 +1,301 @@
+package wosl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestEncryptedDisk(t *testing.T) {
+	key := func(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+
+	t.Run("Basic", func(t *testing.T) {
+		mem := newMemDisk(1 << 10)
+		disk, err := NewEncryptedDisk(mem, 1, key(1))
+		assert.NoError(t, err)
+
+		assert.NoError(t, disk.Write(1, []byte("secret one")))
+		assert.NoError(t, disk.Write(2, []byte("secret two")))
+		assert.That(t, !bytes.Contains(mem.blocks[1], []byte("secret")))
+		assert.Equal(t, binary.BigEndian.Uint32(mem.blocks[1]), 1)
+
+		buf, err := disk.Read(1)
+		assert.NoError(t, err)
+		assert.Equal(t, string(buf), "secret one")
+
+		// the same data encrypts differently every time.
+		first := mem.blocks[1]
+		assert.NoError(t, disk.Write(1, []byte("secret one")))
+		assert.That(t, !bytes.Equal(first, mem.blocks[1]))
+
+		// a disk with the wrong key can't read it.
+		other, err := NewEncryptedDisk(mem, 1, key(2))
+		assert.NoError(t, err)
+		_, err = other.Read(1)
+		assert.Error(t, err)
+
+		_, err = NewEncryptedDisk(mem, 1, key(1)[:7])
+		assert.Error(t, err)
+	})
+
+	t.Run("Tamper", func(t *testing.T) {
+		mem := newMemDisk(1 << 10)
+		disk, err := NewEncryptedDisk(mem, 1, key(1))
+		assert.NoError(t, err)
+
+		assert.NoError(t, disk.Write(1, []byte("one")))
+		assert.NoError(t, disk.Write(2, []byte("two")))
+
+		// swapping blocks fails to decrypt.
+		mem.blocks[1], mem.blocks[2] = mem.blocks[2], mem.blocks[1]
+		_, err = disk.Read(1)
+		assert.Error(t, err)
+		_, err = disk.Read(2)
+		assert.Error(t, err)
+
+		// so does modifying the data or claiming another key.
+		assert.NoError(t, disk.Write(3, []byte("three")))
+		mem.blocks[3][len(mem.blocks[3])-1] ^= 1
+		_, err = disk.Read(3)
+		assert.Error(t, err)
+
+		assert.NoError(t, disk.AddKey(2, key(1)))
+		assert.NoError(t, disk.Write(4, []byte("four")))
+		binary.BigEndian.PutUint32(mem.blocks[4], 2)
+		_, err = disk.Read(4)
+		assert.Error(t, err)
+
+		mem.blocks[5] = []byte("short")
+		_, err = disk.Read(5)
+		assert.Error(t, err)
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		mem := newMemDisk(1 << 10)
+		disk, err := NewEncryptedDisk(mem, 1, key(1))
+		assert.NoError(t, err)
+
+		for i := 1; i <= 100; i++ {
+			assert.NoError(t, disk.Write(uint32(i), numbers[i]))
+		}
+		assert.NoError(t, disk.Delete(50))
+
+		// writes keep happening while the blocks are re-encrypted.
+		assert.NoError(t, disk.Rotate(2, key(2)))
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= 100; i += 2 {
+				assert.NoError(t, disk.Write(uint32(i), numbers[i]))
+			}
+		}()
+		assert.NoError(t, disk.Rotate(3, key(3)))
+		wg.Wait()
+		assert.NoError(t, disk.WaitRotated())
+
+		assert.Error(t, disk.RemoveKey(3))
+		assert.NoError(t, disk.RemoveKey(1))
+		assert.NoError(t, disk.RemoveKey(2))
+
+		for i := 1; i <= 100; i++ {
+			buf, err := disk.Read(uint32(i))
+			assert.NoError(t, err)
+			if i == 50 {
+				assert.Nil(t, buf)
+				continue
+			}
+			assert.Equal(t, string(buf), string(numbers[i]))
+			assert.Equal(t, binary.BigEndian.Uint32(mem.blocks[uint32(i)]), 3)
+		}
+
+		// a rotation that can't decrypt some block reports the error.
+		mem.blocks[101] = []byte("not encrypted at all")
+		mem.max = 101
+		assert.NoError(t, disk.Rotate(4, key(4)))
+		assert.Error(t, disk.WaitRotated())
+	})
+
+	t.Run("ReadDuringRotate", func(t *testing.T) {
+		mem := &gateDisk{memDisk: newMemDisk(1 << 10)}
+		disk, err := NewEncryptedDisk(mem, 1, key(1))
+		assert.NoError(t, err)
+		assert.NoError(t, disk.Write(1, []byte("one")))
+
+		// stall a read after it has read the block from the disk.
+		wait := make(chan struct{})
+		mem.arm(wait)
+		errs := make(chan error, 1)
+		go func() {
+			buf, err := disk.Read(1)
+			if err == nil && string(buf) != "one" {
+				err = fmt.Errorf("read wrong data: %q", buf)
+			}
+			errs <- err
+		}()
+		for mem.armed() {
+			time.Sleep(time.Millisecond)
+		}
+
+		// the block can't be rotated to a new key, and the key it was read
+		// with removed, before the stalled read decrypts it.
+		assert.NoError(t, disk.Rotate(2, key(2)))
+		assert.NoError(t, disk.WaitRotated())
+		assert.NoError(t, disk.RemoveKey(1))
+		close(wait)
+		assert.NoError(t, <-errs)
+	})
+
+	t.Run("ParallelReads", func(t *testing.T) {
+		mem := &gateDisk{memDisk: newMemDisk(1 << 10)}
+		disk, err := NewEncryptedDisk(mem, 1, key(1))
+		assert.NoError(t, err)
+		assert.NoError(t, disk.Write(1, []byte("one")))
+		assert.NoError(t, disk.Write(2, []byte("two")))
+
+		// stall a read while it is reading the block from the disk.
+		wait := make(chan struct{})
+		mem.arm(wait)
+		errs := make(chan error, 1)
+		go func() {
+			_, err := disk.Read(1)
+			errs <- err
+		}()
+		for mem.armed() {
+			time.Sleep(time.Millisecond)
+		}
+
+		// other reads are not held up by it.
+		buf, err := disk.Read(2)
+		assert.NoError(t, err)
+		assert.Equal(t, string(buf), "two")
+		select {
+		case err := <-errs:
+			t.Fatalf("stalled read finished first: %v", err)
+		default:
+		}
+		close(wait)
+		assert.NoError(t, <-errs)
+	})
+
+	t.Run("Blocks", func(t *testing.T) {
+		mem := &listDisk{memDisk: newMemDisk(1 << 10)}
+		disk, err := NewEncryptedDisk(mem, 1, key(1))
+		assert.NoError(t, err)
+
+		// only the blocks that exist are rewritten, no matter how large
+		// the largest block is.
+		blocks := []uint32{1, 7, 1 << 20, invalidBlock - 1}
+		for _, block := range blocks {
+			assert.NoError(t, disk.Write(block, numbers[block%numbersSize]))
+		}
+		listed, err := disk.Blocks()
+		assert.NoError(t, err)
+		assert.DeepEqual(t, listed, blocks)
+
+		mem.reads = 0
+		assert.NoError(t, disk.Rotate(2, key(2)))
+		assert.NoError(t, disk.WaitRotated())
+		assert.Equal(t, mem.reads, len(blocks))
+		assert.NoError(t, disk.RemoveKey(1))
+
+		for _, block := range blocks {
+			buf, err := disk.Read(block)
+			assert.NoError(t, err)
+			assert.Equal(t, string(buf), string(numbers[block%numbersSize]))
+		}
+	})
+
+	t.Run("SkipList", func(t *testing.T) {
+		mem := newMemDisk(1 << 15)
+		disk, err := NewEncryptedDisk(mem, 1, key(1))
+		assert.NoError(t, err)
+
+		sl, err := New(newDiskCache(disk))
+		assert.NoError(t, err)
+		for i := 0; i < 500; i++ {
+			assert.NoError(t, sl.Insert([]byte(fmt.Sprint(i)), numbers[i]))
+		}
+		assert.NoError(t, sl.writeNode(sl.root, rootBlock))
+
+		assert.NoError(t, disk.Rotate(2, key(2)))
+		assert.NoError(t, disk.WaitRotated())
+		assert.NoError(t, disk.RemoveKey(1))
+
+		sl, err = New(newDiskCache(disk))
+		assert.NoError(t, err)
+		for i := 0; i < 500; i++ {
+			value, err := sl.Read([]byte(fmt.Sprint(i)))
+			assert.NoError(t, err)
+			assert.Equal(t, string(value), string(numbers[i]))
+		}
+	})
+}
+
+// listDisk is a memDisk that can list its blocks, counting how many blocks
+// it read.
+type listDisk struct {
+	*memDisk
+	reads int
+}
+
+func (l *listDisk) Read(block uint32) ([]byte, error) {
+	l.reads++
+	return l.memDisk.Read(block)
+}
+
+func (l *listDisk) Blocks() ([]uint32, error) {
+	blocks := make([]uint32, 0, len(l.blocks))
+	for block := range l.blocks {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	return blocks, nil
+}
+
+// gateDisk is a memDisk that can stall the next read after it reads the
+// block, until a channel is closed or some time passes.
+type gateDisk struct {
+	*memDisk
+	mu   sync.Mutex
+	wait chan struct{}
+}
+
+func (g *gateDisk) arm(wait chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.wait = wait
+}
+
+func (g *gateDisk) armed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.wait != nil
+}
+
+func (g *gateDisk) Read(block uint32) ([]byte, error) {
+	g.mu.Lock()
+	buf, wait := g.blocks[block], g.wait
+	g.wait = nil
+	g.mu.Unlock()
+
+	if wait != nil {
+		select {
+		case <-wait:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return buf, nil
+}
+
+func (g *gateDisk) Write(block uint32, data []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.memDisk.Write(block, data)
+}
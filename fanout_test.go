@@ -0,0 +1,33 @@
+package wosl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestSetFanout(t *testing.T) {
+	sl, err := New(newMemCache(4 << 10))
+	assert.NoError(t, err)
+	assert.Equal(t, sl.fanout, 127)
+
+	// a fanout of zero is chosen for the block size.
+	assert.NoError(t, sl.SetFanout(0))
+	assert.Equal(t, sl.fanout, 3)
+
+	assert.Error(t, sl.SetFanout(2))
+	assert.NoError(t, sl.SetFanout(4))
+	assert.Equal(t, sl.fanout, 4)
+
+	for i := 0; i < 300; i++ {
+		assert.NoError(t, sl.Insert([]byte(fmt.Sprint(i)), numbers[i]))
+	}
+	assert.That(t, sl.root.Height() > 1)
+
+	for i := 0; i < 300; i++ {
+		value, err := sl.Read([]byte(fmt.Sprint(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, string(value), string(numbers[i]))
+	}
+}
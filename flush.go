@@ -1,8 +1,12 @@
 package wosl
 
 import (
+	"bytes"
+	"sort"
+
 	"github.com/zeebo/wosl/internal/debug"
 	"github.com/zeebo/wosl/internal/node"
+	"github.com/zeebo/wosl/internal/node/entry"
 	"github.com/zeebo/wosl/lease"
 )
 
@@ -19,15 +23,29 @@ func (t *T) flush(n *node.T, block uint32, parents []uint32) (*node.T, []uint32,
 	if err != nil {
 		return nil, nil, Error.Wrap(err)
 	}
+	child.Node().SetCompressor(t.comp)
+	child.Node().SetFanout(t.fanout)
+	child.Node().SetBloomBits(t.bits)
+	child.Node().SetWatermark(n.Watermark())
 
 	var (
 		children = []lease.T{child}
 		splits   []*node.T
 		bulk     node.Bulk
+		drained  [][]byte
+		pending  []pendingRange // range tombstones that may reach later children
+		now      = t.now()
 	)
+	bulk.SetBloomBits(t.bits)
+	bulk.SetFanout(t.fanout)
 
-	// upon exit, clean up leases on the children
+	// upon exit, remove any merge operands and range tombstones that were
+	// moved into a child so that they are never applied twice, along with
+	// any expired entries, and clean up leases on the children
 	defer func() {
+		for _, key := range drained {
+			n.Remove(key)
+		}
 		for _, child := range children {
 			// TODO(jeff): how to handle this error?
 			child.Close()
@@ -41,14 +59,40 @@ func (t *T) flush(n *node.T, block uint32, parents []uint32) (*node.T, []uint32,
 		pivot := ent.Pivot()
 		he := t.height(key)
 
-		// if the entry has a pivot, move to inserting into that child
+		expiry, value, err := splitExpiry(ent, value)
+		if err != nil {
+			return nil, nil, Error.Wrap(err)
+		}
+		dead := ent.Expires() && expiry <= now
+
+		// if the entry has a pivot, move to inserting into that child. the
+		// child being left must not have any keys at or past the pivot.
 		if pivot > 0 {
+			prev := child.Node()
+			debug.Assert("child has keys past the next pivot", func() bool {
+				_, hi, ok := prev.Fences()
+				return pivot == cblock || !ok || bytes.Compare(hi, key) < 0
+			})
+
 			child, err = t.cache.Get(pivot)
 			if err != nil {
 				return nil, nil, Error.Wrap(err)
 			}
+			child.Node().SetCompressor(t.comp)
+			child.Node().SetFanout(t.fanout)
+			child.Node().SetBloomBits(t.bits)
+			child.Node().SetWatermark(n.Watermark())
 			children = append(children, child)
 			cblock = pivot
+
+			// the part of any range tombstone past the pivot belongs to the
+			// new child.
+			pending = livePending(pending, key)
+			for _, pr := range pending {
+				if !child.Node().DeleteRange(key, pr.end, pr.seq) {
+					return nil, nil, Error.New("entry too large to fit")
+				}
+			}
 		}
 
 		// if the node height is <= the entry height, it becomes a pivot
@@ -57,31 +101,65 @@ func (t *T) flush(n *node.T, block uint32, parents []uint32) (*node.T, []uint32,
 			pivot = cblock
 		}
 
-		// TODO(jeff): this api sucks
-		if ent.Tombstone() {
-			if !child.Node().Delete(key) {
-				return nil, nil, Error.New("entry too large to fit")
-			}
-		} else {
-			if !child.Node().Insert(key, value, pivot) {
-				return nil, nil, Error.New("entry too large to fit")
+		// insert every version this node has into the child, oldest first,
+		// so that the child keeps any that may still be read. the pivot is
+		// only meaningful for our copy of the entry: it points at the
+		// child, not into its children.
+		ents, values := iter.Versions()
+		if err := t.push(child.Node(), key, ents, values, now); err != nil {
+			return nil, nil, Error.Wrap(err)
+		}
+
+		// any later children the range tombstones reach are given the rest
+		// of them as they are walked.
+		pending = livePending(pending, key)
+		for i, vent := range ents {
+			if vent.Range() {
+				end := append([]byte(nil), values[i]...)
+				pending = append(pending, pendingRange{end: end, seq: vent.Seq()})
 			}
 		}
+		hidden := false
+		for _, pr := range pending {
+			hidden = hidden || ent.Seq() < pr.seq
+		}
+
+		switch {
+		case ent.Range(), hidden:
+			// range tombstones are drained from this node, along with the
+			// entries they hide, which are only kept in the child.
+			drained = append(drained, append([]byte(nil), key...))
+		case ent.Merge(), dead:
+			// merge operands are drained so that they are only ever applied
+			// once, and expired entries are dropped.
+			drained = append(drained, append([]byte(nil), key...))
+		}
 
 		// perform a split if the entry height is strictly greater
 		if nh < he {
 			fin := bulk.Done(nh)
 			fin.SetPivot(cblock)
+			fin.SetCompressor(t.comp)
 			splits = append(splits, fin)
 			bulk.Reset()
 		}
 
-		bulk.Append(key, value, ent.Tombstone(), pivot)
+		switch {
+		case ent.Merge(), ent.Range(), dead, hidden:
+			// the entry was drained into the child.
+		case ent.Expires():
+			bulk.AppendExpiring(key, value, expiry, ent.Pointer(), pivot, ent.Seq())
+		case ent.Pointer():
+			bulk.AppendPointer(key, value, pivot, ent.Seq())
+		default:
+			bulk.Append(key, value, ent.Tombstone(), pivot, ent.Seq())
+		}
 	}
 
 	// fin is the furthest right node in our splits
 	fin := bulk.Done(nh)
 	fin.SetPivot(cblock)
+	fin.SetCompressor(t.comp)
 	splits = append(splits, fin)
 
 	// set the next pointers and allocate blocks.
@@ -98,6 +176,77 @@ func (t *T) flush(n *node.T, block uint32, parents []uint32) (*node.T, []uint32,
 	return splits[0], flushed, nil
 }
 
+// pendingRange is the end of a range tombstone being flushed, along with
+// its sequence number.
+type pendingRange struct {
+	end []byte
+	seq uint64
+}
+
+// livePending returns the range tombstones that extend past the key, oldest
+// first, so that newer ones are given to a child after older ones.
+func livePending(pending []pendingRange, key []byte) []pendingRange {
+	live := pending[:0]
+	for _, pr := range pending {
+		if bytes.Compare(key, pr.end) < 0 {
+			live = append(live, pr)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].seq < live[j].seq })
+	return live
+}
+
+// push inserts the versions of an entry from a node being flushed into the
+// child, oldest first. Merge operands only ever come before any other kind
+// of version, and each includes the ones before it, so they are all
+// combined with what the child had before the first of them.
+func (t *T) push(child *node.T, key []byte, ents []entry.T, values [][]byte, now int64) error {
+	var base mergeBase
+	if ents[0].Merge() {
+		seq := ents[0].Seq()
+		if seq > 0 {
+			seq--
+		}
+		var err error
+		if base, err = t.baseOf(child, key, seq); err != nil {
+			return Error.Wrap(err)
+		}
+	}
+
+	for i, ent := range ents {
+		expiry, value, err := splitExpiry(ent, values[i])
+		if err != nil {
+			return Error.Wrap(err)
+		}
+
+		// TODO(jeff): this api sucks
+		var wrote bool
+		switch {
+		case ent.Merge():
+			if err := t.mergeOnto(child, key, base, value, ent.Seq()); err != nil {
+				return Error.Wrap(err)
+			}
+			wrote = true
+		case ent.Range():
+			wrote = child.DeleteRange(key, value, ent.Seq())
+		case ent.Tombstone(), ent.Expires() && expiry <= now:
+			// expired entries leave a tombstone in the child so that they
+			// still hide any older entries.
+			wrote = child.Delete(key, ent.Seq())
+		case ent.Expires():
+			wrote = child.InsertExpiring(key, value, expiry, ent.Pointer(), 0, ent.Seq())
+		case ent.Pointer():
+			wrote = child.InsertPointer(key, value, 0, ent.Seq())
+		default:
+			wrote = child.Insert(key, value, 0, ent.Seq())
+		}
+		if !wrote {
+			return Error.New("entry too large to fit")
+		}
+	}
+	return nil
+}
+
 func (t *T) rebalance(n *node.T, block uint32, parents []uint32) error {
 	debug.Assert("rebalance on height 1", func() bool { return n.Height() == 1 })
 
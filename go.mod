@@ -1,5 +1,7 @@
 module github.com/zeebo/wosl
 
+go 1.17
+
 require (
 	github.com/cespare/xxhash v1.1.0
 	github.com/zeebo/assert v0.0.0-20181109011804-10f827ce2ed6
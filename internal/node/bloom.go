@@ -0,0 +1,68 @@
+package node
+
+import (
+	"github.com/cespare/xxhash"
+)
+
+// bloom is a Bloom filter over the keys of a node with the prefix of the
+// node removed. The first byte is how many bits are probed for each key,
+// and the rest are the bits.
+type bloom []byte
+
+// bloomLength returns how many bytes a Bloom filter for count keys with
+// bits bits per key takes up. It is zero if either is zero.
+func bloomLength(count uint32, bits uint8) uint64 {
+	if count == 0 || bits == 0 {
+		return 0
+	}
+	return 1 + (uint64(count)*uint64(bits)+7)/8
+}
+
+// newBloom returns an empty Bloom filter with bits bits per key that uses
+// buf, which must be at least 2 bytes long.
+func newBloom(buf []byte, bits uint8) bloom {
+	for i := range buf {
+		buf[i] = 0
+	}
+
+	// ln(2) * bits per key probes minimizes the false positive rate.
+	probes := uint(bits) * 69 / 100
+	if probes < 1 {
+		probes = 1
+	} else if probes > 30 {
+		probes = 30
+	}
+	buf[0] = byte(probes)
+
+	return bloom(buf)
+}
+
+// add sets the bits for the key.
+func (b bloom) add(key []byte) {
+	bits := uint64(len(b)-1) * 8
+	h := xxhash.Sum64(key)
+	delta := h>>33 | h<<31
+	for i := byte(0); i < b[0]; i++ {
+		pos := h % bits
+		b[1+pos/8] |= 1 << (pos % 8)
+		h += delta
+	}
+}
+
+// mayContain returns false if the key was definitely never added.
+func (b bloom) mayContain(key []byte) bool {
+	if len(b) < 2 {
+		return true
+	}
+	bits := uint64(len(b)-1) * 8
+	h := xxhash.Sum64(key)
+	delta := h>>33 | h<<31
+	for i := byte(0); i < b[0]; i++ {
+		pos := h % bits
+		if b[1+pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
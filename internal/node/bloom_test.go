@@ -0,0 +1,34 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestBloom(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		b := newBloom(make([]byte, bloomLength(1000, 10)), 10)
+		for i := 0; i < 1000; i++ {
+			b.add([]byte(fmt.Sprint("in", i)))
+		}
+		for i := 0; i < 1000; i++ {
+			assert.That(t, b.mayContain([]byte(fmt.Sprint("in", i))))
+		}
+
+		positives := 0
+		for i := 0; i < 10000; i++ {
+			if b.mayContain([]byte(fmt.Sprint("out", i))) {
+				positives++
+			}
+		}
+		assert.That(t, positives < 300)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		assert.Equal(t, bloomLength(0, 10), 0)
+		assert.Equal(t, bloomLength(10, 0), 0)
+		assert.That(t, bloom(nil).mayContain([]byte("key")))
+	})
+}
@@ -3,7 +3,6 @@ package btree
 import (
 	"bytes"
 	"encoding/binary"
-	"unsafe"
 
 	"github.com/zeebo/errs"
 	"github.com/zeebo/mon"
@@ -15,19 +14,40 @@ var Error = errs.Class("btree")
 
 // btree is an in memory B+ tree tuned to store entries
 type T struct {
-	root  *node
-	rid   uint32
-	count uint32
-	nodes []*node
+	root   *node
+	rid    uint32
+	count  uint32
+	nodes  []*node
+	fanout uint16 // entries per node, or 0 for the default
 }
 
-// Reset clears the btree back to an empty state
+// Reset clears the btree back to an empty state, keeping the fanout.
 func (b *T) Reset() {
-	*b = T{}
+	*b = T{fanout: b.fanout}
+}
+
+// Fanout returns how many entries a node in the btree has room for.
+func (b *T) Fanout() uint16 {
+	if b.fanout == 0 {
+		return DefaultFanout
+	}
+	return b.fanout
+}
+
+// SetFanout sets how many entries a node in the btree has room for. It has
+// no effect once any entries have been inserted. Values below MinFanout use
+// the default.
+func (b *T) SetFanout(fanout uint16) {
+	if len(b.nodes) > 0 {
+		return
+	} else if fanout < MinFanout {
+		fanout = 0
+	}
+	b.fanout = fanout
 }
 
 // search returns the leaf node that should contain the key.
-func (b *T) search(key, buf []byte) (*node, uint32) {
+func (b *T) search(key []byte, buf entry.Buffer) (*node, uint32) {
 	var prefixBytes [4]byte
 	copy(prefixBytes[:], key)
 	prefix := binary.BigEndian.Uint32(prefixBytes[:])
@@ -48,7 +68,7 @@ func (b *T) search(key, buf []byte) (*node, uint32) {
 			case 1:
 				i = h + 1
 			case 0:
-				kh := enth.ReadKey(buf)
+				kh := buf.Key(enth)
 				if bytes.Compare(key, kh) >= 0 {
 					i = h + 1
 				} else {
@@ -72,7 +92,7 @@ func (b *T) search(key, buf []byte) (*node, uint32) {
 
 // alloc creates a fresh node.
 func (b *T) alloc(leaf bool) (*node, uint32) {
-	n := new(node)
+	n := newNode(b.Fanout())
 	n.leaf = leaf
 	n.next = invalidNode
 	n.prev = invalidNode
@@ -92,16 +112,17 @@ func (b *T) split(n *node, nid uint32) (*node, uint32) {
 	s.parent = n.parent
 
 	// split the entries between the two nodes
-	s.count = uint16(copy(s.payload[:], n.payload[:payloadSplit]))
+	split := b.Fanout() / 2
+	s.count = uint16(copy(s.payload, n.payload[:split]))
 
-	copyAt := payloadSplit
+	copyAt := split
 	if !n.leaf {
 		// if it's not a leaf, we don't want to include the split entry
 		copyAt++
 
 		// additionally, the next pointer should be what the split entry
 		// points at.
-		s.next = n.payload[payloadSplit].Pivot()
+		s.next = n.payload[split].Pivot()
 
 		// additionally, every element that it points at needs to have
 		// their parent updated
@@ -118,7 +139,7 @@ func (b *T) split(n *node, nid uint32) (*node, uint32) {
 		}
 		n.prev = sid
 	}
-	n.count = uint16(copy(n.payload[:], n.payload[copyAt:]))
+	n.count = uint16(copy(n.payload, n.payload[copyAt:n.count]))
 
 	timer.Stop()
 	return s, sid
@@ -127,8 +148,15 @@ func (b *T) split(n *node, nid uint32) (*node, uint32) {
 // Insert puts the entry into the btree, using the buf to read keys
 // to determine the position. It returns true if the insert created
 // a new entry.
-func (b *T) Insert(ent entry.T, buf []byte) bool {
-	key := ent.ReadKey(buf)
+func (b *T) Insert(ent entry.T, buf entry.Buffer) bool {
+	_, replaced := b.Replace(ent, buf)
+	return !replaced
+}
+
+// Replace puts the entry into the btree like Insert, but returns the entry
+// that it overwrote, if any. It returns true if an entry was overwritten.
+func (b *T) Replace(ent entry.T, buf entry.Buffer) (entry.T, bool) {
+	key := buf.Key(ent)
 
 	// easy case: if we have no root, we can just allocate it
 	// and insert the entry.
@@ -136,71 +164,29 @@ func (b *T) Insert(ent entry.T, buf []byte) bool {
 		b.root, b.rid = b.alloc(true)
 		b.root.insertEntry(key, ent, buf)
 		b.count++
-		return true
+		return entry.T{}, false
 	}
 
 	// search for the leaf that should contain the node
 	n, nid := b.search(key, buf)
 	for {
-		added := n.insertEntry(key, ent, buf)
+		old, added := n.insertEntry(key, ent, buf)
 		if added && n.leaf {
 			b.count++
 		}
 
-		// easy case: if the node still has enough room, we're done.
-		if n.count < payloadEntries {
-			return added
-		}
-
-		// update the entry we're going to insert to be the entry we're
-		// splitting the node on.
-		ent = n.payload[payloadSplit]
-
-		// split the node. s is a new node that contains keys
-		// smaller than the splitEntry.
-		s, sid := b.split(n, nid)
-
-		// find the parent, allocating a new node if we're looking
-		// at the root, and set the parent of the split node.
-		var p *node
-		var pid uint32
-		if n.parent != invalidNode {
-			p, pid = b.nodes[n.parent], n.parent
-		} else {
-			// create a new parent node, and make it point at the
-			// larger side of the split node for it's next pointer.
-			p, pid = b.alloc(false)
-			p.next = nid
-			n.parent = pid
-			s.parent = pid
-
-			// store it as the root
-			b.root, b.rid = p, pid
-		}
-
-		// make a pointer out of the split entry to point at the
-		// newly split node, and try to insert it.
-		ent.SetPivot(sid)
-		n, nid = p, pid
-	}
-}
-
-// append adds the entry to the node, splitting if necessary. the entry must
-// be greater than any entry already in the node. n remains to the right of
-// and at least as low than any newly created nodes.
-func (b *T) append(n *node, nid uint32, ent entry.T) {
-	for {
-		n.appendEntry(ent)
-		b.count++
-
-		// easy case: if the node still has enough room, we're done.
-		if n.count < payloadEntries {
-			return
+		// easy case: if the node still has enough room, we're done. an
+		// overwrite never changes the count, so it always ends up here.
+		if n.count < b.Fanout() {
+			return old, !added
 		}
 
 		// update the entry we're going to insert to be the entry we're
-		// splitting the node on.
-		ent = n.payload[payloadSplit]
+		// splitting the node on. the key has to be updated too: a deleted
+		// key can still be in the parent as a separator, and positioning
+		// the split entry with it would overwrite that separator.
+		ent = n.payload[b.Fanout()/2]
+		key = buf.Key(ent)
 
 		// split the node. s is a new node that contains keys
 		// smaller than the splitEntry.
@@ -259,6 +245,40 @@ func (b *T) Iter(cb func(ent *entry.T) bool) {
 	}
 }
 
+// Resync updates the entries in the inner nodes of the btree to match the
+// leaf entries they separate. It must be called after the offsets or keys
+// of any leaf entries are changed, as through Iter.
+func (b *T) Resync() {
+	if b.root != nil {
+		b.resync(b.root)
+	}
+}
+
+// resync updates the inner entries of the subtree at n, returning the leftmost
+// leaf entry in it.
+func (b *T) resync(n *node) entry.T {
+	if n.leaf {
+		return n.payload[0]
+	} else if n.count == 0 {
+		return b.resync(b.nodes[n.next])
+	}
+
+	// every inner entry has the same key as the leftmost leaf entry of the
+	// subtree to the right of it.
+	left := b.resync(b.nodes[n.payload[0].Pivot()])
+	for i := uint16(0); i < n.count; i++ {
+		right := n.next
+		if i+1 < n.count {
+			right = n.payload[i+1].Pivot()
+		}
+
+		ent := b.resync(b.nodes[right])
+		ent.SetPivot(n.payload[i].Pivot())
+		n.payload[i] = ent
+	}
+	return left
+}
+
 func (b *T) Iterator() Iterator {
 	// find the deepest leftmost node
 	n := b.root
@@ -286,10 +306,13 @@ const HeaderSize = 0 +
 	4 + // root id
 	4 + // number of entries
 	4 + // number of nodes
+	4 + // fanout
 	0
 
 // Length returns how many bytes writing out the btree would take
-func (b *T) Length() uint64 { return HeaderSize + NodeSize*uint64(len(b.nodes)) }
+func (b *T) Length() uint64 {
+	return HeaderSize + nodeSize(b.Fanout())*uint64(len(b.nodes))
+}
 
 // Count returns how many entries are in the btree.
 func (b *T) Count() uint32 { return b.count }
@@ -307,12 +330,14 @@ func (b *T) Write(buf []byte) []byte {
 	binary.LittleEndian.PutUint32(buf[0:4], b.rid)
 	binary.LittleEndian.PutUint32(buf[4:8], uint32(b.count))
 	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(b.nodes)))
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(b.Fanout()))
 
+	size := nodeSize(b.Fanout())
 	w := buf[HeaderSize:]
 	for _, n := range b.nodes {
 		// TODO(jeff): check how expensive encoding/binary is.
-		*(*node)(unsafe.Pointer(&w[0])) = *n
-		w = w[NodeSize:]
+		n.store(w)
+		w = w[size:]
 	}
 
 	return buf
@@ -321,37 +346,29 @@ func (b *T) Write(buf []byte) []byte {
 // Load loads up a btree from the provided buffer. it continues to use
 // the buffer as a backing store until it must grow.
 func Load(buf []byte) (T, error) {
-	if len(buf) < HeaderSize {
-		return T{}, Error.New("buffer too small for btree")
-	}
-
-	var (
-		rid    = binary.LittleEndian.Uint32(buf[0:4])
-		count  = binary.LittleEndian.Uint32(buf[4:8])
-		ncount = binary.LittleEndian.Uint32(buf[8:12])
-	)
-
-	if uint32(rid) >= ncount {
-		return T{}, Error.New("root id out of range. root:%d count:%d",
-			rid, ncount)
+	h, err := ReadHeader(buf)
+	if err != nil {
+		return T{}, err
 	}
-	if uint64(len(buf)) < HeaderSize+uint64(NodeSize)*uint64(ncount) {
+	size := nodeSize(h.Fanout)
+	if uint64(len(buf)) < HeaderSize+size*uint64(h.Nodes) {
 		return T{}, Error.New("buffer too small for %d nodes: %d",
-			ncount, len(buf))
+			h.Nodes, len(buf))
 	}
 
 	r := buf[HeaderSize:]
-	nodes := make([]*node, ncount)
+	nodes := make([]*node, h.Nodes)
 	for i := range nodes {
 		// TODO(jeff): check how expensive encoding/binary is.
-		nodes[i] = (*node)(unsafe.Pointer(&r[0]))
-		r = r[NodeSize:]
+		nodes[i] = loadNode(r, h.Fanout)
+		r = r[size:]
 	}
 
 	return T{
-		root:  nodes[rid],
-		rid:   rid,
-		count: count,
-		nodes: nodes,
+		root:   nodes[h.Root],
+		rid:    h.Root,
+		count:  h.Count,
+		nodes:  nodes,
+		fanout: h.Fanout,
 	}, nil
 }
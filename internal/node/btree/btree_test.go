@@ -22,6 +22,7 @@ func TestBtree(t *testing.T) {
 		}
 
 		assert.Equal(t, bt.count, len(set))
+		checkInvariants(t, &bt, entry.Buffer{Loaded: buf})
 
 		last := ""
 		bt.Iter(func(ent *entry.T) bool {
@@ -50,7 +51,7 @@ func TestBtree(t *testing.T) {
 			entries[i], entries[j] = entries[j], entries[i]
 		})
 		for _, ent := range entries {
-			bt.Insert(ent, buf)
+			bt.Insert(ent, entry.Buffer{Loaded: buf})
 		}
 
 		assert.Equal(t, bt.count, len(set))
@@ -96,14 +97,111 @@ func TestBtree(t *testing.T) {
 		})
 	})
 
-	t.Run("Bugs", func(t *testing.T) {
-		if payloadEntries != 3 {
-			t.Skip("Test requires payloadEntries to be 3")
+	t.Run("Resync", func(t *testing.T) {
+		var set = map[string]bool{}
+		var buf []byte
+		var bt T
+
+		for i := 0; i < 10000; i++ {
+			d := string(numbers[gen.Intn(numbersSize)&numbersMask])
+			set[d] = true
+			bt.Insert(appendEntry(&buf, d, ""))
+		}
+
+		// move every key to a new buffer in sorted order, like a compaction.
+		var compact []byte
+		bt.Iter(func(ent *entry.T) bool {
+			offset := uint32(len(compact))
+			compact = append(compact, ent.ReadEntry(buf)...)
+			ent.SetOffset(offset)
+			return true
+		})
+		bt.Resync()
+
+		// inserting every key again should find the existing entries.
+		for d := range set {
+			ent, _ := appendEntry(&compact, d, "")
+			assert.That(t, !bt.Insert(ent, entry.Buffer{Loaded: compact}))
+		}
+		assert.Equal(t, bt.count, len(set))
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		var buf []byte
+		var bt T
+
+		for i := 0; i < 1000; i++ {
+			_, ok := bt.Replace(appendEntry(&buf, fmt.Sprintf("%04d", i), "a"))
+			assert.That(t, !ok)
+		}
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("%04d", i)
+			old, ok := bt.Replace(appendEntry(&buf, key, "bb"))
+			assert.That(t, ok)
+			assert.Equal(t, string(old.ReadKey(buf)), key)
+			assert.Equal(t, string(old.ReadValue(buf)), "a")
 		}
+		assert.Equal(t, bt.Count(), 1000)
+	})
+
+	t.Run("Fanout", func(t *testing.T) {
+		for _, fanout := range []uint16{MinFanout, 4, 16, DefaultFanout, 1000} {
+			var set = map[string]bool{}
+			var buf []byte
+			var bt T
+			bt.SetFanout(fanout)
 
+			for i := 0; i < 10000; i++ {
+				d := string(numbers[gen.Intn(numbersSize)&numbersMask])
+				set[d] = true
+				bt.Insert(appendEntry(&buf, d, ""))
+			}
+			assert.Equal(t, bt.Fanout(), fanout)
+			assert.Equal(t, bt.count, len(set))
+			checkInvariants(t, &bt, entry.Buffer{Loaded: buf})
+
+			// delete half of the keys
+			for d := range set {
+				if len(set)%2 == 0 {
+					assert.That(t, bt.Delete([]byte(d), entry.Buffer{Loaded: buf}))
+					delete(set, d)
+				}
+			}
+			assert.Equal(t, bt.count, len(set))
+			checkInvariants(t, &bt, entry.Buffer{Loaded: buf})
+
+			// the fanout should survive a round trip
+			data := bt.Write(nil)
+			assert.Equal(t, uint64(len(data)), HeaderSize+nodeSize(fanout)*uint64(len(bt.nodes)))
+			lt, err := Load(data)
+			assert.NoError(t, err)
+			assert.Equal(t, lt.Fanout(), fanout)
+			checkInvariants(t, &lt, entry.Buffer{Loaded: buf})
+
+			// and bulk loading should use it as well
+			var bu Bulk
+			bu.SetFanout(fanout)
+			lt.Iter(func(ent *entry.T) bool {
+				bu.Append(*ent)
+				return true
+			})
+			bt = bu.Done()
+			assert.Equal(t, bt.Fanout(), fanout)
+			assert.Equal(t, bt.count, len(set))
+			checkInvariants(t, &bt, entry.Buffer{Loaded: buf})
+		}
+
+		// small fanouts use the default
+		var bt T
+		bt.SetFanout(MinFanout - 1)
+		assert.Equal(t, bt.Fanout(), DefaultFanout)
+	})
+
+	t.Run("Bugs", func(t *testing.T) {
 		t.Run("One", func(t *testing.T) {
 			var buf []byte
 			var bt T
+			bt.SetFanout(3)
 
 			bt.Insert(appendEntry(&buf, "A", ""))
 			bt.Insert(appendEntry(&buf, "F", ""))
@@ -120,6 +218,7 @@ func TestBtree(t *testing.T) {
 		t.Run("Two", func(t *testing.T) {
 			var buf []byte
 			var bt T
+			bt.SetFanout(3)
 
 			bt.Insert(appendEntry(&buf, "A", ""))
 			bt.Insert(appendEntry(&buf, "F", ""))
@@ -151,7 +250,7 @@ func BenchmarkBtree(b *testing.B) {
 			b.ResetTimer()
 
 			for i := 0; i < b.N; i++ {
-				bt.Insert(ents[i], buf)
+				bt.Insert(ents[i], entry.Buffer{Loaded: buf})
 			}
 		})
 
@@ -169,7 +268,7 @@ func BenchmarkBtree(b *testing.B) {
 			b.ResetTimer()
 
 			for i := 0; i < b.N; i++ {
-				bt.Insert(ents[i], buf)
+				bt.Insert(ents[i], entry.Buffer{Loaded: buf})
 			}
 		})
 	})
@@ -182,7 +281,7 @@ func BenchmarkBtree(b *testing.B) {
 			for i := 0; i < n; i++ {
 				key := string(numbers[gen.Intn(numbersSize)&numbersMask])
 				ent, _ := appendEntry(&buf, key, "")
-				bt.Insert(ent, buf)
+				bt.Insert(ent, entry.Buffer{Loaded: buf})
 			}
 			out := bt.Write(nil)
 
@@ -209,7 +308,7 @@ func BenchmarkBtree(b *testing.B) {
 			for i := 0; i < n; i++ {
 				key := string(numbers[gen.Intn(numbersSize)&numbersMask])
 				ent, _ := appendEntry(&buf, key, "")
-				bt.Insert(ent, buf)
+				bt.Insert(ent, entry.Buffer{Loaded: buf})
 			}
 			out := bt.Write(nil)
 
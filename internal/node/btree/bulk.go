@@ -2,22 +2,103 @@ package btree
 
 import "github.com/zeebo/wosl/internal/node/entry"
 
+// DefaultFillFactor is the fill factor used by a Bulk if none is set. It
+// packs every node as full as it can be while still allowing an insert.
+const DefaultFillFactor = 1.0
+
 // Bulk allows bulk loading of entries into a btree. they must
-// be appended in strictly ascending order.
+// be appended in strictly ascending order. nodes are filled to the
+// fill factor before a new node is started, rather than split in half.
 type Bulk struct {
-	b   T
-	n   *node
-	nid uint32
+	b      T
+	factor float64  // fraction of the maximum entries to put in a node
+	spine  []uint32 // rightmost node at every level, starting at the leaves
+}
+
+// SetFillFactor sets the fraction of the entries a node can hold that
+// are added to it before starting a new one. It must be called before
+// any calls to Append. Values outside of (0, 1] use the default.
+func (b *Bulk) SetFillFactor(factor float64) { b.factor = factor }
+
+// SetFanout sets how many entries a node in the btree has room for. It has
+// no effect after any calls to Append. Values below MinFanout use the
+// default.
+func (b *Bulk) SetFanout(fanout uint16) { b.b.SetFanout(fanout) }
+
+// limit returns how many entries to put in a node before starting a new
+// one. nodes are never completely full so that an insert can happen
+// without overflowing the payload before the node is split.
+func (b *Bulk) limit() uint16 {
+	factor := b.factor
+	if factor <= 0 || factor > 1 {
+		factor = DefaultFillFactor
+	}
+	limit := uint16(factor * float64(b.b.Fanout()-1))
+	if limit < 1 {
+		limit = 1
+	}
+	return limit
 }
 
 // Append cheaply adds the entry to the btree. it must be strictly
 // greater than any earlier entry.
 func (b *Bulk) Append(ent entry.T) {
-	if b.n == nil {
-		b.n, b.nid = b.b.alloc(true)
-		b.b.root, b.b.rid = b.n, b.nid
+	if len(b.spine) == 0 {
+		n, nid := b.b.alloc(true)
+		b.b.root, b.b.rid = n, nid
+		b.spine = append(b.spine, nid)
+	}
+
+	nid := b.spine[0]
+	n := b.b.nodes[nid]
+
+	// if the leaf is full, start a new one and add the entry as the
+	// separator between them into the level above.
+	if n.count >= b.limit() {
+		s, sid := b.b.alloc(true)
+		s.prev = nid
+		n.next = sid
+		b.spine[0] = sid
+		b.push(1, ent, nid, sid)
+		n = s
+	}
+
+	n.appendEntry(ent)
+	b.b.count++
+}
+
+// push adds the separator between the left node, which is the current
+// rightmost node one level below, and the newly allocated right node into
+// the rightmost node at the level, creating new nodes as necessary.
+func (b *Bulk) push(level int, ent entry.T, left, right uint32) {
+	// if there is no level, create a new root above the left node.
+	if level == len(b.spine) {
+		p, pid := b.b.alloc(false)
+		p.next = left
+		b.b.nodes[left].parent = pid
+		b.b.root, b.b.rid = p, pid
+		b.spine = append(b.spine, pid)
 	}
-	b.b.append(b.n, b.nid, ent)
+
+	pid := b.spine[level]
+	p := b.b.nodes[pid]
+
+	// if the node is full, start a new one pointing at the right node,
+	// and move the separator up a level instead. the full node keeps the
+	// left node as its rightmost edge.
+	if p.count >= b.limit() {
+		q, qid := b.b.alloc(false)
+		q.next = right
+		b.b.nodes[right].parent = qid
+		b.spine[level] = qid
+		b.push(level+1, ent, pid, qid)
+		return
+	}
+
+	ent.SetPivot(left)
+	p.appendEntry(ent)
+	p.next = right
+	b.b.nodes[right].parent = pid
 }
 
 // Length returns how many bytes a btree would be.
@@ -14,12 +14,12 @@ func TestBulk(t *testing.T) {
 		var buf []byte
 
 		for i := 0; i < 1000; i++ {
-			var ent entry.T
-			ent, buf = appendEntry(&buf, fmt.Sprintf("%04d", i), "")
+			ent, _ := appendEntry(&buf, fmt.Sprintf("%04d", i), "")
 			bu.Append(ent)
 		}
 
 		bt := bu.Done()
+		assert.Equal(t, bt.Count(), 1000)
 
 		i := 0
 		bt.Iter(func(ent *entry.T) bool {
@@ -44,6 +44,52 @@ func TestBulk(t *testing.T) {
 		})
 	})
 
+	t.Run("FillFactor", func(t *testing.T) {
+		run := func(t *testing.T, factor float64, count int) T {
+			var bu Bulk
+			var buf []byte
+			var data entry.Buffer
+
+			bu.SetFillFactor(factor)
+			for i := 0; i < count; i++ {
+				var ent entry.T
+				ent, data = appendEntry(&buf, fmt.Sprintf("%08d", 2*i), "")
+				bu.Append(ent)
+			}
+			bt := bu.Done()
+			assert.Equal(t, bt.Count(), count)
+			checkInvariants(t, &bt, data)
+
+			// the btree must still accept inserts everywhere.
+			for i := 0; i < count; i += 7 {
+				bt.Insert(appendEntry(&buf, fmt.Sprintf("%08d", 2*i+1), ""))
+			}
+			_, data = appendEntry(&buf, "", "")
+			checkInvariants(t, &bt, data)
+
+			return bt
+		}
+
+		for _, count := range []int{1, 126, 127, 1000, 20000} {
+			for _, factor := range []float64{0, 0.5, 0.1, 1e-9} {
+				run(t, factor, count)
+			}
+		}
+
+		// by default, the leaves should be packed as full as possible.
+		var bu Bulk
+		var buf []byte
+		for i := 0; i < 1000; i++ {
+			ent, _ := appendEntry(&buf, fmt.Sprintf("%08d", i), "")
+			bu.Append(ent)
+		}
+		bt := bu.Done()
+		leaves := (1000 + DefaultFanout - 2) / (DefaultFanout - 1)
+		assert.Equal(t, len(bt.nodes), leaves+1)
+		half := run(t, 0.5, 1000)
+		assert.That(t, bt.Length() < half.Length())
+	})
+
 	t.Run("Zero", func(t *testing.T) {
 		var bu Bulk
 		bt := bu.Done()
@@ -1,9 +1,12 @@
 package btree
 
 import (
+	"bytes"
 	"fmt"
+	"testing"
 	"time"
 
+	"github.com/zeebo/assert"
 	"github.com/zeebo/wosl/internal/node/entry"
 	"github.com/zeebo/wosl/internal/pcg"
 )
@@ -30,9 +33,77 @@ func init() {
 	}
 }
 
-func appendEntry(buf *[]byte, key, value string) (entry.T, []byte) {
+func appendEntry(buf *[]byte, key, value string) (entry.T, entry.Buffer) {
 	ent := entry.New([]byte(key), []byte(value), false, uint32(len(*buf)))
 	*buf = append(*buf, key...)
 	*buf = append(*buf, value...)
-	return ent, *buf
+	return ent, entry.Buffer{Loaded: *buf}
+}
+
+// checkInvariants asserts that the structure of the btree is consistent:
+// every leaf is at the same depth, keys are sorted and bounded by the inner
+// entries above them, and the parent, next and prev links all agree.
+func checkInvariants(t testing.TB, b *T, buf entry.Buffer) {
+	t.Helper()
+
+	if b.root == nil {
+		assert.Equal(t, b.count, 0)
+		return
+	}
+	assert.That(t, b.nodes[b.rid] == b.root)
+	assert.Equal(t, b.root.parent, invalidNode)
+
+	var leaves []uint32
+	var count uint32
+	depth := -1
+
+	var walk func(nid uint32, lo, hi []byte, d int)
+	walk = func(nid uint32, lo, hi []byte, d int) {
+		n := b.nodes[nid]
+		assert.Equal(t, len(n.payload), b.Fanout())
+		assert.That(t, n.count < b.Fanout())
+
+		if n.leaf {
+			if depth == -1 {
+				depth = d
+			}
+			assert.Equal(t, d, depth)
+			leaves = append(leaves, nid)
+			count += uint32(n.count)
+
+			for i := uint16(0); i < n.count; i++ {
+				key := buf.Key(n.payload[i])
+				assert.That(t, lo == nil || bytes.Compare(key, lo) >= 0)
+				assert.That(t, hi == nil || bytes.Compare(key, hi) < 0)
+				assert.That(t, i == 0 || bytes.Compare(buf.Key(n.payload[i-1]), key) < 0)
+			}
+			return
+		}
+
+		for i := uint16(0); i <= n.count; i++ {
+			child, clo, chi := n.next, lo, hi
+			if i > 0 {
+				clo = buf.Key(n.payload[i-1])
+			}
+			if i < n.count {
+				child, chi = n.payload[i].Pivot(), buf.Key(n.payload[i])
+			}
+			assert.Equal(t, b.nodes[child].parent, nid)
+			walk(child, clo, chi, d+1)
+		}
+	}
+	walk(b.rid, nil, nil, 0)
+	assert.Equal(t, count, b.count)
+
+	for i, nid := range leaves {
+		prev, next := uint32(invalidNode), uint32(invalidNode)
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		}
+		assert.Equal(t, b.nodes[nid].prev, prev)
+		assert.Equal(t, b.nodes[nid].next, next)
+	}
 }
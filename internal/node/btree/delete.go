@@ -0,0 +1,252 @@
+package btree
+
+import (
+	"sort"
+
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// minimum returns the fewest entries a non-root node can have after a
+// delete before it borrows from or merges with a sibling. it is low enough
+// that a merged node always has room for an insert.
+func (b *T) minimum() uint16 {
+	if min := b.Fanout() / 4; min > 1 {
+		return min
+	}
+	return 1
+}
+
+var deleteThunk mon.Thunk
+
+// Delete removes the entry with the key from the btree, using the buf to
+// read keys. It returns true if an entry was removed.
+func (b *T) Delete(key []byte, buf entry.Buffer) bool {
+	_, ok := b.Remove(key, buf)
+	return ok
+}
+
+// Remove removes the entry with the key from the btree like Delete, but
+// returns the entry that it removed, if any.
+func (b *T) Remove(key []byte, buf entry.Buffer) (entry.T, bool) {
+	timer := deleteThunk.Start()
+
+	if b.root == nil {
+		timer.Stop()
+		return entry.T{}, false
+	}
+
+	n, nid := b.search(key, buf)
+	i, ok := n.find(key, buf)
+	if !ok {
+		timer.Stop()
+		return entry.T{}, false
+	}
+	old := n.payload[i]
+	n.removeAt(i)
+	b.count--
+
+	// rebalance up the tree, remembering the nodes that are no longer
+	// referenced so that they can be removed once nothing else moves.
+	freed := b.fix(nid, nil)
+
+	// if the root is an inner node with a single child, the child becomes
+	// the root.
+	for !b.root.leaf && b.root.count == 0 {
+		freed = append(freed, b.rid)
+		b.rid = b.root.next
+		b.root = b.nodes[b.rid]
+		b.root.parent = invalidNode
+	}
+
+	// free in descending order so that moving the last node into a freed
+	// slot never moves another node that is about to be freed.
+	sort.Slice(freed, func(i, j int) bool { return freed[i] > freed[j] })
+	for _, id := range freed {
+		b.free(id)
+	}
+
+	timer.Stop()
+	return old, true
+}
+
+// fix rebalances the node if it is underfull, and then its ancestors. it
+// returns freed with the id of any node that was merged away appended.
+func (b *T) fix(nid uint32, freed []uint32) []uint32 {
+	n := b.nodes[nid]
+	if n.parent == invalidNode || n.count >= b.minimum() {
+		return freed
+	}
+
+	// if the parent has no other children, fix the parent first so that
+	// it does. that can move the node to a different parent.
+	if p := b.nodes[n.parent]; p.count == 0 {
+		freed = b.fix(n.parent, freed)
+		if p = b.nodes[n.parent]; p.count == 0 {
+			return freed
+		}
+	}
+
+	pid := n.parent
+	p := b.nodes[pid]
+	freed = b.rebalance(p, b.childIndex(p, nid), freed)
+	return b.fix(pid, freed)
+}
+
+// childIndex returns the index of the child in the inner node. the index
+// is the count of the node if the child is the rightmost edge.
+func (b *T) childIndex(p *node, cid uint32) uint16 {
+	for i := uint16(0); i < p.count; i++ {
+		if p.payload[i].Pivot() == cid {
+			return i
+		}
+	}
+	return p.count
+}
+
+// child returns the child at the index in the inner node.
+func (p *node) child(i uint16) uint32 {
+	if i == p.count {
+		return p.next
+	}
+	return p.payload[i].Pivot()
+}
+
+// setChild updates the child at the index in the inner node.
+func (p *node) setChild(i uint16, cid uint32) {
+	if i == p.count {
+		p.next = cid
+	} else {
+		p.payload[i].SetPivot(cid)
+	}
+}
+
+// rebalance fixes the underfull child at index i of the inner node p by
+// borrowing an entry from a sibling or merging with it. it returns freed
+// with the id of any node that was merged away appended.
+func (b *T) rebalance(p *node, i uint16, freed []uint32) []uint32 {
+	// pick a sibling, preferring the left one. s is the index of the
+	// entry in the parent that separates the left and right nodes.
+	s := i
+	if i > 0 {
+		s = i - 1
+	}
+	lid, rid := p.child(s), p.child(s+1)
+	l, r := b.nodes[lid], b.nodes[rid]
+
+	switch {
+	case s < i && l.count > b.minimum():
+		b.borrowLeft(p, s, l, r, rid)
+	case s == i && r.count > b.minimum():
+		b.borrowRight(p, s, l, r, lid)
+	default:
+		b.merge(p, s, l, r, lid)
+		freed = append(freed, rid)
+	}
+
+	return freed
+}
+
+// borrowLeft moves the last entry of the left node into the right node,
+// which are separated by the entry at index s in the parent.
+func (b *T) borrowLeft(p *node, s uint16, l, r *node, rid uint32) {
+	last := l.payload[l.count-1]
+	l.count--
+
+	if r.leaf {
+		r.insertAt(0, last)
+		p.payload[s] = withPivot(last, p.payload[s].Pivot())
+		return
+	}
+
+	// the separator comes down to point at the left node's rightmost edge,
+	// and the left node's last entry goes up to become the separator.
+	r.insertAt(0, withPivot(p.payload[s], l.next))
+	b.nodes[l.next].parent = rid
+	l.next = last.Pivot()
+	p.payload[s] = withPivot(last, p.payload[s].Pivot())
+}
+
+// borrowRight moves the first entry of the right node into the left node,
+// which are separated by the entry at index s in the parent.
+func (b *T) borrowRight(p *node, s uint16, l, r *node, lid uint32) {
+	first := r.payload[0]
+	r.removeAt(0)
+
+	if l.leaf {
+		l.appendEntry(first)
+		p.payload[s] = withPivot(r.payload[0], p.payload[s].Pivot())
+		return
+	}
+
+	// the separator comes down to point at the left node's rightmost edge,
+	// and the right node's first child becomes the new rightmost edge.
+	l.appendEntry(withPivot(p.payload[s], l.next))
+	l.next = first.Pivot()
+	b.nodes[l.next].parent = lid
+	p.payload[s] = withPivot(first, p.payload[s].Pivot())
+}
+
+// merge moves every entry of the right node into the left node, which are
+// separated by the entry at index s in the parent, and removes the right
+// node from the parent. the right node is no longer referenced afterward.
+func (b *T) merge(p *node, s uint16, l, r *node, lid uint32) {
+	if l.leaf {
+		l.count += uint16(copy(l.payload[l.count:], r.payload[:r.count]))
+		l.next = r.next
+		if r.next != invalidNode {
+			b.nodes[r.next].prev = lid
+		}
+	} else {
+		l.appendEntry(withPivot(p.payload[s], l.next))
+		l.count += uint16(copy(l.payload[l.count:], r.payload[:r.count]))
+		l.next = r.next
+		for i := uint16(0); i <= r.count; i++ {
+			b.nodes[r.child(i)].parent = lid
+		}
+	}
+
+	// removing the separator leaves the right node's slot, which should
+	// now point at the merged node.
+	p.removeAt(s)
+	p.setChild(s, lid)
+}
+
+// free removes the unreferenced node from the btree by moving the last node
+// into its slot and updating everything that refers to the moved node.
+func (b *T) free(id uint32) {
+	last := uint32(len(b.nodes) - 1)
+	if id != last {
+		m := b.nodes[last]
+		b.nodes[id] = m
+
+		if m.parent == invalidNode {
+			b.rid = id
+		} else {
+			p := b.nodes[m.parent]
+			p.setChild(b.childIndex(p, last), id)
+		}
+
+		if m.leaf {
+			if m.prev != invalidNode {
+				b.nodes[m.prev].next = id
+			}
+			if m.next != invalidNode {
+				b.nodes[m.next].prev = id
+			}
+		} else {
+			for i := uint16(0); i <= m.count; i++ {
+				b.nodes[m.child(i)].parent = id
+			}
+		}
+	}
+
+	b.nodes[last] = nil
+	b.nodes = b.nodes[:last]
+}
+
+// withPivot returns the entry with the pivot replaced.
+func withPivot(ent entry.T, pivot uint32) entry.T {
+	ent.SetPivot(pivot)
+	return ent
+}
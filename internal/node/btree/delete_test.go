@@ -0,0 +1,134 @@
+package btree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+func TestDelete(t *testing.T) {
+	// check asserts the btree is consistent and contains exactly the set.
+	check := func(t *testing.T, bt *T, buf []byte, set map[string]bool) {
+		t.Helper()
+		data := entry.Buffer{Loaded: buf}
+		checkInvariants(t, bt, data)
+		assert.Equal(t, bt.Count(), len(set))
+
+		count, last := 0, ""
+		bt.Iter(func(ent *entry.T) bool {
+			key := string(data.Key(*ent))
+			assert.That(t, last < key)
+			assert.That(t, set[key])
+			last = key
+			count++
+			return true
+		})
+		assert.Equal(t, count, len(set))
+	}
+
+	t.Run("Basic", func(t *testing.T) {
+		var set = map[string]bool{}
+		var buf []byte
+		var bt T
+
+		for i := 0; i < 10000; i++ {
+			key := fmt.Sprintf("%08d", i)
+			set[key] = true
+			bt.Insert(appendEntry(&buf, key, ""))
+		}
+		check(t, &bt, buf, set)
+
+		assert.That(t, !bt.Delete([]byte("missing"), entry.Buffer{Loaded: buf}))
+
+		for i := 0; i < 10000; i++ {
+			key := fmt.Sprintf("%08d", i)
+			assert.That(t, bt.Delete([]byte(key), entry.Buffer{Loaded: buf}))
+			assert.That(t, !bt.Delete([]byte(key), entry.Buffer{Loaded: buf}))
+			delete(set, key)
+			if i%100 == 0 {
+				check(t, &bt, buf, set)
+			}
+		}
+
+		check(t, &bt, buf, set)
+		assert.Equal(t, len(bt.nodes), 1)
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		var set = map[string]bool{}
+		var buf []byte
+		var bt T
+
+		for i := 0; i < 100000; i++ {
+			key := string(numbers[gen.Intn(numbersSize)&numbersMask])
+			if gen.Intn(3) == 0 {
+				assert.Equal(t, bt.Delete([]byte(key), entry.Buffer{Loaded: buf}), set[key])
+				delete(set, key)
+			} else {
+				set[key] = true
+				bt.Insert(appendEntry(&buf, key, ""))
+			}
+			if i%10000 == 0 {
+				check(t, &bt, buf, set)
+			}
+		}
+		check(t, &bt, buf, set)
+
+		for key := range set {
+			assert.That(t, bt.Delete([]byte(key), entry.Buffer{Loaded: buf}))
+			delete(set, key)
+		}
+		check(t, &bt, buf, set)
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		for _, factor := range []float64{0, 0.5, 1e-9} {
+			var set = map[string]bool{}
+			var buf []byte
+			var bu Bulk
+
+			bu.SetFillFactor(factor)
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprintf("%08d", i)
+				set[key] = true
+				ent, _ := appendEntry(&buf, key, "")
+				bu.Append(ent)
+			}
+			bt := bu.Done()
+			check(t, &bt, buf, set)
+
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprintf("%08d", (i*7919)%2000)
+				assert.That(t, bt.Delete([]byte(key), entry.Buffer{Loaded: buf}))
+				delete(set, key)
+				if i%50 == 0 {
+					check(t, &bt, buf, set)
+				}
+			}
+			check(t, &bt, buf, set)
+		}
+	})
+
+	t.Run("Write+Load", func(t *testing.T) {
+		var set = map[string]bool{}
+		var buf []byte
+		var bt T
+
+		for i := 0; i < 10000; i++ {
+			key := fmt.Sprintf("%08d", i)
+			set[key] = true
+			bt.Insert(appendEntry(&buf, key, ""))
+		}
+		for i := 0; i < 10000; i += 3 {
+			key := fmt.Sprintf("%08d", i)
+			assert.That(t, bt.Delete([]byte(key), entry.Buffer{Loaded: buf}))
+			delete(set, key)
+		}
+
+		bt2, err := Load(bt.Write(nil))
+		assert.NoError(t, err)
+		check(t, &bt2, buf, set)
+	})
+}
@@ -1,11 +1,15 @@
 package btree
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/zeebo/wosl/internal/node/entry"
+)
 
 var DumpLeaf = false
 
 // Dump constructs a dot graph of the btree
-func Dump(b *T, buf []byte) {
+func Dump(b *T, buf entry.Buffer) {
 	var order []uint32
 	var twalk func(*node, uint32)
 	twalk = func(n *node, nid uint32) {
@@ -32,7 +36,7 @@ func Dump(b *T, buf []byte) {
 		fmt.Printf(`<TD PORT="fb"> </TD><TD PORT="fn">n%d (%d)</TD>`, nid, n.count)
 		if !n.leaf || DumpLeaf {
 			for i := uint16(0); i < n.count; i++ {
-				fmt.Printf(`<TD PORT="f%d">%s`, i, n.payload[i].ReadKey(buf))
+				fmt.Printf(`<TD PORT="f%d">%s`, i, buf.Key(n.payload[i]))
 				if n.leaf {
 					fmt.Printf(`:%d`, n.payload[i].Value())
 				}
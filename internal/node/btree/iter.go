@@ -36,3 +36,70 @@ next:
 func (i *Iterator) Entry() entry.T {
 	return i.n.payload[i.i]
 }
+
+// Prev moves the iterator to the previous entry and returns true if there
+// is one. It is only valid to call this after the iterator is positioned
+// on an entry.
+func (i *Iterator) Prev() bool {
+	if i.n == nil {
+		return false
+	}
+
+	for i.i == 0 {
+		if i.n.prev == invalidNode {
+			i.n = nil
+			return false
+		}
+		i.n = i.b.nodes[i.n.prev]
+		i.i = i.n.count
+	}
+
+	i.i--
+	return true
+}
+
+// Seek positions the iterator at the first entry with a key greater than or
+// equal to the key, using the buf to read keys. It returns true if there
+// is such an entry.
+func (i *Iterator) Seek(key []byte, buf entry.Buffer) bool {
+	if i.b == nil || i.b.root == nil {
+		i.n = nil
+		return false
+	}
+
+	n, _ := i.b.search(key, buf)
+	idx, _ := n.find(key, buf)
+	i.n, i.i = n, idx-1 // overflow hack if idx is 0
+	return i.Next()
+}
+
+// SeekLT positions the iterator at the last entry with a key strictly less
+// than the key, using the buf to read keys. It returns true if there is such
+// an entry.
+func (i *Iterator) SeekLT(key []byte, buf entry.Buffer) bool {
+	if i.b == nil || i.b.root == nil {
+		i.n = nil
+		return false
+	}
+
+	n, _ := i.b.search(key, buf)
+	idx, _ := n.find(key, buf)
+	i.n, i.i = n, idx
+	return i.Prev()
+}
+
+// Last positions the iterator at the last entry. It returns true if there
+// is one.
+func (i *Iterator) Last() bool {
+	if i.b == nil || i.b.root == nil {
+		i.n = nil
+		return false
+	}
+
+	n := i.b.root
+	for !n.leaf {
+		n = i.b.nodes[n.next]
+	}
+	i.n, i.i = n, n.count
+	return i.Prev()
+}
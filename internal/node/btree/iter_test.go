@@ -1,9 +1,11 @@
 package btree
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/internal/node/entry"
 )
 
 func TestIterator(t *testing.T) {
@@ -33,8 +35,63 @@ func TestIterator(t *testing.T) {
 		assert.Equal(t, len(set), 0)
 	})
 
+	t.Run("Seek", func(t *testing.T) {
+		var buf []byte
+		var data entry.Buffer
+		var bt T
+
+		// insert only the even keys, so that the odd keys are missing.
+		for i := 0; i < 10000; i += 2 {
+			var ent entry.T
+			ent, data = appendEntry(&buf, fmt.Sprintf("%05d", i), "")
+			bt.Insert(ent, data)
+		}
+
+		for i := 0; i < 10000; i++ {
+			key := []byte(fmt.Sprintf("%05d", i))
+			ge, lt := (i+1)/2*2, (i-1)/2*2
+
+			iter := bt.Iterator()
+			assert.Equal(t, iter.Seek(key, data), ge < 10000)
+			if ge < 10000 {
+				assert.Equal(t, string(data.Key(iter.Entry())), fmt.Sprintf("%05d", ge))
+			}
+			if ge+2 < 10000 {
+				assert.That(t, iter.Next())
+				assert.Equal(t, string(data.Key(iter.Entry())), fmt.Sprintf("%05d", ge+2))
+			}
+
+			iter = bt.Iterator()
+			assert.Equal(t, iter.SeekLT(key, data), i > 0)
+			if i > 0 {
+				assert.Equal(t, string(data.Key(iter.Entry())), fmt.Sprintf("%05d", lt))
+			}
+			if lt-2 >= 0 {
+				assert.That(t, iter.Prev())
+				assert.Equal(t, string(data.Key(iter.Entry())), fmt.Sprintf("%05d", lt-2))
+			}
+		}
+
+		iter := bt.Iterator()
+		assert.That(t, iter.Last())
+		assert.Equal(t, string(data.Key(iter.Entry())), "09998")
+		assert.That(t, !iter.Next())
+		assert.That(t, !iter.Seek([]byte("a"), data))
+		assert.That(t, !iter.SeekLT([]byte(""), data))
+
+		// walking backwards from the end should see every entry.
+		count, iter := 0, bt.Iterator()
+		for ok := iter.Last(); ok; ok = iter.Prev() {
+			count++
+		}
+		assert.Equal(t, count, 5000)
+	})
+
 	t.Run("Empty", func(t *testing.T) {
 		iter := new(T).Iterator()
 		assert.That(t, !iter.Next())
+		assert.That(t, !iter.Seek([]byte("a"), entry.Buffer{}))
+		assert.That(t, !iter.SeekLT([]byte("a"), entry.Buffer{}))
+		assert.That(t, !iter.Last())
 	})
 }
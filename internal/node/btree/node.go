@@ -10,34 +10,100 @@ import (
 )
 
 const (
-	invalidNode    = math.MaxUint32
-	payloadEntries = 127
-	payloadSplit   = payloadEntries / 2
+	invalidNode = math.MaxUint32
 
-	// NodeSize is how many bytes a node takes up.
-	NodeSize = uint64(unsafe.Sizeof(node{}))
+	// MinFanout is the fewest entries a node can be configured to hold.
+	MinFanout = 3
+
+	// MaxFanout is the most entries a node can be configured to hold.
+	MaxFanout = math.MaxUint16
+
+	// DefaultFanout is how many entries a node holds if none is set.
+	DefaultFanout = 127
+
+	// NodeSize is how many bytes a node with the default fanout takes up.
+	NodeSize = headerSize + DefaultFanout*entrySize
+
+	headerSize = uint64(unsafe.Sizeof(header{}))
+	entrySize  = uint64(unsafe.Sizeof(entry.T{}))
 )
 
-// N.B. it is important that Node does not contain pointers, so that we can
-// construct arrays of them off heap.
+// NodeLength returns how many bytes a node with the fanout takes up.
+func NodeLength(fanout uint16) uint64 { return nodeSize(fanout) }
+
+// nodeSize returns how many bytes a node with the fanout takes up.
+func nodeSize(fanout uint16) uint64 { return headerSize + uint64(fanout)*entrySize }
+
+// FanoutFor returns the largest fanout such that a node takes up at most
+// size bytes, bounded by MinFanout and MaxFanout.
+func FanoutFor(size uint64) uint16 {
+	if size < nodeSize(MinFanout) {
+		return MinFanout
+	} else if size >= nodeSize(MaxFanout) {
+		return MaxFanout
+	}
+	return uint16((size - headerSize) / entrySize)
+}
+
+// N.B. it is important that header does not contain pointers, so that we
+// can construct them off heap.
+
+// header is the fixed size part of a node.
+type header struct {
+	next   uint32  // pointer to the next node (or if not leaf, the rightmost edge)
+	prev   uint32  // backpointer from next node (unused if not leaf)
+	parent uint32  // set to invalidNode on the root node
+	count  uint16  // used values in payload
+	leaf   bool    // set if is a leaf
+	_      [1]byte // padding
+}
 
-// node are nodes in the btree.
+// node are nodes in the btree. the header and payload may point into a
+// loaded buffer.
 type node struct {
-	next    uint32  // pointer to the next node (or if not leaf, the rightmost edge)
-	prev    uint32  // backpointer from next node (unused if not leaf)
-	parent  uint32  // set to invalidNode on the root node
-	count   uint16  // used values in payload
-	leaf    bool    // set if is a leaf
-	_       [1]byte // padding
-	payload [payloadEntries]entry.T
+	*header
+	payload []entry.T // has room for the fanout of the btree
 }
 
-// insertEntry inserts the entry into the node. it should never be called
-// on a node that would have to split. it returns true if the count increased.
-func (n *node) insertEntry(key []byte, ent entry.T, buf []byte) bool {
-	prefix := binary.BigEndian.Uint32(ent.Prefix[:])
+// newNode returns a node with room for fanout entries.
+func newNode(fanout uint16) *node {
+	return &node{
+		header:  new(header),
+		payload: make([]entry.T, fanout),
+	}
+}
 
-	// binary search to find the appropriate child
+// loadNode returns a node that uses the storage in buf, which must be
+// at least nodeSize(fanout) bytes.
+func loadNode(buf []byte, fanout uint16) *node {
+	return &node{
+		header:  (*header)(unsafe.Pointer(&buf[0])),
+		payload: entries(buf, fanout),
+	}
+}
+
+// entries returns a slice of count entries that uses the storage in buf
+// after the header.
+func entries(buf []byte, count uint16) []entry.T {
+	_ = buf[headerSize+uint64(count)*entrySize-1]
+	return unsafe.Slice((*entry.T)(unsafe.Pointer(&buf[headerSize])), count)
+}
+
+// store writes the node into buf, which must be at least nodeSize(fanout)
+// bytes.
+func (n *node) store(buf []byte) {
+	*(*header)(unsafe.Pointer(&buf[0])) = *n.header
+	copy(entries(buf, uint16(len(n.payload))), n.payload)
+}
+
+// find returns the index of the first entry in the node with a key that
+// is greater than or equal to the key, and if it is equal.
+func (n *node) find(key []byte, buf entry.Buffer) (uint16, bool) {
+	var prefixBytes [4]byte
+	copy(prefixBytes[:], key)
+	prefix := binary.BigEndian.Uint32(prefixBytes[:])
+
+	// binary search to find the appropriate entry
 	i, j := uint16(0), n.count
 	for i < j {
 		h := (i + j) >> 1
@@ -49,17 +115,13 @@ func (n *node) insertEntry(key []byte, ent entry.T, buf []byte) bool {
 			i = h + 1
 
 		case 0:
-			kh := enth.ReadKey(buf)
+			kh := buf.Key(enth)
 			switch bytes.Compare(key, kh) {
 			case 1:
 				i = h + 1
 
 			case 0:
-				// found a match. overwite and exit.
-				// we want to retain the pivot field, though.
-				ent.SetPivot(enth.Pivot())
-				n.payload[h] = ent
-				return false
+				return h, true
 
 			case -1:
 				j = h
@@ -70,10 +132,38 @@ func (n *node) insertEntry(key []byte, ent entry.T, buf []byte) bool {
 		}
 	}
 
+	return i, false
+}
+
+// insertEntry inserts the entry into the node. it should never be called
+// on a node that would have to split. it returns true if the count increased,
+// and otherwise the entry that was overwritten.
+func (n *node) insertEntry(key []byte, ent entry.T, buf entry.Buffer) (entry.T, bool) {
+	i, ok := n.find(key, buf)
+	if ok {
+		// found a match. overwite and exit.
+		// we want to retain the pivot field, though.
+		old := n.payload[i]
+		ent.SetPivot(old.Pivot())
+		n.payload[i] = ent
+		return old, false
+	}
+
+	n.insertAt(i, ent)
+	return entry.T{}, true
+}
+
+// insertAt inserts the entry at the index, shifting later entries over.
+func (n *node) insertAt(i uint16, ent entry.T) {
 	copy(n.payload[i+1:], n.payload[i:n.count])
 	n.payload[i] = ent
 	n.count++
-	return true
+}
+
+// removeAt removes the entry at the index, shifting later entries over.
+func (n *node) removeAt(i uint16) {
+	copy(n.payload[i:], n.payload[i+1:n.count])
+	n.count--
 }
 
 // appendEntry appends the entry into the node. it must compare greater than any
@@ -13,9 +13,9 @@ func TestNode(t *testing.T) {
 		var keys []string
 		var seen = map[string]bool{}
 		var buf []byte
-		var n node
+		n := newNode(DefaultFanout)
 
-		for i := 0; i < payloadEntries-1; i++ {
+		for i := 0; i < DefaultFanout-1; i++ {
 			var key string
 			for key == "" || seen[key] {
 				key = fmt.Sprint(gen.Uint32())
@@ -34,4 +34,16 @@ func TestNode(t *testing.T) {
 			assert.Equal(t, string(n.payload[i].ReadKey(buf)), keys[i])
 		}
 	})
+	t.Run("FanoutFor", func(t *testing.T) {
+		assert.Equal(t, FanoutFor(0), MinFanout)
+		assert.Equal(t, FanoutFor(NodeSize), DefaultFanout)
+		assert.Equal(t, FanoutFor(NodeSize+entrySize-1), DefaultFanout)
+		assert.Equal(t, FanoutFor(1<<40), MaxFanout)
+
+		for _, size := range []uint64{100, 1000, 4096, 1 << 16} {
+			fanout := FanoutFor(size)
+			assert.That(t, nodeSize(fanout) <= size)
+			assert.That(t, nodeSize(fanout+1) > size)
+		}
+	})
 }
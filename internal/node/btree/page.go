@@ -0,0 +1,106 @@
+package btree
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// Header describes a written btree, so that its nodes can be read one at a
+// time instead of loading all of them.
+type Header struct {
+	Root   uint32 // id of the root node
+	Count  uint32 // number of entries
+	Nodes  uint32 // number of nodes
+	Fanout uint16 // entries per node
+}
+
+// ReadHeader parses the header at the start of a written btree.
+func ReadHeader(buf []byte) (Header, error) {
+	if len(buf) < HeaderSize {
+		return Header{}, Error.New("buffer too small for btree")
+	}
+
+	var (
+		rid    = binary.LittleEndian.Uint32(buf[0:4])
+		count  = binary.LittleEndian.Uint32(buf[4:8])
+		ncount = binary.LittleEndian.Uint32(buf[8:12])
+		fanout = binary.LittleEndian.Uint32(buf[12:16])
+	)
+
+	if fanout < MinFanout || fanout > MaxFanout {
+		return Header{}, Error.New("invalid fanout: %d", fanout)
+	}
+	if uint32(rid) >= ncount {
+		return Header{}, Error.New("root id out of range. root:%d count:%d",
+			rid, ncount)
+	}
+
+	return Header{
+		Root:   rid,
+		Count:  count,
+		Nodes:  ncount,
+		Fanout: uint16(fanout),
+	}, nil
+}
+
+// PageSize returns how many bytes each node of the btree takes up.
+func (h Header) PageSize() uint64 { return nodeSize(h.Fanout) }
+
+// PageOffset returns where the node with the id starts, relative to the
+// start of the written btree.
+func (h Header) PageOffset(id uint32) uint64 {
+	return HeaderSize + uint64(id)*nodeSize(h.Fanout)
+}
+
+// Page is a single node of a written btree that was read on its own. It is
+// only ever read from.
+type Page struct {
+	n *node
+}
+
+// LoadPage returns the page for the node stored in buf, which must be at
+// least PageSize bytes. It uses the storage in buf unless it is not aligned
+// for the entries, in which case it is copied.
+func (h Header) LoadPage(buf []byte) (Page, error) {
+	size := nodeSize(h.Fanout)
+	if uint64(len(buf)) < size {
+		return Page{}, Error.New("buffer too small for node: %d", len(buf))
+	}
+	if uintptr(unsafe.Pointer(&buf[0]))%unsafe.Alignof(entry.T{}) != 0 {
+		buf = append(make([]byte, 0, size), buf[:size]...)
+	}
+
+	n := loadNode(buf, h.Fanout)
+	if n.count > h.Fanout {
+		return Page{}, Error.New("invalid node count: %d", n.count)
+	}
+	return Page{n: n}, nil
+}
+
+// Leaf returns true if the page is a leaf.
+func (p Page) Leaf() bool { return p.n.leaf }
+
+// Count returns how many entries are in the page.
+func (p Page) Count() uint16 { return p.n.count }
+
+// Entry returns the i'th entry of the page.
+func (p Page) Entry(i uint16) entry.T { return p.n.payload[i] }
+
+// Child returns the id of the node to the left of the i'th entry of an
+// inner page, or the rightmost child if i is the count.
+func (p Page) Child(i uint16) uint32 {
+	if i >= p.n.count {
+		return p.n.next
+	}
+	return p.n.payload[i].Pivot()
+}
+
+// Next returns the id of the next leaf after a leaf page, and false if it
+// is the last one.
+func (p Page) Next() (uint32, bool) { return p.n.next, p.n.next != invalidNode }
+
+// Prev returns the id of the leaf before a leaf page, and false if it is
+// the first one.
+func (p Page) Prev() (uint32, bool) { return p.n.prev, p.n.prev != invalidNode }
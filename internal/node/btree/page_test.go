@@ -0,0 +1,89 @@
+package btree
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestPage(t *testing.T) {
+	t.Run("Walk", func(t *testing.T) {
+		var buf []byte
+		var bt T
+		bt.SetFanout(8)
+
+		for i := 0; i < 1000; i++ {
+			bt.Insert(appendEntry(&buf, string(numbers[i]), ""))
+		}
+		written := bt.Write(nil)
+
+		h, err := ReadHeader(written)
+		assert.NoError(t, err)
+		assert.Equal(t, h.Root, bt.rid)
+		assert.Equal(t, h.Count, bt.count)
+		assert.Equal(t, h.Nodes, len(bt.nodes))
+		assert.Equal(t, h.Fanout, 8)
+
+		load := func(id uint32) Page {
+			off := h.PageOffset(id)
+			page, err := h.LoadPage(written[off : off+h.PageSize()])
+			assert.NoError(t, err)
+			return page
+		}
+
+		// walk down the leftmost edge and then across the leaves.
+		page := load(h.Root)
+		for !page.Leaf() {
+			page = load(page.Child(0))
+		}
+		_, ok := page.Prev()
+		assert.That(t, !ok)
+
+		var keys [][]byte
+		for {
+			for i := uint16(0); i < page.Count(); i++ {
+				keys = append(keys, page.Entry(i).ReadKey(buf))
+			}
+			next, ok := page.Next()
+			if !ok {
+				break
+			}
+			page = load(next)
+		}
+
+		assert.Equal(t, len(keys), bt.count)
+		iter := bt.Iterator()
+		for _, key := range keys {
+			assert.That(t, iter.Next())
+			assert.That(t, bytes.Equal(key, iter.Entry().ReadKey(buf)))
+		}
+	})
+
+	t.Run("Unaligned", func(t *testing.T) {
+		var buf []byte
+		var bt T
+		bt.Insert(appendEntry(&buf, "key", ""))
+		written := bt.Write(nil)
+
+		h, err := ReadHeader(written)
+		assert.NoError(t, err)
+
+		off := h.PageOffset(h.Root)
+		shifted := append([]byte{0}, written[off:off+h.PageSize()]...)
+		page, err := h.LoadPage(shifted[1:])
+		assert.NoError(t, err)
+		assert.Equal(t, page.Count(), 1)
+		assert.Equal(t, string(page.Entry(0).ReadKey(buf)), "key")
+
+		_, err = h.LoadPage(shifted[1:10])
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := ReadHeader(make([]byte, HeaderSize-1))
+		assert.Error(t, err)
+		_, err = ReadHeader(make([]byte, HeaderSize))
+		assert.Error(t, err)
+	})
+}
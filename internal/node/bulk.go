@@ -9,24 +9,47 @@ import (
 )
 
 // Bulk allows for bulk loading data into a node, if it already exists
-// in sorted order.
+// in sorted order. The node is returned in its frozen form.
 type Bulk struct {
-	buf []byte
-	bu  btree.Bulk
+	buf    []byte
+	ents   []entry.T
+	seq    uint64 // largest sequence number appended
+	bits   uint8  // bits per key of the Bloom filter built by Done
+	fanout uint16 // fanout of the btree of the returned node
+}
+
+// SetBloomBits sets how many bits per key the Bloom filter built for the
+// returned node uses. Zero causes no filter to be built. It is kept across
+// calls to Reset.
+func (b *Bulk) SetBloomBits(bits uint8) { b.bits = bits }
+
+// SetFanout sets how many entries each node of the btree built for the
+// returned node, once it is modified, has room for. Values below
+// btree.MinFanout use the default. It is kept across calls to Reset.
+func (b *Bulk) SetFanout(fanout uint16) { b.fanout = fanout }
+
+// nodeFanout returns the fanout the btree of the returned node will have.
+func (b *Bulk) nodeFanout() uint16 {
+	if b.fanout < btree.MinFanout {
+		return btree.DefaultFanout
+	}
+	return b.fanout
 }
 
 // Reset clears the state of the bulk import.
 func (b *Bulk) Reset() {
 	b.buf = nil
-	b.bu = btree.Bulk{}
+	b.ents = nil
+	b.seq = 0
 }
 
 // Length returns an upper bound on how many bytes writing the
 // eventually returned node would require.
 func (b *Bulk) Length() uint64 {
 	return 0 +
-		nodeHeaderPadded +
-		b.bu.Length() +
+		headerLength(0) +
+		bloomLength(uint32(len(b.ents)), b.bits) +
+		fencesLength(b.first(), b.last(), nil) +
 		uint64(len(b.buf)) +
 		0
 }
@@ -38,45 +61,149 @@ func (b *Bulk) Fits(key, value []byte, size uint32) bool {
 		// we add 10 btreeNodeSize to protect if the insert would cause a split
 		// which might allocate up to log(n) nodes. there's no way that's ever
 		// bigger than 10 (famous last words).
-		b.Length()+10*btree.NodeSize < uint64(size)
+		b.Length()+10*btree.NodeLength(b.nodeFanout()) < uint64(size)
 }
 
 var bulkAppendThunk mon.Thunk // timing info for bulk.Append
 
-// Append adds the key/value to the bulk importer. If tombstone
-// is true, it is added as a tombstone. It returns true if the
-// write happened, and false if it would cause the node to become
-// too large.
-func (b *Bulk) Append(key, value []byte, tombstone bool, pivot uint32) bool {
+// Append adds the key/value to the bulk importer as of the sequence
+// number. If tombstone is true, it is added as a tombstone. It returns
+// true if the write happened, and false if it would cause the node to
+// become too large.
+func (b *Bulk) Append(key, value []byte, tombstone bool, pivot uint32, seq uint64) bool {
 	timer := bulkAppendThunk.Start()
 
+	// build the entry that we will insert.
+	ent := entry.New(key, value, tombstone, 0)
+	ent.SetPivot(pivot)
+	ent.SetSeq(seq)
+
+	wrote := b.append(key, value, ent)
+	timer.Stop()
+	return wrote
+}
+
+var bulkAppendPointerThunk mon.Thunk // timing info for bulk.AppendPointer
+
+// AppendPointer adds the key and value log pointer to the bulk importer.
+// It returns true if the write happened, and false if it would cause the
+// node to become too large.
+func (b *Bulk) AppendPointer(key, ptr []byte, pivot uint32, seq uint64) bool {
+	timer := bulkAppendPointerThunk.Start()
+
+	// build the entry that we will insert.
+	ent := entry.New(key, ptr, false, 0)
+	ent.SetPivot(pivot)
+	ent.SetPointer(true)
+	ent.SetSeq(seq)
+
+	wrote := b.append(key, ptr, ent)
+	timer.Stop()
+	return wrote
+}
+
+var bulkAppendExpiringThunk mon.Thunk // timing info for bulk.AppendExpiring
+
+// AppendExpiring adds the key and value to the bulk importer along with the
+// time it expires at. If pointer is true, the value is a value log pointer.
+// It returns true if the write happened, and false if it would cause the
+// node to become too large.
+func (b *Bulk) AppendExpiring(key, value []byte, expiry int64, pointer bool,
+	pivot uint32, seq uint64) bool {
+
+	timer := bulkAppendExpiringThunk.Start()
+
+	// build the entry that we will insert.
+	value = append(entry.AppendExpiry(nil, expiry), value...)
+	ent := entry.New(key, value, false, 0)
+	ent.SetPivot(pivot)
+	ent.SetPointer(pointer)
+	ent.SetExpires(true)
+	ent.SetSeq(seq)
+
+	wrote := b.append(key, value, ent)
+	timer.Stop()
+	return wrote
+}
+
+// append adds the key and value to the buffer and the entry to the
+// bulk loader.
+func (b *Bulk) append(key, value []byte, ent entry.T) bool {
 	// make sure the write is ok to go
 	if !b.Fits(key, value, math.MaxUint32) {
-		timer.Stop()
 		return false
 	}
 
-	// build the entry that we will insert.
-	ent := entry.New(key, value, tombstone, uint32(len(b.buf)))
-	ent.SetPivot(pivot)
-
 	// add the data to the buffer
+	ent.SetOffset(uint32(len(b.buf)) + entry.HeaderSize)
+	b.buf = ent.AppendHeader(b.buf, 0)
 	b.buf = append(b.buf, key...)
 	b.buf = append(b.buf, value...)
 
-	// insert it into the bulk loader.
-	b.bu.Append(ent)
-
-	timer.Stop()
+	// keep track of the entry for the frozen form.
+	b.ents = append(b.ents, ent)
+	if ent.Seq() > b.seq {
+		b.seq = ent.Seq()
+	}
 	return true
 }
 
+// first returns the first key appended, or nil if there is none.
+func (b *Bulk) first() []byte {
+	if len(b.ents) == 0 {
+		return nil
+	}
+	return b.ents[0].ReadKey(b.buf)
+}
+
+// last returns the last key appended, or nil if there is none.
+func (b *Bulk) last() []byte {
+	if len(b.ents) == 0 {
+		return nil
+	}
+	return b.ents[len(b.ents)-1].ReadKey(b.buf)
+}
+
 // Done returns a node with the given next and height using the
 // bulk loaded data. It should not be called multiple times.
 func (b *Bulk) Done(height uint32) *T {
 	t := New(height)
 	t.buf = b.buf
-	t.entries = b.bu.Done()
-	t.dirty = t.entries.Count() > 0
+	b.strip(t)
+	t.frozen = newFrozen(b.ents)
+	t.seq = b.seq
+	t.dirty = len(b.ents) > 0
+	t.bits = b.bits
+	t.SetFanout(b.fanout)
+	t.refence()
+
+	if size := bloomLength(uint32(len(b.ents)), b.bits); size > 0 {
+		t.bloom = newBloom(make([]byte, size), b.bits)
+		t.eachKey(t.bloom.add)
+	}
 	return t
 }
+
+// strip moves the prefix shared by every key into the node, rewriting the
+// buffer and entries without it the same way Write does for other nodes.
+func (b *Bulk) strip(t *T) {
+	if len(b.ents) == 0 {
+		return
+	}
+	n := commonPrefix(b.first(), b.last())
+	if n > int(maxPrefix) {
+		n = int(maxPrefix)
+	}
+	if n == 0 {
+		return
+	}
+
+	t.prefix = append([]byte(nil), b.first()[:n]...)
+
+	src := entry.Buffer{Loaded: b.buf}
+	data := make([]byte, 0, len(b.buf)-n*len(b.ents))
+	for i, ent := range b.ents {
+		data, b.ents[i] = appendRecord(data, src, ent, nil, n, 0)
+	}
+	t.buf = data
+}
@@ -5,26 +5,132 @@ import (
 	"testing"
 
 	"github.com/zeebo/assert"
-	"github.com/zeebo/wosl/internal/node/entry"
 )
 
 func TestBulk(t *testing.T) {
+	t.Run("Bloom", func(t *testing.T) {
+		var bu Bulk
+		bu.SetBloomBits(10)
+
+		for i := 0; i < 1000; i += 2 {
+			key := []byte(fmt.Sprintf("%04d", i))
+			assert.That(t, bu.Append(key, nil, false, 0, 0))
+		}
+		n := bu.Done(0)
+		assert.That(t, n.bloom != nil)
+
+		positives := 0
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("%04d", i))
+			if i%2 == 0 {
+				assert.That(t, n.MayContain(key))
+			} else if n.MayContain(key) {
+				positives++
+			}
+		}
+		assert.That(t, positives < 50)
+
+		// the filter survives writing and loading.
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+		assert.That(t, n.bloom != nil)
+		assert.That(t, n.MayContain([]byte("0500")))
+	})
+
 	t.Run("Append", func(t *testing.T) {
 		var bu Bulk
 
 		for i := 0; i < 1000; i++ {
 			key := []byte(fmt.Sprintf("%04d", i))
-			assert.That(t, bu.Append(key, nil, false, 0))
+			assert.That(t, bu.Append(key, nil, false, 0, 0))
 		}
 		n := bu.Done(0)
+		assert.That(t, n.frozen != nil)
+		assert.Equal(t, n.Count(), 1000)
+
+		last, data := "", n.data()
+		for _, ent := range n.frozen.ents {
+			key := string(data.Key(ent))
+			assert.That(t, key > last)
+			last = key
+		}
+
+		// writing and loading keeps it frozen, and inserting thaws it.
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+		assert.That(t, n.frozen != nil)
+		assert.Equal(t, n.Count(), 1000)
+
+		assert.That(t, n.Insert([]byte("0500"), []byte("new"), 0, 0))
+		assert.That(t, n.frozen == nil)
+		assert.Equal(t, n.Count(), 1000)
 
-		last, base := "", n.buf[n.base:]
-		n.entries.Iter(func(ent *entry.T) bool {
-			key := string(ent.ReadKey(base))
+		last, iter := "", n.Iterator()
+		for iter.Next() {
+			key := string(iter.Key())
 			assert.That(t, key > last)
+			if key == "0500" {
+				assert.Equal(t, string(iter.Value()), "new")
+			}
 			last = key
-			return true
-		})
+		}
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		var bu Bulk
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("prefix/%04d", i))
+			assert.That(t, bu.Append(key, key, false, 0, 0))
+		}
+		n := bu.Done(0)
+		assert.Equal(t, string(n.prefix), "prefix/0")
+
+		// the prefix is written, and the keys are whole when read back.
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, string(n.prefix), "prefix/0")
+
+		i, iter := 0, n.Iterator()
+		for iter.Next() {
+			assert.Equal(t, string(iter.Key()), fmt.Sprintf("prefix/%04d", i))
+			assert.Equal(t, string(iter.Value()), string(iter.Key()))
+			i++
+		}
+		assert.Equal(t, i, 1000)
+
+		iter = n.Iterator()
+		assert.That(t, iter.Seek([]byte("prefix/0500")))
+		assert.Equal(t, string(iter.Key()), "prefix/0500")
+		assert.That(t, iter.Seek([]byte("prefix/05000")))
+		assert.Equal(t, string(iter.Key()), "prefix/0501")
+	})
+
+	t.Run("Fanout", func(t *testing.T) {
+		// count how many entries fit in a small block with the fanout.
+		fill := func(fanout uint16) (int, *T) {
+			var bu Bulk
+			bu.SetFanout(fanout)
+			i := 0
+			for ; bu.Fits(numbers[i], numbers[i], 4<<10); i++ {
+				key := []byte(fmt.Sprintf("%04d", i))
+				assert.That(t, bu.Append(key, numbers[i], false, 0, 0))
+			}
+			return i, bu.Done(0)
+		}
+
+		small, n := fill(3)
+		large, _ := fill(0)
+		assert.That(t, small > large)
+
+		// the fanout is used once the node is thawed.
+		assert.That(t, n.Insert([]byte("new"), nil, 0, 0))
+		assert.Equal(t, n.entries.Fanout(), 3)
 	})
 }
 
@@ -45,7 +151,7 @@ func BenchmarkBulk(b *testing.B) {
 				if !bu.Fits(keys[i], v, bufferSize) {
 					bu.Reset()
 				}
-				bu.Append(keys[i], v, true, 0)
+				bu.Append(keys[i], v, true, 0, 0)
 			}
 		}
 
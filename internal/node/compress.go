@@ -0,0 +1,124 @@
+package node
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// CodecNone is the codec recorded for nodes that are not compressed.
+const CodecNone uint8 = 0
+
+// CodecFlate is the codec recorded for nodes compressed with flate.
+const CodecFlate uint8 = 1
+
+// Compressor compresses the payload of a node when it is written, and
+// decompresses it when it is loaded.
+type Compressor interface {
+	// Codec returns the identifier recorded in the header of any node
+	// compressed with the Compressor. It must not be CodecNone.
+	Codec() uint8
+
+	// Compress appends the compressed form of src to dst.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress fills dst with the decompressed form of src. The dst
+	// slice is exactly as large as the decompressed data.
+	Decompress(dst, src []byte) error
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   [256]Compressor
+)
+
+func init() {
+	RegisterCompressor(NewFlateCompressor(flate.DefaultCompression))
+}
+
+// RegisterCompressor makes the compressor available to decompress any
+// nodes recorded with its codec. Since nodes record only the codec, it
+// returns an error if a different compressor was already registered with
+// the same codec. Flate compressors of any level decompress the same way,
+// so they are never different.
+func RegisterCompressor(c Compressor) error {
+	if c.Codec() == CodecNone {
+		return Error.New("cannot register a compressor with no codec")
+	}
+
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	if prev := compressors[c.Codec()]; prev == nil {
+		compressors[c.Codec()] = c
+	} else if !sameCompressor(prev, c) {
+		return Error.New("codec already registered: %d", c.Codec())
+	}
+	return nil
+}
+
+// sameCompressor returns true if the compressors decompress the same way.
+func sameCompressor(a, b Compressor) bool {
+	_, aflate := a.(flateCompressor)
+	_, bflate := b.(flateCompressor)
+	if aflate && bflate {
+		return true
+	}
+	typ := reflect.TypeOf(a)
+	return typ == reflect.TypeOf(b) && typ.Comparable() && a == b
+}
+
+// lookupCompressor returns the compressor registered for the codec.
+func lookupCompressor(codec uint8) (Compressor, error) {
+	compressorsMu.RLock()
+	c := compressors[codec]
+	compressorsMu.RUnlock()
+
+	if c == nil {
+		return nil, Error.New("unknown codec: %d", codec)
+	}
+	return c, nil
+}
+
+// flateCompressor is a Compressor using compress/flate.
+type flateCompressor struct {
+	level int
+}
+
+// NewFlateCompressor returns a Compressor that uses compress/flate at the
+// given level. The level only affects compression, so every level shares
+// the same codec.
+func NewFlateCompressor(level int) Compressor {
+	return flateCompressor{level: level}
+}
+
+// Codec returns CodecFlate.
+func (f flateCompressor) Codec() uint8 { return CodecFlate }
+
+// Compress appends the flate compressed form of src to dst.
+func (f flateCompressor) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, err := flate.NewWriter(buf, f.level)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress fills dst with the flate decompressed form of src.
+func (f flateCompressor) Decompress(dst, src []byte) error {
+	r := flate.NewReader(bytes.NewReader(src))
+	if _, err := io.ReadFull(r, dst); err != nil {
+		r.Close()
+		return Error.Wrap(err)
+	}
+	return Error.Wrap(r.Close())
+}
@@ -0,0 +1,130 @@
+package node
+
+import (
+	"compress/flate"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestCompress(t *testing.T) {
+	t.Run("Flate", func(t *testing.T) {
+		comp := NewFlateCompressor(flate.BestSpeed)
+		data := []byte("hello hello hello hello hello hello")
+
+		out, err := comp.Compress([]byte("prefix"), data)
+		assert.NoError(t, err)
+		assert.Equal(t, string(out[:6]), "prefix")
+
+		got := make([]byte, len(data))
+		assert.NoError(t, comp.Decompress(got, out[6:]))
+		assert.Equal(t, string(got), string(data))
+	})
+
+	t.Run("Write+Load", func(t *testing.T) {
+		n1 := New(3)
+		n1.SetNext(5)
+		n1.SetPivot(7)
+		n1.SetCompressor(NewFlateCompressor(flate.BestSpeed))
+		for i := 0; i < 1000; i++ {
+			d := numbers[i]
+			assert.That(t, n1.Insert(d, d, 0, 0))
+		}
+
+		buf, err := n1.Write(nil)
+		assert.NoError(t, err)
+		assert.That(t, uint64(len(buf)) < n1.Length())
+
+		n2, err := Load(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, n2.Height(), 3)
+		assert.Equal(t, n2.Next(), 5)
+		assert.Equal(t, n2.Pivot(), 7)
+		assert.Equal(t, n2.Compressor().Codec(), CodecFlate)
+		assert.Equal(t, n2.Count(), n1.Count())
+
+		iter := n2.Iterator()
+		for iter.Next() {
+			assert.Equal(t, string(iter.Key()), string(iter.Value()))
+		}
+	})
+
+	t.Run("Incompressible", func(t *testing.T) {
+		n := New(0)
+		n.SetCompressor(NewFlateCompressor(flate.BestSpeed))
+		for i := 0; i < 10; i++ {
+			value := make([]byte, 1024)
+			for j := range value {
+				value[j] = byte(gen.Uint32())
+			}
+			assert.That(t, n.Insert(numbers[i], value, 0, 0))
+		}
+
+		// the values won't compress, but the node should still round trip.
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n2, err := Load(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, n2.Count(), n.Count())
+	})
+
+	t.Run("CompressedLength", func(t *testing.T) {
+		n := New(0)
+		n.SetCompressor(NewFlateCompressor(flate.BestSpeed))
+		for i := 0; i < 1000; i++ {
+			d := numbers[i]
+			assert.That(t, n.Insert(d, d, 0, 0))
+		}
+		_, err := n.Write(nil)
+		assert.NoError(t, err)
+		for i := 1000; i < 1100; i++ {
+			d := numbers[i]
+			assert.That(t, n.Insert(d, d, 0, 0))
+		}
+
+		// estimating does not write the node.
+		length := n.Length()
+		size, err := n.CompressedLength()
+		assert.NoError(t, err)
+		assert.That(t, size < length)
+		assert.That(t, n.Dirty())
+		assert.Equal(t, n.Length(), length)
+
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		assert.That(t, uint64(len(buf)) <= size)
+	})
+
+	t.Run("Register", func(t *testing.T) {
+		a := &copyCompressor{codec: 200}
+		assert.NoError(t, RegisterCompressor(a))
+		assert.NoError(t, RegisterCompressor(a))
+		assert.Error(t, RegisterCompressor(&copyCompressor{codec: 200}))
+		assert.Error(t, RegisterCompressor(&copyCompressor{codec: CodecFlate}))
+		assert.Error(t, RegisterCompressor(&copyCompressor{codec: CodecNone}))
+
+		// every level of flate shares the codec.
+		assert.NoError(t, RegisterCompressor(NewFlateCompressor(flate.BestSpeed)))
+		assert.NoError(t, RegisterCompressor(NewFlateCompressor(flate.BestCompression)))
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		n := New(0)
+		n.SetCompressor(NewFlateCompressor(flate.BestSpeed))
+		assert.That(t, n.Insert([]byte("key"), []byte("value"), 0, 0))
+
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		buf[28] = 255
+
+		_, err = Load(buf)
+		assert.Error(t, err)
+	})
+}
+
+// copyCompressor is a Compressor with any codec that does not compress.
+type copyCompressor struct{ codec uint8 }
+
+func (c *copyCompressor) Codec() uint8                             { return c.codec }
+func (c *copyCompressor) Compress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+func (c *copyCompressor) Decompress(dst, src []byte) error         { copy(dst, src); return nil }
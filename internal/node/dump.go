@@ -2,4 +2,10 @@ package node
 
 import "github.com/zeebo/wosl/internal/node/btree"
 
-func Dump(n *T) { btree.Dump(&n.entries, n.buf[n.base:]) }
+func Dump(n *T) {
+	entries := n.entries
+	if n.frozen != nil {
+		entries = n.frozen.thaw(n.entries.Fanout())
+	}
+	btree.Dump(&entries, n.data())
+}
@@ -1,13 +1,24 @@
 package entry
 
+import "encoding/binary"
+
 // we require that keys are < 32KB and that values are < 32KB.
 // that means we have 15 bits for keys, and 15 bits for values.
-// pack the kind into 2 bits, and we use a uint32 for all of them.
+// pack the tombstone and pointer flags into 2 bits, and we use a uint32
+// for all of them. a pointer entry has a value that is a reference into
+// some value log rather than the value itself. a tombstone never has a
+// pointer, so an entry with both flags set is a merge entry instead, whose
+// value is an operand to combine with the values of older entries. a
+// tombstone with a value is a range tombstone, deleting every key from its
+// key up to but not including its value.
 // we use another uint32 to describe the offset into some stream
 // that the key + value are stored. we use 4 more bytes to store
 // the prefix of the key so that we can do comparisons on those
-// without having to read the key in some cases. this makes an
-// entry a compact 16 bytes, allowing 4 to fit in a cache line.
+// without having to read the key in some cases. every entry is stamped
+// with a uint64 sequence number, the top bit of which marks a record that
+// is an older version of some entry rather than an entry itself, and the
+// next bit marks an expiring entry, whose value starts with the time it
+// expires at, before any value log pointer. this makes an entry 24 bytes.
 
 const (
 	KeyShift = 0
@@ -21,14 +32,48 @@ const (
 	TombstoneShift = ValueShift + ValueBits
 	TombstoneBits  = 1
 	TombstoneMask  = 1<<TombstoneBits - 1
+
+	PointerShift = TombstoneShift + TombstoneBits
+	PointerBits  = 1
+	PointerMask  = 1<<PointerBits - 1
 )
 
+// HeaderSize is how many bytes the header that precedes every key and value
+// in a stream is. It holds everything about the entry that can't be derived
+// from where it is in the stream, so that a stream can be read without the
+// entries that point into it.
+const HeaderSize = (0 +
+	4 + // key+value+tombstone+pointer
+	4 + // pivot
+	8 + // sequence number+superseded+expires
+	4 + // offset of the previous version
+	0)
+
+// supersededBit is set in the sequence number of a record that is an older
+// version of an entry that has since been overwritten.
+const supersededBit = 1 << 63
+
+// expiresBit is set in the sequence number of an entry whose value starts
+// with the time it expires at.
+const expiresBit = 1 << 62
+
+// seqFlags are the bits of the sequence number that are not part of it.
+const seqFlags = supersededBit | expiresBit
+
+// MaxSeq is the largest sequence number an entry can have.
+const MaxSeq = expiresBit - 1
+
+// ExpirySize is how many bytes the expiration time at the start of the value
+// of an expiring entry is.
+const ExpirySize = 8
+
 // T represents an entry in some key value store.
 type T struct {
 	Prefix [4]byte // first four bytes of the key
-	kvt    uint32  // bitpacked key+value+tombstone
+	kvt    uint32  // bitpacked key+value+tombstone+pointer
 	pivot  uint32  // 0 means no pivot: there is no block 0.
 	offset uint32  // offset into the stream
+	seq    uint64  // sequence number with the superseded and expires bits
 }
 
 // New constructs an entry with the given parameters all bitpacked.
@@ -59,8 +104,75 @@ func (e T) Key() uint32 { return uint32(e.kvt>>KeyShift) & KeyMask }
 // Value returns how many bytes of value there are.
 func (e T) Value() uint32 { return uint32(e.kvt>>ValueShift) & ValueMask }
 
+// mergeBits are the bits that are set in a merge entry.
+const mergeBits = TombstoneMask<<TombstoneShift | PointerMask<<PointerShift
+
 // Tombstone returns true if the entry is a tombstone.
-func (e T) Tombstone() bool { return uint8(e.kvt>>TombstoneShift)&TombstoneMask > 0 }
+func (e T) Tombstone() bool { return e.kvt&mergeBits == TombstoneMask<<TombstoneShift }
+
+// Pointer returns true if the value of the entry is a value log pointer.
+func (e T) Pointer() bool { return e.kvt&mergeBits == PointerMask<<PointerShift }
+
+// Range returns true if the entry is a tombstone for every key from its key
+// up to but not including its value.
+func (e T) Range() bool { return e.Tombstone() && e.Value() > 0 }
+
+// Merge returns true if the value of the entry is a merge operand.
+func (e T) Merge() bool { return e.kvt&mergeBits == mergeBits }
+
+// SetMerge updates if the value of the entry is a merge operand.
+func (e *T) SetMerge(merge bool) {
+	e.kvt &^= mergeBits
+	if merge {
+		e.kvt |= mergeBits
+	}
+}
+
+// SetPointer updates if the value of the entry is a value log pointer.
+func (e *T) SetPointer(pointer bool) {
+	e.kvt &^= PointerMask << PointerShift
+	if pointer {
+		e.kvt |= PointerMask << PointerShift
+	}
+}
+
+// Expires returns true if the value of the entry starts with the time the
+// entry expires at.
+func (e T) Expires() bool { return e.seq&expiresBit != 0 }
+
+// SetExpires updates if the value of the entry starts with the time the
+// entry expires at.
+func (e *T) SetExpires(expires bool) {
+	e.seq &^= expiresBit
+	if expires {
+		e.seq |= expiresBit
+	}
+}
+
+// SetKey updates the length and prefix of the key of the entry.
+func (e *T) SetKey(key []byte) {
+	e.Prefix = [4]byte{}
+	copy(e.Prefix[:], key)
+	e.kvt = e.kvt&^(KeyMask<<KeyShift) | uint32(len(key)&KeyMask)<<KeyShift
+}
+
+// Seq returns the sequence number of the entry.
+func (e T) Seq() uint64 { return e.seq &^ seqFlags }
+
+// SetSeq updates the sequence number of the entry.
+func (e *T) SetSeq(seq uint64) { e.seq = e.seq&seqFlags | seq&MaxSeq }
+
+// Superseded returns true if the record is an older version of an entry
+// rather than an entry itself.
+func (e T) Superseded() bool { return e.seq&supersededBit != 0 }
+
+// SetSuperseded updates if the record is an older version of an entry.
+func (e *T) SetSuperseded(superseded bool) {
+	e.seq &^= supersededBit
+	if superseded {
+		e.seq |= supersededBit
+	}
+}
 
 // Offset returns the offset of the entry.
 func (e T) Offset() uint32 { return e.offset }
@@ -89,3 +201,137 @@ func (e T) ReadValue(buf []byte) []byte {
 func (e T) ReadEntry(buf []byte) []byte {
 	return buf[e.offset : e.offset+e.Key()+e.Value()]
 }
+
+// AppendExpiry appends the expiration time, in nanoseconds since the unix
+// epoch, to buf in the form it has at the start of an expiring value.
+func AppendExpiry(buf []byte, expiry int64) []byte {
+	var exp [ExpirySize]byte
+	binary.BigEndian.PutUint64(exp[:], uint64(expiry))
+	return append(buf, exp[:]...)
+}
+
+// ReadExpiry splits the value of an expiring entry into the expiration time
+// and the rest of the value. It returns false if the value is too short.
+func ReadExpiry(value []byte) (int64, []byte, bool) {
+	if len(value) < ExpirySize {
+		return 0, nil, false
+	}
+	return int64(binary.BigEndian.Uint64(value)), value[ExpirySize:], true
+}
+
+// AppendHeader appends the header describing the entry to buf. The prev
+// argument is the offset of the previous version of the entry, or 0 if it
+// has none.
+func (e T) AppendHeader(buf []byte, prev uint32) []byte {
+	var hdr [HeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:4], e.kvt)
+	binary.BigEndian.PutUint32(hdr[4:8], e.pivot)
+	binary.BigEndian.PutUint64(hdr[8:16], e.seq)
+	binary.BigEndian.PutUint32(hdr[16:20], prev)
+	return append(buf, hdr[:]...)
+}
+
+// SetPrev updates the offset of the previous version in the header.
+func SetPrev(hdr []byte, prev uint32) {
+	binary.BigEndian.PutUint32(hdr[16:20], prev)
+}
+
+// ReadRecord parses the header, key and value starting at the offset into
+// the stream. It returns the entry, pointing at the key just past the
+// header, and the offset of the next record. It returns false if the
+// record is truncated.
+func ReadRecord(buf []byte, offset uint32) (T, uint32, bool) {
+	if uint64(offset)+HeaderSize > uint64(len(buf)) {
+		return T{}, 0, false
+	}
+	hdr := buf[offset : offset+HeaderSize]
+
+	e := T{
+		kvt:    binary.BigEndian.Uint32(hdr[0:4]),
+		pivot:  binary.BigEndian.Uint32(hdr[4:8]),
+		seq:    binary.BigEndian.Uint64(hdr[8:16]),
+		offset: offset + HeaderSize,
+	}
+	next := uint64(e.offset) + uint64(e.Key()) + uint64(e.Value())
+	if next > uint64(len(buf)) {
+		return T{}, 0, false
+	}
+	copy(e.Prefix[:], e.ReadKey(buf))
+
+	return e, uint32(next), true
+}
+
+// RecordLength returns how many bytes the record starting with the header
+// takes up, including the header. It returns false if hdr is shorter than a
+// header.
+func RecordLength(hdr []byte) (uint32, bool) {
+	if len(hdr) < HeaderSize {
+		return 0, false
+	}
+	e := T{kvt: binary.BigEndian.Uint32(hdr[0:4])}
+	return HeaderSize + e.Key() + e.Value(), true
+}
+
+// Buffer holds the data that entries point into. It is split into an
+// immutable loaded segment and a segment that is appended to, so that adding
+// data never has to copy the loaded segment. Offsets past the end of the
+// loaded segment address the append segment.
+type Buffer struct {
+	Loaded []byte
+	Append []byte
+}
+
+// Len returns how many bytes are in both segments of the buffer.
+func (b Buffer) Len() uint32 { return uint32(len(b.Loaded) + len(b.Append)) }
+
+// segment returns the segment containing the data for the entry along with
+// the entry adjusted to be relative to that segment.
+func (b Buffer) segment(e T) ([]byte, T) {
+	if loaded := uint32(len(b.Loaded)); e.offset >= loaded {
+		e.offset -= loaded
+		return b.Append, e
+	}
+	return b.Loaded, e
+}
+
+// Key returns a slice of the buffer that contains the key of the entry.
+func (b Buffer) Key(e T) []byte {
+	buf, e := b.segment(e)
+	return e.ReadKey(buf)
+}
+
+// Value returns a slice of the buffer that contains the value of the entry.
+func (b Buffer) Value(e T) []byte {
+	buf, e := b.segment(e)
+	return e.ReadValue(buf)
+}
+
+// Prev returns the previous version of the entry, if it has one.
+func (b Buffer) Prev(e T) (T, bool) {
+	prev := b.PrevOffset(e)
+	if prev < HeaderSize {
+		return T{}, false
+	}
+
+	buf, p := b.segment(T{offset: prev})
+	ent, _, ok := ReadRecord(buf, p.offset-HeaderSize)
+	if !ok {
+		return T{}, false
+	}
+	ent.offset = prev
+	return ent, true
+}
+
+// PrevOffset returns the offset of the previous version of the entry, or 0
+// if it has none.
+func (b Buffer) PrevOffset(e T) uint32 {
+	buf, e := b.segment(e)
+	return binary.BigEndian.Uint32(buf[e.offset-HeaderSize+16:])
+}
+
+// Entry returns a slice of the buffer that contains the combined key and
+// value of the entry.
+func (b Buffer) Entry(e T) []byte {
+	buf, e := b.segment(e)
+	return e.ReadEntry(buf)
+}
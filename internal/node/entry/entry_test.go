@@ -14,5 +14,191 @@ func TestEntry(t *testing.T) {
 		assert.Equal(t, ent.Value(), 2)
 		assert.Equal(t, ent.Tombstone(), true)
 		assert.Equal(t, ent.Offset(), 4)
+		assert.Equal(t, ent.Pointer(), false)
+	})
+
+	t.Run("SetKey", func(t *testing.T) {
+		ent := New([]byte("prefix"), make([]byte, 2), true, 4)
+		ent.SetKey([]byte("fix"))
+		assert.Equal(t, ent.Key(), 3)
+		assert.Equal(t, string(ent.Prefix[:]), "fix\x00")
+		assert.Equal(t, ent.Value(), 2)
+		assert.Equal(t, ent.Tombstone(), true)
+	})
+
+	t.Run("Pointer", func(t *testing.T) {
+		ent := New(make([]byte, 1), make([]byte, 2), false, 4)
+		ent.SetPointer(true)
+		assert.Equal(t, ent.Pointer(), true)
+		assert.Equal(t, ent.Tombstone(), false)
+		assert.Equal(t, ent.Key(), 1)
+		assert.Equal(t, ent.Value(), 2)
+
+		ent.SetPointer(false)
+		assert.Equal(t, ent.Pointer(), false)
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		ent := New(make([]byte, 1), make([]byte, 2), false, 4)
+		ent.SetMerge(true)
+		assert.Equal(t, ent.Merge(), true)
+		assert.Equal(t, ent.Pointer(), false)
+		assert.Equal(t, ent.Tombstone(), false)
+		assert.Equal(t, ent.Key(), 1)
+		assert.Equal(t, ent.Value(), 2)
+
+		ent.SetMerge(false)
+		assert.Equal(t, ent.Merge(), false)
+		assert.Equal(t, ent.Pointer(), false)
+		assert.Equal(t, ent.Tombstone(), false)
+
+		ent = New(make([]byte, 1), nil, true, 4)
+		assert.Equal(t, ent.Merge(), false)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		ent := New([]byte("a"), []byte("b"), true, 4)
+		assert.Equal(t, ent.Tombstone(), true)
+		assert.Equal(t, ent.Range(), true)
+
+		ent = New([]byte("a"), nil, true, 4)
+		assert.Equal(t, ent.Range(), false)
+
+		ent = New([]byte("a"), []byte("b"), false, 4)
+		ent.SetMerge(true)
+		assert.Equal(t, ent.Range(), false)
+	})
+
+	t.Run("Expires", func(t *testing.T) {
+		ent := New(make([]byte, KeyMask), make([]byte, ValueMask), false, 4)
+		ent.SetExpires(true)
+		assert.Equal(t, ent.Expires(), true)
+		assert.Equal(t, ent.Key(), KeyMask)
+		assert.Equal(t, ent.Value(), ValueMask)
+		assert.Equal(t, ent.Tombstone(), false)
+
+		ent.SetPointer(true)
+		assert.Equal(t, ent.Expires(), true)
+		assert.Equal(t, ent.Pointer(), true)
+
+		ent.SetSeq(MaxSeq)
+		ent.SetSuperseded(true)
+		assert.Equal(t, ent.Expires(), true)
+		assert.Equal(t, ent.Seq(), uint64(MaxSeq))
+
+		ent.SetExpires(false)
+		assert.Equal(t, ent.Expires(), false)
+		assert.Equal(t, ent.Superseded(), true)
+		assert.Equal(t, ent.Seq(), uint64(MaxSeq))
+		assert.Equal(t, ent.Key(), KeyMask)
+
+		value := AppendExpiry(nil, 1234)
+		value = append(value, "value"...)
+		expiry, rest, ok := ReadExpiry(value)
+		assert.That(t, ok)
+		assert.Equal(t, expiry, 1234)
+		assert.Equal(t, string(rest), "value")
+
+		_, _, ok = ReadExpiry(value[:ExpirySize-1])
+		assert.That(t, !ok)
+	})
+
+	t.Run("Seq", func(t *testing.T) {
+		ent := New([]byte("key"), nil, false, 0)
+		ent.SetSeq(MaxSeq)
+		assert.Equal(t, ent.Seq(), uint64(MaxSeq))
+		assert.That(t, !ent.Superseded())
+
+		ent.SetSuperseded(true)
+		assert.That(t, ent.Superseded())
+		assert.Equal(t, ent.Seq(), uint64(MaxSeq))
+
+		ent.SetSeq(5)
+		assert.That(t, ent.Superseded())
+		assert.Equal(t, ent.Seq(), 5)
+
+		ent.SetSuperseded(false)
+		assert.That(t, !ent.Superseded())
+		assert.Equal(t, ent.Seq(), 5)
+	})
+
+	t.Run("Prev", func(t *testing.T) {
+		ent1 := New([]byte("key"), []byte("old"), false, HeaderSize)
+		ent1.SetSeq(1)
+		ent1.SetSuperseded(true)
+		loaded := ent1.AppendHeader(nil, 0)
+		loaded = append(loaded, "keyold"...)
+
+		ent2 := New([]byte("key"), []byte("new"), false, uint32(len(loaded))+HeaderSize)
+		ent2.SetSeq(2)
+		app := ent2.AppendHeader(nil, 0)
+		SetPrev(app, ent1.Offset())
+		app = append(app, "keynew"...)
+
+		buf := Buffer{Loaded: loaded, Append: app}
+		assert.Equal(t, buf.PrevOffset(ent2), ent1.Offset())
+
+		prev, ok := buf.Prev(ent2)
+		assert.That(t, ok)
+		assert.Equal(t, prev, ent1)
+		assert.Equal(t, string(buf.Value(prev)), "old")
+
+		_, ok = buf.Prev(prev)
+		assert.That(t, !ok)
+	})
+
+	t.Run("Buffer", func(t *testing.T) {
+		buf := Buffer{
+			Loaded: []byte("keyvalue"),
+			Append: []byte("kv"),
+		}
+		assert.Equal(t, buf.Len(), 10)
+
+		ent := New([]byte("key"), []byte("value"), false, 0)
+		assert.Equal(t, string(buf.Key(ent)), "key")
+		assert.Equal(t, string(buf.Value(ent)), "value")
+		assert.Equal(t, string(buf.Entry(ent)), "keyvalue")
+
+		ent = New([]byte("k"), []byte("v"), false, 8)
+		assert.Equal(t, string(buf.Key(ent)), "k")
+		assert.Equal(t, string(buf.Value(ent)), "v")
+		assert.Equal(t, string(buf.Entry(ent)), "kv")
+	})
+
+	t.Run("Record", func(t *testing.T) {
+		ent1 := New([]byte("key"), []byte("value"), false, HeaderSize)
+		ent1.SetPivot(7)
+		ent1.SetPointer(true)
+		ent1.SetSeq(9)
+		ent2 := New([]byte("k2"), nil, true, 0)
+
+		buf := ent1.AppendHeader(nil, 0)
+		buf = append(buf, "keyvalue"...)
+		buf = ent2.AppendHeader(buf, 0)
+		buf = append(buf, "k2"...)
+
+		got, next, ok := ReadRecord(buf, 0)
+		assert.That(t, ok)
+		assert.Equal(t, got, ent1)
+		assert.Equal(t, string(got.ReadKey(buf)), "key")
+		assert.Equal(t, string(got.ReadValue(buf)), "value")
+
+		got, next, ok = ReadRecord(buf, next)
+		assert.That(t, ok)
+		assert.Equal(t, got.Key(), 2)
+		assert.Equal(t, got.Tombstone(), true)
+		assert.Equal(t, string(got.ReadKey(buf)), "k2")
+		assert.Equal(t, next, len(buf))
+
+		_, _, ok = ReadRecord(buf[:len(buf)-1], next-HeaderSize-2)
+		assert.That(t, !ok)
+		_, _, ok = ReadRecord(buf, next)
+		assert.That(t, !ok)
+
+		length, ok := RecordLength(buf)
+		assert.That(t, ok)
+		assert.Equal(t, length, HeaderSize+8)
+		_, ok = RecordLength(buf[:HeaderSize-1])
+		assert.That(t, !ok)
 	})
 }
@@ -0,0 +1,121 @@
+package node
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// Fences returns the smallest and largest keys of the entries in the node,
+// and false if the node has no entries. Range tombstones in the node may
+// cover keys past the largest one. It is not safe to modify the returned
+// slices.
+func (t *T) Fences() (lo, hi []byte, ok bool) {
+	return t.lo, t.hi, t.lo != nil
+}
+
+// InFences returns true if the key is between the fences of the node.
+func (t *T) InFences(key []byte) bool {
+	return t.lo != nil && bytes.Compare(key, t.lo) >= 0 && bytes.Compare(key, t.hi) <= 0
+}
+
+// widen extends the fences to include the key, which has the prefix of the
+// node removed.
+func (t *T) widen(key []byte) {
+	if t.lo == nil || bytes.Compare(key, t.lo[len(t.prefix):]) < 0 {
+		t.lo = t.fullKey(key)
+	}
+	if t.hi == nil || bytes.Compare(key, t.hi[len(t.prefix):]) > 0 {
+		t.hi = t.fullKey(key)
+	}
+}
+
+// refence sets the fences to the smallest and largest keys in the node.
+func (t *T) refence() {
+	first, last, ok := t.ends()
+	if !ok {
+		t.lo, t.hi = nil, nil
+		return
+	}
+	src := t.data()
+	t.lo, t.hi = t.fullKey(src.Key(first)), t.fullKey(src.Key(last))
+}
+
+// ends returns the first and last entries in the node, and false if it has
+// no entries.
+func (t *T) ends() (first, last entry.T, ok bool) {
+	if t.frozen != nil {
+		ents := t.frozen.ents
+		if len(ents) == 0 {
+			return first, last, false
+		}
+		return ents[0], ents[len(ents)-1], true
+	}
+
+	iter := t.entries.Iterator()
+	if !iter.Seek(nil, t.data()) {
+		return first, last, false
+	}
+	first = iter.Entry()
+	iter.Last()
+	return first, iter.Entry(), true
+}
+
+// fullKey returns a copy of the key, which has the prefix of the node
+// removed, with the prefix added back. It is never nil.
+func (t *T) fullKey(key []byte) []byte {
+	full := make([]byte, 0, len(t.prefix)+len(key))
+	full = append(full, t.prefix...)
+	return append(full, key...)
+}
+
+// fencesLength returns how many bytes the fences take up when written with
+// the prefix removed from them.
+func fencesLength(lo, hi, prefix []byte) uint64 {
+	if lo == nil {
+		return 0
+	}
+	return 4 + uint64(len(lo)+len(hi)-2*len(prefix))
+}
+
+// appendFences appends the fences, each preceded by its length, with the
+// prefix removed from them. Nothing is appended if there are no fences.
+func appendFences(buf, lo, hi, prefix []byte) []byte {
+	if lo == nil {
+		return buf
+	}
+	lo, hi = lo[len(prefix):], hi[len(prefix):]
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(lo)))
+	buf = append(buf, lo...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(hi)))
+	return append(buf, hi...)
+}
+
+// readFences reads the fences written by appendFences, adding the prefix
+// back on to them. The returned keys do not alias buf.
+func readFences(buf, prefix []byte) (lo, hi []byte, err error) {
+	if len(buf) == 0 {
+		return nil, nil, nil
+	}
+	read := func() ([]byte, error) {
+		if len(buf) < 2 {
+			return nil, Error.New("truncated fences")
+		}
+		n := int(binary.BigEndian.Uint16(buf))
+		if len(buf) < 2+n {
+			return nil, Error.New("truncated fences")
+		}
+		key := make([]byte, 0, len(prefix)+n)
+		key = append(append(key, prefix...), buf[2:2+n]...)
+		buf = buf[2+n:]
+		return key, nil
+	}
+	if lo, err = read(); err != nil {
+		return nil, nil, err
+	}
+	if hi, err = read(); err != nil {
+		return nil, nil, err
+	}
+	return lo, hi, nil
+}
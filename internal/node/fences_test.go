@@ -0,0 +1,89 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestFences(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("prefix/%04d", i)) }
+
+	check := func(t *testing.T, n *T, lo, hi int) {
+		t.Helper()
+		glo, ghi, ok := n.Fences()
+		assert.That(t, ok)
+		assert.Equal(t, string(glo), string(key(lo)))
+		assert.Equal(t, string(ghi), string(key(hi)))
+		assert.That(t, n.InFences(key(lo)) && n.InFences(key(hi)))
+		assert.That(t, !n.InFences(key(lo-1)) && !n.InFences(key(hi+1)))
+	}
+
+	t.Run("Insert+Remove", func(t *testing.T) {
+		n := New(0)
+		_, _, ok := n.Fences()
+		assert.That(t, !ok)
+		assert.That(t, !n.MayContain(key(0)))
+
+		for i := 10; i < 20; i++ {
+			assert.That(t, n.Insert(key(i), nil, 0, 0))
+		}
+		check(t, n, 10, 19)
+
+		assert.That(t, n.Insert(key(5), nil, 0, 0))
+		assert.That(t, n.Insert(key(50), nil, 0, 0))
+		check(t, n, 5, 50)
+
+		assert.That(t, n.Remove(key(5)))
+		assert.That(t, n.Remove(key(50)))
+		assert.That(t, n.Remove(key(15)))
+		check(t, n, 10, 19)
+
+		for i := 10; i < 20; i++ {
+			n.Remove(key(i))
+		}
+		_, _, ok = n.Fences()
+		assert.That(t, !ok)
+	})
+
+	t.Run("Write+Load", func(t *testing.T) {
+		for _, comp := range []Compressor{nil, NewFlateCompressor(1)} {
+			n := New(0)
+			n.SetCompressor(comp)
+			for i := 100; i < 200; i++ {
+				assert.That(t, n.Insert(key(i), []byte("value"), 0, 0))
+			}
+
+			buf, err := n.Write(nil)
+			assert.NoError(t, err)
+			check(t, n, 100, 199)
+
+			lo, hi, err := ReadFences(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, string(lo), string(key(100)))
+			assert.Equal(t, string(hi), string(key(199)))
+
+			n, err = Load(buf)
+			assert.NoError(t, err)
+			check(t, n, 100, 199)
+		}
+
+		// empty nodes have no fences.
+		buf, err := New(0).Write(nil)
+		assert.NoError(t, err)
+		lo, hi, err := ReadFences(buf)
+		assert.NoError(t, err)
+		assert.Nil(t, lo)
+		assert.Nil(t, hi)
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		var bu Bulk
+		for i := 100; i < 200; i++ {
+			assert.That(t, bu.Append(key(i), nil, false, 0, 0))
+		}
+		n := bu.Done(0)
+		check(t, n, 100, 199)
+	})
+}
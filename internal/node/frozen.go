@@ -0,0 +1,117 @@
+package node
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/zeebo/wosl/internal/node/btree"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// frozenStride is how many entries are covered by each index entry.
+const frozenStride = 16
+
+// frozen is a read-optimized form of the entries of a node that is never
+// inserted into. It is a sorted array of the entries along with a sparse
+// index of every frozenStride'th entry, whose full keys separate the
+// strides, which is much smaller than a btree sized for inserts. It is
+// converted to a btree the first time the node is modified.
+type frozen struct {
+	ents  []entry.T // every entry in sorted order
+	index []entry.T // every frozenStride'th entry
+}
+
+// newFrozen returns the frozen form of the entries, which must be sorted
+// with no duplicates.
+func newFrozen(ents []entry.T) *frozen {
+	index := make([]entry.T, 0, (len(ents)+frozenStride-1)/frozenStride)
+	for i := 0; i < len(ents); i += frozenStride {
+		index = append(index, ents[i])
+	}
+	return &frozen{
+		ents:  ents,
+		index: index,
+	}
+}
+
+// loadFrozen constructs the frozen form of the entries by reading the
+// records in data, which must be sorted with no duplicates other than the
+// records of older versions.
+func loadFrozen(data []byte) (*frozen, error) {
+	var ents []entry.T
+	for offset := uint32(0); offset < uint32(len(data)); {
+		ent, next, ok := entry.ReadRecord(data, offset)
+		if !ok {
+			return nil, Error.New("truncated record at offset: %d", offset)
+		}
+		if !ent.Superseded() {
+			ents = append(ents, ent)
+		}
+		offset = next
+	}
+	return newFrozen(ents), nil
+}
+
+// frozenIndexLength returns how many bytes an index with n entries takes up
+// when written.
+func frozenIndexLength(n int) uint64 { return 4 * uint64(n) }
+
+// appendIndex appends the offsets of the entries in the index, which is
+// written with the node so that it can be searched one stride at a time
+// without loading every entry.
+func (f *frozen) appendIndex(buf []byte) []byte {
+	var tmp [4]byte
+	for _, ent := range f.index {
+		binary.BigEndian.PutUint32(tmp[:], ent.Offset())
+		buf = append(buf, tmp[:]...)
+	}
+	return buf
+}
+
+// Count returns how many entries there are.
+func (f *frozen) Count() uint32 { return uint32(len(f.ents)) }
+
+// find returns the index of the first entry with a key greater than or
+// equal to the key, or the number of entries if there is none.
+func (f *frozen) find(key []byte, buf entry.Buffer) int {
+	// find the first stride that starts with a key greater than the key.
+	// every entry before the stride preceding it is smaller than the key.
+	lo, hi := 0, len(f.index)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(buf.Key(f.index[mid]), key) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return 0
+	}
+
+	// binary search within the stride. if every key in it is smaller, the
+	// answer is the start of the next stride.
+	lo, hi = (lo-1)*frozenStride, lo*frozenStride
+	if hi > len(f.ents) {
+		hi = len(f.ents)
+	}
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if bytes.Compare(buf.Key(f.ents[mid]), key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// thaw returns a btree with the fanout containing every entry.
+func (f *frozen) thaw(fanout uint16) btree.T {
+	var bu btree.Bulk
+	bu.SetFanout(fanout)
+	for _, ent := range f.ents {
+		bu.Append(ent)
+	}
+	return bu.Done()
+}
@@ -0,0 +1,70 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/internal/node/btree"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+func TestFrozen(t *testing.T) {
+	newBulk := func(n int) *T {
+		var bu Bulk
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("key%04d", 2*i))
+			assert.That(t, bu.Append(key, key, false, 0, 0))
+		}
+		return bu.Done(0)
+	}
+
+	t.Run("Find", func(t *testing.T) {
+		n := newBulk(1000)
+		data := n.data()
+
+		assert.Equal(t, string(n.prefix), "key")
+		for i := 0; i < 2000; i++ {
+			key := []byte(fmt.Sprintf("%04d", i))
+			idx := n.frozen.find(key, data)
+			assert.Equal(t, idx, (i+1)/2)
+			if idx < len(n.frozen.ents) {
+				assert.That(t, bytes.Compare(data.Key(n.frozen.ents[idx]), key) >= 0)
+			}
+		}
+
+		assert.Equal(t, n.frozen.find([]byte("/"), data), 0)
+		assert.Equal(t, n.frozen.find([]byte("a"), data), 1000)
+		assert.Equal(t, newBulk(0).frozen.find([]byte("a"), data), 0)
+	})
+
+	t.Run("Thaw", func(t *testing.T) {
+		n := newBulk(1000)
+		entries := n.frozen.thaw(btree.DefaultFanout)
+		assert.Equal(t, entries.Count(), n.frozen.Count())
+
+		i := 0
+		entries.Iter(func(ent *entry.T) bool {
+			assert.Equal(t, *ent, n.frozen.ents[i])
+			i++
+			return true
+		})
+		assert.Equal(t, i, 1000)
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		n := newBulk(1000)
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		assert.That(t, uint64(len(buf)) <= n.Length())
+
+		f, err := loadFrozen(n.data().Loaded)
+		assert.NoError(t, err)
+		assert.DeepEqual(t, f.ents, n.frozen.ents)
+		assert.DeepEqual(t, f.index, n.frozen.index)
+
+		_, err = loadFrozen(n.data().Loaded[:len(n.data().Loaded)-1])
+		assert.Error(t, err)
+	})
+}
@@ -1,17 +1,206 @@
 package node
 
 import (
+	"bytes"
+
 	"github.com/zeebo/wosl/internal/node/btree"
 	"github.com/zeebo/wosl/internal/node/entry"
 )
 
-// Iterator walks over the entries in a node.
+// Iterator walks over the entries in a node. It reads the entries from the
+// frozen form if the node has one, and otherwise from the btree. Until it is
+// positioned by a seek, it streams through the loaded buffer instead if
+// nothing has been appended to the node.
 type Iterator struct {
-	buf  []byte
-	iter btree.Iterator
+	buf    entry.Buffer
+	prefix []byte
+	key    []byte
+	iter   btree.Iterator
+	frozen *frozen // frozen entries to walk instead of the btree (or nil)
+	idx    int     // index of the current frozen entry
+	scan   bool    // if the entries are read directly from the loaded buffer
+	off    uint32  // offset of the next record when scanning
+	ent    entry.T // current entry when scanning
+}
+
+// Next advances the iterator and returns true if there is an entry.
+func (i *Iterator) Next() bool {
+	switch {
+	case i.frozen != nil:
+		if i.idx+1 >= len(i.frozen.ents) {
+			i.idx = len(i.frozen.ents)
+			return false
+		}
+		i.idx++
+		return true
+
+	case i.scan:
+		// skip over the records of older versions, which are only
+		// reachable from the entries that superseded them.
+		for {
+			ent, next, ok := entry.ReadRecord(i.buf.Loaded, i.off)
+			if !ok {
+				return false
+			}
+			i.ent, i.off = ent, next
+			if !ent.Superseded() {
+				return true
+			}
+		}
+
+	default:
+		return i.iter.Next()
+	}
+}
+
+// Prev moves the iterator to the previous entry and returns true if there
+// is one. It is only valid to call this after the iterator is positioned
+// on an entry.
+func (i *Iterator) Prev() bool {
+	switch {
+	case i.frozen != nil:
+		if i.idx <= 0 {
+			i.idx = -1
+			return false
+		}
+		i.idx--
+		return true
+
+	case i.scan:
+		// the records can't be read backwards, so switch to the btree.
+		i.scan = false
+		if !i.iter.Seek(i.buf.Key(i.ent), i.buf) {
+			return false
+		}
+		return i.iter.Prev()
+
+	default:
+		return i.iter.Prev()
+	}
+}
+
+// relative returns the key with the prefix of the node removed. If the key
+// does not have the prefix, it instead returns -1 if the key sorts before
+// every key in the node, and 1 if it sorts after.
+func (i *Iterator) relative(key []byte) ([]byte, int) {
+	if bytes.HasPrefix(key, i.prefix) {
+		return key[len(i.prefix):], 0
+	}
+	return nil, bytes.Compare(key, i.prefix)
+}
+
+// Seek positions the iterator at the first entry with a key greater than or
+// equal to the key. It returns true if there is such an entry.
+func (i *Iterator) Seek(key []byte) bool {
+	i.scan = false
+	suffix, cmp := i.relative(key)
+
+	if i.frozen != nil {
+		switch cmp {
+		case -1:
+			i.idx = 0
+		case 0:
+			i.idx = i.frozen.find(suffix, i.buf)
+		case 1:
+			i.idx = len(i.frozen.ents)
+		}
+		return i.idx < len(i.frozen.ents)
+	}
+
+	switch cmp {
+	case -1:
+		return i.iter.Seek(nil, i.buf)
+	case 0:
+		return i.iter.Seek(suffix, i.buf)
+	default:
+		i.iter.Last()
+		return i.iter.Next()
+	}
+}
+
+// SeekLT positions the iterator at the last entry with a key strictly less
+// than the key. It returns true if there is such an entry.
+func (i *Iterator) SeekLT(key []byte) bool {
+	i.scan = false
+	suffix, cmp := i.relative(key)
+
+	if i.frozen != nil {
+		switch cmp {
+		case -1:
+			i.idx = -1
+		case 0:
+			i.idx = i.frozen.find(suffix, i.buf) - 1
+		case 1:
+			i.idx = len(i.frozen.ents) - 1
+		}
+		return i.idx >= 0
+	}
+
+	switch cmp {
+	case -1:
+		return i.iter.SeekLT(nil, i.buf)
+	case 0:
+		return i.iter.SeekLT(suffix, i.buf)
+	default:
+		return i.iter.Last()
+	}
+}
+
+// Entry returns the current entry.
+func (i *Iterator) Entry() entry.T {
+	switch {
+	case i.frozen != nil:
+		return i.frozen.ents[i.idx]
+	case i.scan:
+		return i.ent
+	default:
+		return i.iter.Entry()
+	}
 }
 
-func (i *Iterator) Next() bool     { return i.iter.Next() }
-func (i *Iterator) Entry() entry.T { return i.iter.Entry() }
-func (i *Iterator) Key() []byte    { return i.Entry().ReadKey(i.buf) }
-func (i *Iterator) Value() []byte  { return i.Entry().ReadValue(i.buf) }
+// Value returns the value of the current entry.
+func (i *Iterator) Value() []byte { return i.buf.Value(i.Entry()) }
+
+// At returns the newest version of the current entry with a sequence number
+// at or below seq, and its value. It returns false if the node has no such
+// version, in which case an older one may be in a child.
+func (i *Iterator) At(seq uint64) (entry.T, []byte, bool) {
+	ent, ok := i.Entry(), true
+	for ok && ent.Seq() > seq {
+		ent, ok = i.buf.Prev(ent)
+	}
+	if !ok {
+		return entry.T{}, nil, false
+	}
+	return ent, i.buf.Value(ent), true
+}
+
+// Versions returns every version of the current entry that the node has,
+// oldest first, along with their values.
+func (i *Iterator) Versions() ([]entry.T, [][]byte) {
+	var ents []entry.T
+	for ent, ok := i.Entry(), true; ok; ent, ok = i.buf.Prev(ent) {
+		ents = append(ents, ent)
+	}
+
+	values := make([][]byte, len(ents))
+	for a, b := 0, len(ents)-1; a < b; a, b = a+1, b-1 {
+		ents[a], ents[b] = ents[b], ents[a]
+	}
+	for j, ent := range ents {
+		values[j] = i.buf.Value(ent)
+	}
+	return ents, values
+}
+
+// Key returns the key of the current entry. If the node has a prefix
+// shared by every key, the returned slice is only valid until the next
+// call to Key.
+func (i *Iterator) Key() []byte {
+	key := i.buf.Key(i.Entry())
+	if len(i.prefix) == 0 {
+		return key
+	}
+	i.key = append(append(i.key[:0], i.prefix...), key...)
+	return i.key
+}
@@ -13,7 +13,7 @@ func TestIterator(t *testing.T) {
 
 		for i := 0; i < 100; i++ {
 			buf := []byte(fmt.Sprint(gen.Intn(100)))
-			assert.That(t, n.Insert(buf, nil, 0))
+			assert.That(t, n.Insert(buf, nil, 0, 0))
 		}
 
 		last, iter := "", n.Iterator()
@@ -23,4 +23,75 @@ func TestIterator(t *testing.T) {
 			last = key
 		}
 	})
+
+	t.Run("Seek", func(t *testing.T) {
+		// nodes containing only the even keys, built in every way.
+		key := func(i int) []byte { return []byte(fmt.Sprintf("key/%04d", i)) }
+
+		inserted := New(0)
+		var bu Bulk
+		for i := 0; i < 1000; i += 2 {
+			assert.That(t, inserted.Insert(key(i), key(i), 0, 0))
+			assert.That(t, bu.Append(key(i), key(i), false, 0, 0))
+		}
+		written := New(0)
+		for i := 0; i < 1000; i += 2 {
+			assert.That(t, written.Insert(key(i), key(i), 0, 0))
+		}
+		_, err := written.Write(nil)
+		assert.NoError(t, err)
+		assert.That(t, len(written.prefix) > 0)
+
+		nodes := map[string]*T{
+			"Inserted": inserted,
+			"Written":  written,
+			"Frozen":   bu.Done(0),
+		}
+
+		for name, n := range nodes {
+			t.Run(name, func(t *testing.T) {
+				for i := 0; i < 1000; i++ {
+					ge, lt := (i+1)/2*2, (i-1)/2*2
+
+					iter := n.Iterator()
+					assert.Equal(t, iter.Seek(key(i)), ge < 1000)
+					if ge < 1000 {
+						assert.Equal(t, string(iter.Key()), string(key(ge)))
+						assert.Equal(t, string(iter.Value()), string(key(ge)))
+					}
+					if ge+2 < 1000 {
+						assert.That(t, iter.Next())
+						assert.Equal(t, string(iter.Key()), string(key(ge+2)))
+					}
+
+					iter = n.Iterator()
+					assert.Equal(t, iter.SeekLT(key(i)), i > 0)
+					if i > 0 {
+						assert.Equal(t, string(iter.Key()), string(key(lt)))
+					}
+					if lt-2 >= 0 {
+						assert.That(t, iter.Prev())
+						assert.Equal(t, string(iter.Key()), string(key(lt-2)))
+					}
+				}
+
+				// keys without the prefix sort before or after every key.
+				iter := n.Iterator()
+				assert.That(t, iter.Seek([]byte("a")))
+				assert.Equal(t, string(iter.Key()), string(key(0)))
+				assert.That(t, !iter.Seek([]byte("z")))
+				assert.That(t, !iter.SeekLT([]byte("a")))
+				assert.That(t, iter.SeekLT([]byte("z")))
+				assert.Equal(t, string(iter.Key()), string(key(998)))
+
+				// switching to reverse after a forward scan works.
+				iter = n.Iterator()
+				assert.That(t, iter.Next())
+				assert.That(t, iter.Next())
+				assert.That(t, iter.Prev())
+				assert.Equal(t, string(iter.Key()), string(key(0)))
+				assert.That(t, !iter.Prev())
+			})
+		}
+	})
 }
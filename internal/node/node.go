@@ -1,6 +1,7 @@
 package node
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math"
 
@@ -18,27 +19,71 @@ const nodeHeaderSize = (0 +
 	4 + // height
 	4 + // pivot
 	8 + // btree size
+	8 + // payload size
+	1 + // codec
+	2 + // prefix length
+	8 + // largest sequence number
+	4 + // bloom filter size
+	4 + // fences size
+	4 + // frozen index size
+	4 + // range tombstone count
 	0)
 
-// how many bytes a node header is when padded
-const nodeHeaderPadded = btree.NodeSize - btree.HeaderSize
+// the alignment of the btree written after the header and prefix
+const nodeHeaderAlign = 8
 
-// TODO(jeff): investigate using a [][]byte (or just two []byte) so that we
-// don't have to copy potentially large amounts of data to just append a new
-// key. it may be mmap'd causing a bunch of read traffic for no reason. the
-// buf is append only, anyway, so there has to be some way to optimize.
-// maybe it'll only be slower for typical values of buf (like 4MB or less).
+// the largest prefix whose length can be stored in the header
+const maxPrefix = math.MaxUint16
+
+// paddedLength returns how many bytes a node header followed by a prefix of
+// the given length takes up when padded so that the btree is aligned.
+func paddedLength(prefixLen uint64) uint64 {
+	return (nodeHeaderSize + prefixLen + nodeHeaderAlign - 1) &^ (nodeHeaderAlign - 1)
+}
+
+// headerLength returns an upper bound on how many bytes the padded header
+// takes up when writing a node whose keys share a prefix of the given
+// length. Writing may move more of the keys into the prefix, which grows
+// the header, but every key shrinks by at least as much.
+func headerLength(prefixLen int) uint64 {
+	return paddedLength(uint64(prefixLen)) + nodeHeaderAlign - 1
+}
 
 // T is a node in a write-optimized skip list. It targets a specific size
-// and maintains entry pointers into the buf.
+// and maintains entry pointers into the buf and app. The buf is never
+// modified after it is loaded or written, so that it may be mmap'd and
+// inserts don't have to copy or read it. Entries with offsets past the
+// end of buf point into app.
+//
+// Every key and value in buf and app is preceded by an entry header. The
+// keys and values in buf are always sorted with no duplicates other than
+// the records of older versions, which are marked as superseded, so while
+// nothing has been appended, buf alone describes every entry in the node.
+//
+// Nodes that are produced by a Bulk or loaded without a btree keep their
+// entries in a frozen form until they are first modified, at which point
+// they are converted into a btree.
 type T struct {
-	next    uint32  // pointer to the next node (or 0)
-	height  uint32  // height of the node
-	pivot   uint32  // pivot of the leader
-	buf     []byte  // buffer containing the keys and values
-	base    uint32  // how many bytes into buf the key/values start
-	entries btree.T // btree of entries into buf
-	dirty   bool    // if modifications have happened since the last Write
+	next    uint32     // pointer to the next node (or 0)
+	height  uint32     // height of the node
+	pivot   uint32     // pivot of the leader
+	buf     []byte     // buffer containing the loaded keys and values
+	base    uint32     // how many bytes into buf the key/values start
+	app     []byte     // keys and values appended since loading
+	garbage uint64     // bytes in buf and app of overwritten entries
+	seq     uint64     // largest sequence number of any entry inserted
+	mark    uint64     // versions superseded at or below this are dropped
+	ranges  *ranges    // range tombstones in the node (or nil if not built)
+	pivots  *pivots    // keys with pivots in the node (or nil if not built)
+	entries btree.T    // btree of entries into buf
+	frozen  *frozen    // read-optimized entries, used instead of the btree
+	dirty   bool       // if modifications have happened since the last Write
+	comp    Compressor // compressor used by Write (or nil)
+	prefix  []byte     // prefix stripped from every key in buf
+	bloom   bloom      // filter of the keys in the node (or nil)
+	bits    uint8      // bits per key of the filter built by Write
+	lo      []byte     // smallest key in the node (or nil if empty)
+	hi      []byte     // largest key in the node (or nil if empty)
 }
 
 // New returns a node with a buffer size of the given size.
@@ -52,59 +97,244 @@ var nodeLoadThunk mon.Thunk // timing info for node.Load
 func Load(buf []byte) (*T, error) {
 	timer := nodeLoadThunk.Start()
 
-	if len(buf) < nodeHeaderSize {
+	h, buf, comp, err := unpack(buf)
+	if err != nil {
 		timer.Stop()
-		return nil, Error.New("buffer too small: %d", buf)
+		return nil, err
 	}
-
-	// read in the header
-	var (
-		next      = uint32(binary.BigEndian.Uint32(buf[0:4]))
-		height    = uint32(binary.BigEndian.Uint32(buf[4:8]))
-		pivot     = uint32(binary.BigEndian.Uint32(buf[8:12]))
-		btreeSize = uint64(binary.BigEndian.Uint64(buf[12:20]))
-	)
-
-	if uint64(len(buf)) < nodeHeaderPadded+btreeSize {
-		timer.Stop()
-		return nil, Error.New("buffer too small: %d", len(buf))
+	prefix := buf[nodeHeaderSize : nodeHeaderSize+h.prefixLen]
+	base := h.base()
+
+	// read in the btree, or the frozen entries if the btree was omitted.
+	var entries btree.T
+	var froz *frozen
+	if h.btreeSize == 0 {
+		froz, err = loadFrozen(buf[base:])
+	} else {
+		entries, err = btree.Load(buf[h.padded():])
 	}
-
-	// read in the btree
-	entries, err := btree.Load(buf[nodeHeaderPadded:])
 	if err != nil {
 		timer.Stop()
 		return nil, Error.Wrap(err)
 	}
 
-	base := nodeHeaderPadded + entries.Length()
-	if base > math.MaxUint32 {
+	var filter bloom
+	if h.bloomSize > 0 {
+		filter = bloom(buf[h.padded()+h.btreeSize : h.fences()])
+	}
+
+	lo, hi, err := readFences(buf[h.fences():h.fences()+h.fenceSize], prefix)
+	if err != nil {
 		timer.Stop()
-		return nil, Error.New("internal error: btree too big")
+		return nil, Error.Wrap(err)
 	}
 
 	timer.Stop()
 	return &T{
 		buf:     buf,
-		next:    next,
-		height:  height,
-		pivot:   pivot,
+		next:    h.next,
+		height:  h.height,
+		pivot:   h.pivot,
 		base:    uint32(base),
+		seq:     h.seq,
 		entries: entries,
+		frozen:  froz,
+		comp:    comp,
+		prefix:  prefix,
+		bloom:   filter,
+		lo:      lo,
+		hi:      hi,
 	}, nil
 }
 
+// ReadFences returns the smallest and largest keys of the node written in
+// the buffer without loading its entries. It returns nil keys if the node
+// has no entries.
+func ReadFences(buf []byte) (lo, hi []byte, err error) {
+	h, buf, _, err := unpack(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	fences := h.fences()
+	return readFences(buf[fences:fences+h.fenceSize], buf[nodeHeaderSize:nodeHeaderSize+h.prefixLen])
+}
+
+// header is the fixed size header at the start of a written node.
+type header struct {
+	next      uint32
+	height    uint32
+	pivot     uint32
+	btreeSize uint64
+	payload   uint64
+	codec     uint8
+	prefixLen uint64
+	seq       uint64
+	bloomSize uint64
+	fenceSize uint64
+	indexSize uint64
+	ranges    uint32
+}
+
+// padded returns the offset of the btree in the uncompressed node.
+func (h header) padded() uint64 { return paddedLength(h.prefixLen) }
+
+// fences returns the offset of the fences in the uncompressed node. They
+// are followed by the index of a frozen node, and then the entries.
+func (h header) fences() uint64 {
+	return h.padded() + h.btreeSize + h.bloomSize
+}
+
+// base returns the offset of the entries in the uncompressed node.
+func (h header) base() uint64 {
+	return h.padded() + h.btreeSize + h.bloomSize + h.fenceSize + h.indexSize
+}
+
+// readHeader parses the header at the start of buf, which must include the
+// prefix of the node.
+func readHeader(buf []byte) (h header, err error) {
+	if len(buf) < nodeHeaderSize {
+		return h, Error.New("buffer too small: %d", len(buf))
+	}
+
+	// read in the header
+	h = header{
+		next:      uint32(binary.BigEndian.Uint32(buf[0:4])),
+		height:    uint32(binary.BigEndian.Uint32(buf[4:8])),
+		pivot:     uint32(binary.BigEndian.Uint32(buf[8:12])),
+		btreeSize: uint64(binary.BigEndian.Uint64(buf[12:20])),
+		payload:   uint64(binary.BigEndian.Uint64(buf[20:28])),
+		codec:     uint8(buf[28]),
+		prefixLen: uint64(binary.BigEndian.Uint16(buf[29:31])),
+		seq:       uint64(binary.BigEndian.Uint64(buf[31:39])),
+		bloomSize: uint64(binary.BigEndian.Uint32(buf[39:43])),
+		fenceSize: uint64(binary.BigEndian.Uint32(buf[43:47])),
+		indexSize: uint64(binary.BigEndian.Uint32(buf[47:51])),
+		ranges:    uint32(binary.BigEndian.Uint32(buf[51:55])),
+	}
+
+	if h.prefixLen > maxPrefix || uint64(len(buf)) < nodeHeaderSize+h.prefixLen {
+		return h, Error.New("invalid prefix length: %d", h.prefixLen)
+	}
+	return h, nil
+}
+
+// unpack reads the header of the node written in buf, and returns it along
+// with the node laid out uncompressed, decompressing it if necessary with
+// the returned compressor.
+func unpack(buf []byte) (h header, _ []byte, comp Compressor, err error) {
+	h, err = readHeader(buf)
+	if err != nil {
+		return h, nil, nil, err
+	}
+	size := nodeHeaderSize + h.prefixLen
+
+	// if the node was compressed, decompress the payload into a new buffer
+	// laid out just like an uncompressed node.
+	if h.codec != CodecNone {
+		if h.payload > math.MaxUint32-h.padded() {
+			return h, nil, nil, Error.New("payload too large: %d", h.payload)
+		}
+
+		comp, err = lookupCompressor(h.codec)
+		if err != nil {
+			return h, nil, nil, Error.Wrap(err)
+		}
+
+		raw := make([]byte, h.padded()+h.payload)
+		copy(raw, buf[:size])
+		if err := comp.Decompress(raw[h.padded():], buf[size:]); err != nil {
+			return h, nil, nil, Error.Wrap(err)
+		}
+		buf = raw
+	}
+
+	if uint64(len(buf)) < h.base() {
+		return h, nil, nil, Error.New("buffer too small: %d", len(buf))
+	}
+	if h.base() > math.MaxUint32 {
+		return h, nil, nil, Error.New("internal error: btree too big")
+	}
+	return h, buf, comp, nil
+}
+
 // Length returns an upper bound on how many bytes writing the node would require.
 func (t *T) Length() uint64 {
 	return 0 +
-		nodeHeaderPadded +
-		t.entries.Length() +
-		(uint64(len(t.buf)) - uint64(t.base)) +
+		headerLength(len(t.prefix)) +
+		t.btreeLength() +
+		bloomLength(t.Count(), t.bits) +
+		fencesLength(t.lo, t.hi, nil) +
+		t.indexLength() +
+		uint64(t.data().Len()) +
 		0
 }
 
+// CompressedLength returns an estimate of how many bytes writing the node
+// would require after compressing it, without modifying the node. Only the
+// keys and values are compressed to make the estimate, and the rest of the
+// node is assumed not to shrink. Without a compressor, it is Length.
+func (t *T) CompressedLength() (uint64, error) {
+	if t.comp == nil {
+		return t.Length(), nil
+	}
+
+	src := t.data()
+	size := t.Length() - uint64(src.Len())
+	for _, seg := range [][]byte{src.Loaded, src.Append} {
+		if len(seg) == 0 {
+			continue
+		}
+		out, err := t.comp.Compress(nil, seg)
+		if err != nil {
+			return 0, Error.Wrap(err)
+		}
+		size += uint64(len(out))
+	}
+	return size, nil
+}
+
+// btreeLength returns how many bytes the btree takes up when written. It
+// is zero for frozen nodes, which omit it.
+func (t *T) btreeLength() uint64 {
+	if t.frozen != nil {
+		return 0
+	}
+	return t.entries.Length()
+}
+
+// indexLength returns how many bytes the index of a frozen node takes up
+// when written. It is zero for nodes with a btree.
+func (t *T) indexLength() uint64 {
+	if t.frozen == nil {
+		return 0
+	}
+	return frozenIndexLength(len(t.frozen.index))
+}
+
+// thaw converts the frozen entries into a btree so that the node can be
+// modified.
+func (t *T) thaw() {
+	if t.frozen != nil {
+		t.entries = t.frozen.thaw(t.entries.Fanout())
+		t.frozen = nil
+	}
+}
+
+// data returns the buffer that the entries point into.
+func (t *T) data() entry.Buffer {
+	return entry.Buffer{
+		Loaded: t.buf[t.base:],
+		Append: t.app,
+	}
+}
+
 // Count returns how many entries are in the node.
-func (t *T) Count() uint32 { return t.entries.Count() }
+func (t *T) Count() uint32 {
+	if t.frozen != nil {
+		return t.frozen.Count()
+	}
+	return t.entries.Count()
+}
 
 // Height returns the height of the node.
 func (t *T) Height() uint32 { return t.height }
@@ -121,6 +351,70 @@ func (t *T) Pivot() uint32 { return t.pivot }
 // SetPivot sets the next pointer.
 func (t *T) SetPivot(pivot uint32) { t.pivot = pivot }
 
+// ChildOf returns the block of the child of the node whose range contains
+// the key. It is the pivot of the last entry at or before the key that has
+// one, or the pivot of the node if there is no such entry.
+func (t *T) ChildOf(key []byte) uint32 {
+	if pivot, ok := t.pivotIndex().childOf(key); ok {
+		return pivot
+	}
+	return t.pivot
+}
+
+// Compressor returns the compressor used when writing the node, or nil.
+func (t *T) Compressor() Compressor { return t.comp }
+
+// SetCompressor sets the compressor used when writing the node. A nil
+// compressor causes the node to be written uncompressed.
+func (t *T) SetCompressor(comp Compressor) { t.comp = comp }
+
+// SetFanout sets how many entries each node of the btree built for the
+// node has room for. A btree that already has entries keeps its fanout.
+func (t *T) SetFanout(fanout uint16) { t.entries.SetFanout(fanout) }
+
+// SetBloomBits sets how many bits per key the Bloom filter built for the
+// node when it is written uses. Zero causes no filter to be written.
+func (t *T) SetBloomBits(bits uint8) { t.bits = bits }
+
+// MayContain returns false if the node definitely has no entry for the
+// key, which is always the case if the key does not have the prefix of
+// the node. Nodes without a Bloom filter may contain any key.
+func (t *T) MayContain(key []byte) bool {
+	if !bytes.HasPrefix(key, t.prefix) || !t.InFences(key) {
+		return false
+	}
+	return t.bloom == nil || t.bloom.mayContain(key[len(t.prefix):])
+}
+
+// addBloom adds the key, with the prefix of the node removed, to the Bloom
+// filter if there is one, first copying it if it is part of buf.
+func (t *T) addBloom(key []byte) {
+	if t.bloom == nil {
+		return
+	}
+	if sameStorage(t.bloom, t.buf) {
+		t.bloom = append(bloom(nil), t.bloom...)
+	}
+	t.bloom.add(key)
+}
+
+// Seq returns the largest sequence number of any entry inserted into the
+// node.
+func (t *T) Seq() uint64 { return t.seq }
+
+// SetWatermark sets the oldest sequence number that the entries of the node
+// may still be read at. Older versions of an entry are kept in the node
+// until the version that superseded them is at or below the watermark. It
+// is not saved when the node is written.
+func (t *T) SetWatermark(seq uint64) { t.mark = seq }
+
+// Watermark returns the oldest sequence number that the entries of the node
+// may still be read at.
+func (t *T) Watermark() uint64 { return t.mark }
+
+// keep returns true if the version before the entry may still be read.
+func (t *T) keep(ent entry.T) bool { return ent.Seq() > t.mark }
+
 // Dirty returns true if the node has been modified since the last Write.
 func (t *T) Dirty() bool { return t.dirty }
 
@@ -129,14 +423,11 @@ func (t *T) Sully() { t.dirty = true }
 
 var nodeWriteThunk mon.Thunk // timing info for node.Write
 
-// TODO(jeff): do we want to include the lengths before the key and value so
-// that a scan doesn't have to continue to load what the next entry is from
-// the btree? it'd be 4 bytes per entry (since we have keys are 10 bits and
-// values are 20 bits), but maybe the btree hopping isn't expensive?
-
 // Write marshals the node to the provided buffer. If it is not large enough
 // a new one is allocated. It holds on to the returned buffer, so do not
-// modify it.
+// modify it. If the node has a compressor, and compressing makes the node
+// smaller, the compressed form is returned instead in a new buffer that is
+// not held on to. The node still holds on to the provided buffer.
 func (t *T) Write(buf []byte) ([]byte, error) {
 	timer := nodeWriteThunk.Start()
 
@@ -145,48 +436,288 @@ func (t *T) Write(buf []byte) ([]byte, error) {
 		return nil, Error.New("internal error: node has become too big")
 	}
 
-	// ensure buf is large enough
-	if uint64(cap(buf)) < uint64(length) {
+	// ensure buf is large enough and does not share storage with the
+	// buffers we are compacting from.
+	if uint64(cap(buf)) < uint64(length) ||
+		sameStorage(buf, t.buf) || sameStorage(buf, t.app) {
 		buf = make([]byte, length)
 	} else {
 		buf = buf[:length]
 	}
 
-	btreeSize := t.entries.Length()
+	btreeSize := t.btreeLength()
+	bloomSize := bloomLength(t.Count(), t.bits)
+	src := t.data()
+
+	// find how much more of the keys is shared by all of them, so that it
+	// can be added to the prefix and stripped from every key. frozen nodes
+	// are already compacted, so they are written as is.
+	trim := 0
+	if t.frozen == nil {
+		trim = t.trim(src)
+	}
+	prefix := t.prefix
+	if trim > 0 {
+		t.entries.Iter(func(ent *entry.T) bool {
+			prefix = append(prefix[:len(prefix):len(prefix)], src.Key(*ent)[:trim]...)
+			return false
+		})
+	}
 
 	// write in the header
 	binary.BigEndian.PutUint32(buf[0:4], uint32(t.next))
 	binary.BigEndian.PutUint32(buf[4:8], uint32(t.height))
 	binary.BigEndian.PutUint32(buf[8:12], uint32(t.pivot))
 	binary.BigEndian.PutUint64(buf[12:20], uint64(btreeSize))
-
-	// compact the entries so that their offsets are increasing
-	data := buf[nodeHeaderPadded+btreeSize : nodeHeaderPadded+btreeSize : len(buf)]
+	buf[28] = CodecNone
+	binary.BigEndian.PutUint16(buf[29:31], uint16(len(prefix)))
+	binary.BigEndian.PutUint64(buf[31:39], t.seq)
+	binary.BigEndian.PutUint32(buf[39:43], uint32(bloomSize))
+	binary.BigEndian.PutUint32(buf[51:55], uint32(len(t.rangeIndex().starts)))
+	copy(buf[nodeHeaderSize:], prefix)
+	padded := paddedLength(uint64(len(prefix)))
+
+	// write the fences after the bloom filter.
+	fences := buf[padded+btreeSize+bloomSize:]
+	fences = appendFences(fences[:0], t.lo, t.hi, prefix)
+	binary.BigEndian.PutUint32(buf[43:47], uint32(len(fences)))
+
+	// write the index of a frozen node after the fences so that it can be
+	// searched without reading every record.
+	index := buf[padded+btreeSize+bloomSize+uint64(len(fences)):]
+	if t.frozen != nil {
+		index = t.frozen.appendIndex(index[:0])
+	} else {
+		index = index[:0]
+	}
+	binary.BigEndian.PutUint32(buf[47:51], uint32(len(index)))
+
+	// compact the entries so that their offsets are increasing, removing
+	// the newly shared part of the keys.
+	base := padded + btreeSize + bloomSize + uint64(len(fences)) + uint64(len(index))
+	data := buf[base:base:len(buf)]
+	if t.frozen != nil {
+		data = append(data, src.Loaded...)
+	}
 	t.entries.Iter(func(ent *entry.T) bool {
-		offset := uint32(len(data))
-		data = append(data, ent.ReadEntry(buf)...)
-		ent.SetOffset(offset)
+		data, *ent = t.appendEntry(data, src, *ent, nil, trim)
 		return true
 	})
+	t.entries.Resync()
+
+	// the keys may have shrunk, so the payload may be smaller than the
+	// upper bound we allocated for.
+	buf = buf[:base+uint64(len(data))]
+	binary.BigEndian.PutUint64(buf[20:28], uint64(len(buf))-padded)
 
 	// write in the compacted btree
-	t.entries.Write(buf[nodeHeaderPadded:])
+	if t.frozen == nil {
+		t.entries.Write(buf[padded:])
+	}
 
 	// update our local state because we modified the btree entries
 	t.buf = buf
-	t.base = uint32(nodeHeaderPadded + btreeSize)
+	t.base = uint32(base)
+	t.app = nil
+	t.garbage = 0
+	t.prefix = buf[nodeHeaderSize : nodeHeaderSize+len(prefix)]
+	t.ranges = nil
 	t.dirty = false
 
+	// build the Bloom filter now that the keys have their final form.
+	t.bloom = nil
+	if bloomSize > 0 {
+		t.bloom = newBloom(buf[padded+btreeSize:padded+btreeSize+bloomSize], t.bits)
+		t.eachKey(t.bloom.add)
+	}
+
+	// if we have a compressor, try to shrink the payload. the header is
+	// not padded in the compressed form.
+	if t.comp != nil {
+		header := nodeHeaderSize + len(prefix)
+		payload := buf[padded:]
+		out := make([]byte, header, header+len(payload)/2)
+		out, err := t.comp.Compress(out, payload)
+		if err != nil {
+			timer.Stop()
+			return nil, Error.Wrap(err)
+		}
+		if len(out) < len(buf) {
+			copy(out, buf[:header])
+			out[28] = t.comp.Codec()
+
+			timer.Stop()
+			return out, nil
+		}
+	}
+
 	timer.Stop()
 	return buf, nil
 }
 
+// trim returns how many more bytes past the prefix are shared by every key
+// in the node, limited so that the prefix still fits in the header. Since
+// the keys are sorted, it is how many bytes the first and last key share.
+func (t *T) trim(src entry.Buffer) int {
+	first, last, ok := t.ends()
+	if !ok {
+		return 0
+	}
+
+	n := commonPrefix(src.Key(first), src.Key(last))
+	if n > int(maxPrefix)-len(t.prefix) {
+		n = int(maxPrefix) - len(t.prefix)
+	}
+	return n
+}
+
+// expand shrinks the prefix to n bytes by adding the removed bytes back on
+// to the start of every key, rebuilding the buffer without any overwritten
+// entries.
+func (t *T) expand(n int) {
+	extra := t.prefix[n:]
+	src := t.data()
+
+	data := make([]byte, 0, int(uint64(src.Len())-t.garbage)+int(t.Count())*len(extra))
+	t.entries.Iter(func(ent *entry.T) bool {
+		data, *ent = t.appendEntry(data, src, *ent, extra, 0)
+		return true
+	})
+	t.entries.Resync()
+
+	t.buf = data
+	t.base = 0
+	t.app = nil
+	t.garbage = 0
+	t.prefix = t.prefix[:n:n]
+	t.bloom = nil  // the filter no longer matches the keys
+	t.ranges = nil // older versions may have been dropped
+	t.dirty = true
+}
+
+// eachKey calls fn with every key in the node, with the prefix removed.
+func (t *T) eachKey(fn func(key []byte)) {
+	src := t.data()
+	if t.frozen != nil {
+		for _, ent := range t.frozen.ents {
+			fn(src.Key(ent))
+		}
+		return
+	}
+	t.entries.Iter(func(ent *entry.T) bool {
+		fn(src.Key(*ent))
+		return true
+	})
+}
+
+// appendEntry appends the record for the entry to data, preceded by the
+// records of any older versions of it that are still needed, and returns
+// the entry updated to point at it. Each key is rewritten to be extra
+// followed by the key with the first trim bytes removed.
+func (t *T) appendEntry(data []byte, src entry.Buffer, ent entry.T,
+	extra []byte, trim int) ([]byte, entry.T) {
+
+	// collect the older versions that are still needed, newest first.
+	var versions []entry.T
+	for cur := ent; t.keep(cur); {
+		prev, ok := src.Prev(cur)
+		if !ok {
+			break
+		}
+		versions = append(versions, prev)
+		cur = prev
+	}
+
+	// write them out oldest first so that each can point at the last.
+	var prev uint32
+	for i := len(versions) - 1; i >= 0; i-- {
+		versions[i].SetSuperseded(true)
+		data, versions[i] = appendRecord(data, src, versions[i], extra, trim, prev)
+		prev = versions[i].Offset()
+	}
+
+	ent.SetSuperseded(false)
+	return appendRecord(data, src, ent, extra, trim, prev)
+}
+
+// appendRecord appends the header, rewritten key and value of the entry to
+// data, and returns the entry updated to point at it.
+func appendRecord(data []byte, src entry.Buffer, ent entry.T,
+	extra []byte, trim int, prev uint32) ([]byte, entry.T) {
+
+	// reserve space for the header, filled in once the key is known.
+	var zero [entry.HeaderSize]byte
+	hdr := len(data)
+	offset := uint32(hdr) + entry.HeaderSize
+	data = append(data, zero[:]...)
+	data = append(data, extra...)
+	data = append(data, src.Key(ent)[trim:]...)
+	key := data[offset:]
+	data = append(data, src.Value(ent)...)
+	ent.SetKey(key)
+	ent.SetOffset(offset)
+	ent.AppendHeader(data[:hdr], prev)
+	return data, ent
+}
+
+// compactMinimum is how many bytes of overwritten entries a node must have
+// before it is compacted, so that small nodes are not rebuilt constantly.
+const compactMinimum = 4096
+
+// compact rebuilds the buffer with only the live entries if at least half
+// of it is taken up by overwritten entries.
+func (t *T) compact() {
+	if t.garbage >= compactMinimum && 2*t.garbage >= uint64(t.data().Len()) {
+		t.expand(len(t.prefix))
+	}
+}
+
+// Garbage returns how many bytes of the node are taken up by entries that
+// have been overwritten. They are reclaimed by compaction or Write.
+func (t *T) Garbage() uint64 { return t.garbage }
+
+// suffix returns the key without the prefix, first shrinking the prefix if
+// the key does not start with it.
+func (t *T) suffix(key []byte) []byte {
+	if !bytes.HasPrefix(key, t.prefix) {
+		t.expand(commonPrefix(key, t.prefix))
+	}
+	return key[len(t.prefix):]
+}
+
+// commonPrefix returns how many bytes a and b share at the start.
+func commonPrefix(a, b []byte) int {
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return len(a)
+}
+
+// sameStorage returns true if the two slices start at the same address.
+func sameStorage(a, b []byte) bool {
+	return cap(a) > 0 && cap(b) > 0 && &a[:cap(a)][0] == &b[:cap(b)][0]
+}
+
 // Reset returns the node to the initial new state, even if it was
 // created from a call to Load.
 func (t *T) Reset() {
-	t.buf = t.buf[:0]
+	t.buf = nil
 	t.base = 0
+	t.app = t.app[:0]
+	t.garbage = 0
+	t.seq = 0
+	t.ranges = nil
+	t.pivots = nil
+	t.prefix = nil
+	t.bloom = nil
+	t.lo, t.hi = nil, nil
 	t.entries.Reset()
+	t.frozen = nil
 	t.dirty = false
 }
 
@@ -197,37 +728,74 @@ func (t *T) Fits(key, value []byte, size uint32) bool {
 		// we add 10 btreeNodeSize to protect if the insert would cause a split
 		// which might allocate up to log(n) nodes. there's no way that's ever
 		// bigger than 10 (famous last words).
-		t.Length()+10*btree.NodeSize < uint64(size)
+		t.Length()+10*btree.NodeLength(t.entries.Fanout()) < uint64(size)
 }
 
 var nodeInsertThunk mon.Thunk // timing info for node.Insert
 
-// Insert associates the key with the value in the node. If wrote is
-// false, then there was not enough space, and the node should be
-// flushed.
-func (t *T) Insert(key, value []byte, pivot uint32) (wrote bool) {
+// Insert associates the key with the value in the node as of the sequence
+// number. If wrote is false, then there was not enough space, and the node
+// should be flushed.
+func (t *T) Insert(key, value []byte, pivot uint32, seq uint64) (wrote bool) {
 	timer := nodeInsertThunk.Start()
 
-	// make sure the write is ok to go
-	if !t.Fits(key, value, math.MaxUint32) {
-		timer.Stop()
-		return false
-	}
+	// build the entry that we will insert.
+	t.thaw()
+	key = t.suffix(key)
+	ent := entry.New(key, value, false, 0)
+	ent.SetPivot(pivot)
+	ent.SetSeq(seq)
+
+	wrote = t.insert(key, value, ent)
+	timer.Stop()
+	return wrote
+}
+
+var nodeInsertPointerThunk mon.Thunk // timing info for node.InsertPointer
+
+// InsertPointer associates the key with the value log pointer in the node.
+// If wrote is false, then there was not enough space, and the node should
+// be flushed.
+func (t *T) InsertPointer(key, ptr []byte, pivot uint32, seq uint64) (wrote bool) {
+	timer := nodeInsertPointerThunk.Start()
 
 	// build the entry that we will insert.
-	ent := entry.New(key, value, false, uint32(len(t.buf))-t.base)
+	t.thaw()
+	key = t.suffix(key)
+	ent := entry.New(key, ptr, false, 0)
 	ent.SetPivot(pivot)
+	ent.SetPointer(true)
+	ent.SetSeq(seq)
 
-	// add the data to the buffer
-	t.buf = append(t.buf, key...)
-	t.buf = append(t.buf, value...)
+	wrote = t.insert(key, ptr, ent)
+	timer.Stop()
+	return wrote
+}
 
-	// insert it into the btree.
-	t.entries.Insert(ent, t.buf)
-	t.dirty = true
+var nodeInsertExpiringThunk mon.Thunk // timing info for node.InsertExpiring
+
+// InsertExpiring associates the key with the value in the node until the
+// expiry, in nanoseconds since the unix epoch. If pointer is true, the value
+// is a value log pointer. If wrote is false, then there was not enough
+// space, and the node should be flushed.
+func (t *T) InsertExpiring(key, value []byte, expiry int64, pointer bool,
+	pivot uint32, seq uint64) (wrote bool) {
 
+	timer := nodeInsertExpiringThunk.Start()
+
+	// build the entry that we will insert.
+	t.thaw()
+	key = t.suffix(key)
+	value = append(entry.AppendExpiry(nil, expiry), value...)
+	ent := entry.New(key, value, false, 0)
+	ent.SetPivot(pivot)
+	ent.SetPointer(pointer)
+	ent.SetExpires(true)
+	ent.SetSeq(seq)
+
+	wrote = t.insert(key, value, ent)
 	timer.Stop()
-	return true
+	return wrote
 }
 
 var nodeDeleteThunk mon.Thunk // timing info for node.Delete
@@ -235,33 +803,167 @@ var nodeDeleteThunk mon.Thunk // timing info for node.Delete
 // Delete removes the key from the node. It does not reclaim space
 // in the buffer. If wrote is false, there was not enough space, and
 // the node should be flushed.
-func (t *T) Delete(key []byte) (wrote bool) {
+func (t *T) Delete(key []byte, seq uint64) (wrote bool) {
 	timer := nodeDeleteThunk.Start()
 
-	// make sure the write is ok to go
-	if !t.Fits(key, nil, math.MaxUint32) {
+	// build the entry that we will insert.
+	t.thaw()
+	key = t.suffix(key)
+	ent := entry.New(key, nil, true, 0)
+	ent.SetSeq(seq)
+
+	wrote = t.insert(key, nil, ent)
+	timer.Stop()
+	return wrote
+}
+
+var nodeInsertMergeThunk mon.Thunk // timing info for node.InsertMerge
+
+// InsertMerge associates the key with the merge operand in the node. If
+// wrote is false, then there was not enough space, and the node should be
+// flushed.
+func (t *T) InsertMerge(key, operand []byte, pivot uint32, seq uint64) (wrote bool) {
+	timer := nodeInsertMergeThunk.Start()
+
+	// build the entry that we will insert.
+	t.thaw()
+	key = t.suffix(key)
+	ent := entry.New(key, operand, false, 0)
+	ent.SetPivot(pivot)
+	ent.SetMerge(true)
+	ent.SetSeq(seq)
+
+	wrote = t.insert(key, operand, ent)
+	timer.Stop()
+	return wrote
+}
+
+var nodeRemoveThunk mon.Thunk // timing info for node.Remove
+
+// Remove drops the entry for the key from the node entirely, rather than
+// inserting a tombstone for it. It returns true if there was an entry.
+func (t *T) Remove(key []byte) (removed bool) {
+	timer := nodeRemoveThunk.Start()
+
+	if !bytes.HasPrefix(key, t.prefix) {
 		timer.Stop()
 		return false
 	}
 
-	// build the entry that we will insert.
-	ent := entry.New(key, nil, true, uint32(len(t.buf))-t.base)
+	t.thaw()
+	old, removed := t.entries.Remove(key[len(t.prefix):], t.data())
+	if removed {
+		if t.hasRange(old) {
+			t.ranges = nil
+		}
+		if old.Pivot() > 0 {
+			t.pivots = nil
+		}
+		t.discard(old)
+		t.dirty = true
+		if bytes.Equal(key, t.lo) || bytes.Equal(key, t.hi) {
+			t.refence()
+		}
+	}
 
-	// add the data to the buffer
-	t.buf = append(t.buf, key...)
+	timer.Stop()
+	return removed
+}
 
-	// insert it into the btree
-	t.entries.Insert(ent, t.buf)
+// insert appends the key suffix and value to the buffer and adds the
+// entry to the btree.
+func (t *T) insert(key, value []byte, ent entry.T) bool {
+	// make sure the write is ok to go
+	if !t.Fits(key, value, math.MaxUint32) {
+		return false
+	}
+
+	// add the data to the append buffer
+	hdr := len(t.app)
+	ent.SetOffset(t.data().Len() + entry.HeaderSize)
+	t.app = ent.AppendHeader(t.app, 0)
+	t.app = append(t.app, key...)
+	t.app = append(t.app, value...)
 	t.dirty = true
+	t.addBloom(key)
+	t.widen(key)
+
+	// insert it into the btree, keeping track of the bytes of any entry
+	// that it overwrote so that they can be reclaimed.
+	old, ok := t.entries.Replace(ent, t.data())
+	if ok && old.Seq() > ent.Seq() {
+		// the node already has a newer version, so the entry is put back.
+		t.entries.Replace(old, t.data())
+		t.discard(ent)
+		return true
+	}
+	if ent.Seq() > t.seq {
+		t.seq = ent.Seq()
+	}
+	if ent.Range() || (ok && t.hasRange(old)) {
+		t.ranges = nil
+	}
+	if !ok {
+		// overwriting an entry keeps its pivot, so only new keys can add one.
+		if ent.Pivot() > 0 {
+			t.pivots = nil
+		}
+		return true
+	}
 
-	timer.Stop()
+	// if it overwrote the start of a range tombstone, the rest of the
+	// range has to move over. the end must be read before compacting.
+	var end []byte
+	if old.Range() && !ent.Range() {
+		end = append(end, t.data().Value(old)...)
+	}
+
+	// older versions are linked to from the entry while they may still be
+	// read, including range tombstones, which then hide their start key.
+	// rewriting the same version takes over the versions older than it.
+	switch {
+	case !t.keep(ent):
+		t.discard(old)
+	case old.Seq() == ent.Seq():
+		entry.SetPrev(t.app[hdr:], t.data().PrevOffset(old))
+		t.garbage += recordSize(old)
+		t.compact()
+	default:
+		entry.SetPrev(t.app[hdr:], old.Offset())
+	}
+
+	if end != nil {
+		return t.shiftRange(append(t.prefix[:len(t.prefix):len(t.prefix)], key...), end, old.Seq())
+	}
 	return true
 }
 
-// Iterator returns an iterator over the entries in the node.
+// discard records the bytes of the entry, which is no longer in the btree,
+// and all of its older versions as garbage, compacting if there is enough
+// of it.
+func (t *T) discard(ent entry.T) {
+	data := t.data()
+	for ok := true; ok; ent, ok = data.Prev(ent) {
+		t.garbage += recordSize(ent)
+	}
+	t.compact()
+}
+
+// recordSize returns how many bytes the record for the entry takes up.
+func recordSize(ent entry.T) uint64 {
+	return entry.HeaderSize + uint64(ent.Key()) + uint64(ent.Value())
+}
+
+// Iterator returns an iterator over the entries in the node. If nothing
+// has been appended or removed since the node was loaded or written, the
+// iterator streams through the buffer without consulting the btree.
 func (t *T) Iterator() Iterator {
 	return Iterator{
-		buf:  t.buf[t.base:],
-		iter: t.entries.Iterator(),
+		buf:    t.data(),
+		prefix: t.prefix,
+		iter:   t.entries.Iterator(),
+		frozen: t.frozen,
+		idx:    -1,
+		scan:   t.frozen == nil && len(t.app) == 0 && t.garbage == 0,
 	}
 }
@@ -1,10 +1,12 @@
 package node
 
 import (
+	"encoding/binary"
 	"fmt"
 	"testing"
 
 	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/internal/node/btree"
 	"github.com/zeebo/wosl/internal/node/entry"
 )
 
@@ -16,24 +18,546 @@ func TestNode(t *testing.T) {
 
 		for i := 0; i < 100; i++ {
 			buf := []byte(fmt.Sprint(gen.Intn(100)))
-			assert.That(t, n.Insert(buf, nil, 0))
+			assert.That(t, n.Insert(buf, nil, 0, 0))
 		}
 
-		last, base := "", n.buf[n.base:]
+		last, data := "", n.data()
 		n.entries.Iter(func(ent *entry.T) bool {
-			key := string(ent.ReadKey(base))
+			key := string(data.Key(*ent))
 			assert.That(t, key > last)
 			last = key
 			return true
 		})
 	})
 
+	t.Run("Write+Insert", func(t *testing.T) {
+		n := New(0)
+		for i := 0; i < 100; i++ {
+			assert.That(t, n.Insert(numbers[i], nil, 0, 0))
+		}
+		count := n.Count()
+
+		_, err := n.Write(nil)
+		assert.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			assert.That(t, n.Insert(numbers[i], numbers[i], 0, 0))
+		}
+		assert.Equal(t, n.Count(), count)
+
+		iter := n.Iterator()
+		for iter.Next() {
+			assert.Equal(t, string(iter.Key()), string(iter.Value()))
+		}
+	})
+
+	t.Run("InsertPointer", func(t *testing.T) {
+		n := New(0)
+		assert.That(t, n.Insert([]byte("a"), []byte("value"), 0, 0))
+		assert.That(t, n.InsertPointer([]byte("b"), []byte("pointer"), 0, 0))
+		assert.That(t, n.Insert([]byte("c"), []byte("value"), 0, 0))
+
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+
+		iter := n.Iterator()
+		for iter.Next() {
+			ent := iter.Entry()
+			assert.Equal(t, ent.Pointer(), string(iter.Key()) == "b")
+			if ent.Pointer() {
+				assert.Equal(t, string(iter.Value()), "pointer")
+			}
+		}
+	})
+
+	t.Run("InsertMerge", func(t *testing.T) {
+		n := New(0)
+		assert.That(t, n.Insert([]byte("a"), []byte("value"), 0, 0))
+		assert.That(t, n.InsertMerge([]byte("b"), []byte("operand"), 0, 0))
+		assert.That(t, n.Delete([]byte("c"), 0))
+
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+
+		iter := n.Iterator()
+		for iter.Next() {
+			ent := iter.Entry()
+			assert.Equal(t, ent.Merge(), string(iter.Key()) == "b")
+			assert.Equal(t, ent.Tombstone(), string(iter.Key()) == "c")
+			assert.That(t, !ent.Pointer())
+			if ent.Merge() {
+				assert.Equal(t, string(iter.Value()), "operand")
+			}
+		}
+	})
+
+	t.Run("InsertExpiring", func(t *testing.T) {
+		n := New(0)
+		assert.That(t, n.Insert([]byte("a"), []byte("value"), 0, 0))
+		assert.That(t, n.InsertExpiring([]byte("b"), []byte("value"), 10, false, 0, 0))
+		assert.That(t, n.InsertExpiring([]byte("c"), []byte("ptr"), 20, true, 0, 0))
+
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+
+		iter := n.Iterator()
+		for iter.Next() {
+			ent := iter.Entry()
+			assert.Equal(t, ent.Expires(), string(iter.Key()) != "a")
+			assert.Equal(t, ent.Pointer(), string(iter.Key()) == "c")
+			if !ent.Expires() {
+				continue
+			}
+
+			expiry, value, ok := entry.ReadExpiry(iter.Value())
+			assert.That(t, ok)
+			if ent.Pointer() {
+				assert.Equal(t, expiry, 20)
+				assert.Equal(t, string(value), "ptr")
+			} else {
+				assert.Equal(t, expiry, 10)
+				assert.Equal(t, string(value), "value")
+			}
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		n := New(0)
+		for i := 0; i < 100; i++ {
+			assert.That(t, n.Insert(numbers[i], numbers[i], 0, 0))
+		}
+		count := n.Count()
+
+		_, err := n.Write(nil)
+		assert.NoError(t, err)
+
+		assert.That(t, n.Remove(numbers[0]))
+		assert.That(t, !n.Remove(numbers[0]))
+		assert.That(t, !n.Remove([]byte("missing")))
+		assert.Equal(t, n.Count(), count-1)
+		assert.That(t, n.Garbage() > 0)
+
+		iter := n.Iterator()
+		for iter.Next() {
+			assert.That(t, string(iter.Key()) != string(numbers[0]))
+		}
+	})
+
+	t.Run("ChildOf", func(t *testing.T) {
+		key := func(i int) []byte { return []byte(fmt.Sprintf("k%02d", i)) }
+
+		n := New(1)
+		n.SetPivot(1)
+		for i := 0; i < 30; i++ {
+			pivot := uint32(0)
+			if i%10 == 5 {
+				pivot = uint32(i)
+			}
+			assert.That(t, n.Insert(key(i), nil, pivot, 0))
+		}
+		assert.Equal(t, n.ChildOf(key(0)), 1)
+		assert.Equal(t, n.ChildOf(key(5)), 5)
+		assert.Equal(t, n.ChildOf(key(14)), 5)
+		assert.Equal(t, n.ChildOf(key(15)), 15)
+		assert.Equal(t, n.ChildOf(key(99)), 25)
+
+		// overwriting keeps the pivot, and adding or removing one is seen.
+		assert.That(t, n.Insert(key(15), nil, 0, 1))
+		assert.Equal(t, n.ChildOf(key(16)), 15)
+		assert.That(t, n.Insert([]byte("k10a"), nil, 10, 0))
+		assert.Equal(t, n.ChildOf(key(11)), 10)
+		assert.That(t, n.Remove(key(25)))
+		assert.Equal(t, n.ChildOf(key(99)), 15)
+
+		// the pivots survive a round trip.
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, n.ChildOf(key(4)), 1)
+		assert.Equal(t, n.ChildOf(key(11)), 10)
+		assert.Equal(t, n.ChildOf(key(99)), 15)
+	})
+
+	t.Run("DeleteRange", func(t *testing.T) {
+		key := func(i int) []byte { return []byte(fmt.Sprintf("k%02d", i)) }
+		keys := func(n *T) (out []string) {
+			iter := n.Iterator()
+			for iter.Next() {
+				if ent := iter.Entry(); ent.Range() {
+					out = append(out, fmt.Sprintf("%q-%q", iter.Key(), iter.Value()))
+				} else {
+					out = append(out, string(iter.Key()))
+				}
+			}
+			return out
+		}
+
+		n := New(1)
+		n.SetWatermark(entry.MaxSeq)
+		for i := 0; i < 30; i++ {
+			assert.That(t, n.Insert(key(i), nil, 0, 0))
+		}
+
+		assert.That(t, n.DeleteRange(key(10), key(20), 0))
+		assert.Equal(t, n.Count(), 21)
+		assert.That(t, n.Covered(key(10)) && n.Covered(key(19)))
+		assert.That(t, !n.Covered(key(9)) && !n.Covered(key(20)))
+
+		// inserting over the start moves the rest of the range over.
+		assert.That(t, n.Insert(key(10), nil, 0, 0))
+		assert.That(t, n.Insert(key(15), nil, 0, 0))
+		assert.That(t, !n.Covered(key(10)) && n.Covered(key(11)))
+		assert.DeepEqual(t, keys(n)[9:13], []string{"k09", "k10", `"k10\x00"-"k20"`, "k15"})
+
+		// overlapping ranges are combined, keeping newer entries.
+		assert.That(t, n.DeleteRange(key(5), key(12), 0))
+		assert.DeepEqual(t, keys(n)[4:8], []string{"k04", `"k05"-"k20"`, "k15", "k20"})
+		assert.That(t, n.DeleteRange(key(7), key(25), 3))
+		assert.DeepEqual(t, keys(n)[4:8], []string{"k04", `"k05"-"k25"`, "k25", "k26"})
+		seq, ok := n.Covering(key(24), entry.MaxSeq)
+		assert.That(t, ok)
+		assert.Equal(t, seq, 3)
+		_, ok = n.Covering(key(25), entry.MaxSeq)
+		assert.That(t, !ok)
+
+		// the ranges survive a round trip.
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+		assert.That(t, n.Covered(key(5)) && n.Covered(key(24)) && !n.Covered(key(25)))
+
+		// ranges above the watermark keep what they cover and hide only
+		// older versions.
+		assert.That(t, n.DeleteRange(key(26), key(28), 5))
+		assert.DeepEqual(t, keys(n)[6:10], []string{"k25", `"k26"-"k28"`, "k27", "k28"})
+		_, ok = n.Covering(key(26), 4)
+		assert.That(t, !ok)
+		seq, ok = n.Covering(key(27), 5)
+		assert.That(t, ok)
+		assert.Equal(t, seq, 5)
+
+		// leaves only remove the keys.
+		leaf := New(0)
+		for i := 0; i < 30; i++ {
+			assert.That(t, leaf.Insert(key(i), nil, 0, 0))
+		}
+		assert.That(t, leaf.DeleteRange(key(10), key(20), 0))
+		assert.Equal(t, leaf.Count(), 20)
+		assert.That(t, !leaf.Covered(key(15)))
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		n1 := New(0)
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("tenant/table/%04d", i))
+			assert.That(t, n1.Insert(key, key, 0, 0))
+		}
+		length := n1.Length()
+
+		buf, err := n1.Write(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, string(n1.prefix), "tenant/table/00")
+		assert.That(t, n1.Length() < length)
+
+		n2, err := Load(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, string(n2.prefix), "tenant/table/00")
+
+		// inserting keys with and without the prefix should keep every key.
+		assert.That(t, n2.Insert([]byte("tenant/table/0050"), []byte("over"), 0, 0))
+		assert.Equal(t, string(n2.prefix), "tenant/table/00")
+		assert.That(t, n2.Insert([]byte("tenant/other"), []byte("other"), 0, 0))
+		assert.Equal(t, string(n2.prefix), "tenant/")
+		assert.Equal(t, n2.Count(), 101)
+
+		last, iter := "", n2.Iterator()
+		for iter.Next() {
+			key, value := string(iter.Key()), string(iter.Value())
+			assert.That(t, key > last)
+			switch key {
+			case "tenant/other":
+				assert.Equal(t, value, "other")
+			case "tenant/table/0050":
+				assert.Equal(t, value, "over")
+			default:
+				assert.Equal(t, key, value)
+			}
+			last = key
+		}
+	})
+
+	t.Run("Load+Insert", func(t *testing.T) {
+		n1 := New(0)
+		for i := 0; i < 100; i += 2 {
+			assert.That(t, n1.Insert(numbers[i], numbers[i], 0, 0))
+		}
+		buf, err := n1.Write(nil)
+		assert.NoError(t, err)
+		orig := append([]byte(nil), buf...)
+
+		n2, err := Load(buf)
+		assert.NoError(t, err)
+		for i := 1; i < 100; i += 2 {
+			assert.That(t, n1.Insert(numbers[i], numbers[i], 0, 0))
+			assert.That(t, n2.Insert(numbers[i], numbers[i], 0, 0))
+		}
+
+		// the loaded keys and values must not be modified or copied by
+		// inserts. the btree is aliased, so it is allowed to change.
+		assert.That(t, sameStorage(n2.buf, buf))
+		assert.Equal(t, string(buf[n2.base:]), string(orig[n2.base:]))
+		assert.Equal(t, n2.Count(), n1.Count())
+
+		iter := n2.Iterator()
+		for iter.Next() {
+			assert.Equal(t, string(iter.Key()), string(iter.Value()))
+		}
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		n1 := New(0)
+		for i := 0; i < 100; i++ {
+			d := numbers[gen.Intn(numbersSize)&numbersMask]
+			assert.That(t, n1.Insert(d, d, uint32(i), 0))
+		}
+		buf, err := n1.Write(nil)
+		assert.NoError(t, err)
+
+		// drop the btree so that it has to be rebuilt from the records.
+		h, err := readHeader(buf)
+		assert.NoError(t, err)
+		stripped := append([]byte(nil), buf[:h.padded()]...)
+		stripped = append(stripped, buf[h.padded()+h.btreeSize:]...)
+		binary.BigEndian.PutUint64(stripped[12:20], 0)
+
+		n2, err := Load(stripped)
+		assert.NoError(t, err)
+		assert.Equal(t, n2.Count(), n1.Count())
+
+		var ents []entry.T
+		n1.entries.Iter(func(ent *entry.T) bool {
+			ents = append(ents, *ent)
+			return true
+		})
+
+		iter1, iter2 := n1.Iterator(), n2.Iterator()
+		assert.That(t, iter1.scan)
+		for _, ent := range ents {
+			assert.That(t, iter1.Next())
+			assert.That(t, iter2.Next())
+			assert.Equal(t, iter1.Entry(), ent)
+			assert.Equal(t, iter2.Entry().Pivot(), ent.Pivot())
+			assert.Equal(t, string(iter2.Key()), string(iter1.Key()))
+			assert.Equal(t, string(iter2.Value()), string(iter1.Value()))
+		}
+		assert.That(t, !iter1.Next())
+		assert.That(t, !iter2.Next())
+
+		// the rebuilt btree must support inserts.
+		assert.That(t, n2.Insert([]byte("new"), []byte("new"), 0, 0))
+		assert.That(t, !n2.Iterator().scan)
+		assert.Equal(t, n2.Count(), n1.Count()+1)
+	})
+
+	t.Run("Garbage", func(t *testing.T) {
+		n := New(0)
+		for i := 0; i < 10; i++ {
+			assert.That(t, n.Insert(numbers[i], numbers[i], 0, 0))
+		}
+		assert.Equal(t, n.Garbage(), 0)
+		length := n.Length()
+
+		// overwriting a hot key many times should not grow the node
+		// without bound.
+		value := make([]byte, 100)
+		for i := 0; i < 10000; i++ {
+			binary.BigEndian.PutUint64(value, uint64(i))
+			assert.That(t, n.Insert([]byte("hot"), value, 0, 0))
+			assert.That(t, n.Length() < length+2*compactMinimum+1000)
+		}
+		assert.Equal(t, n.Count(), 11)
+
+		got := map[string]string{}
+		iter := n.Iterator()
+		for iter.Next() {
+			got[string(iter.Key())] = string(iter.Value())
+		}
+		assert.Equal(t, got["hot"], string(value))
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, got[string(numbers[i])], string(numbers[i]))
+		}
+
+		// writing the node reclaims the garbage as well.
+		assert.That(t, n.Insert([]byte("hot"), value, 0, 0))
+		assert.That(t, n.Garbage() > 0)
+		_, err := n.Write(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, n.Garbage(), 0)
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		n := New(0)
+		n.SetWatermark(1)
+		for i := 0; i < 10; i++ {
+			assert.That(t, n.Insert(numbers[i], numbers[i], 0, 1))
+		}
+		assert.That(t, n.Insert([]byte("key"), []byte("v1"), 0, 1))
+		assert.That(t, n.Insert([]byte("key"), []byte("v2"), 0, 2))
+		assert.That(t, n.Insert([]byte("key"), []byte("v3"), 0, 3))
+		assert.Equal(t, n.Count(), 11)
+		assert.Equal(t, n.Seq(), 3)
+
+		// inserting an older version than the node has is ignored.
+		assert.That(t, n.Insert([]byte("key"), []byte("v0"), 0, 0))
+
+		check := func(t *testing.T, n *T, oldest uint64) {
+			t.Helper()
+			iter := n.Iterator()
+			assert.That(t, iter.Seek([]byte("key")))
+			assert.Equal(t, string(iter.Value()), "v3")
+
+			for seq := uint64(0); seq <= 4; seq++ {
+				ent, value, ok := iter.At(seq)
+				if seq < oldest {
+					assert.That(t, !ok)
+					continue
+				}
+				assert.That(t, ok)
+				want := seq
+				if want > 3 {
+					want = 3
+				}
+				assert.Equal(t, ent.Seq(), want)
+				assert.Equal(t, string(value), fmt.Sprint("v", want))
+			}
+
+			ents, values := iter.Versions()
+			assert.Equal(t, len(ents), int(4-oldest))
+			assert.Equal(t, string(values[len(values)-1]), "v3")
+
+			// the older versions are not visited by iteration.
+			count := 0
+			for iter := n.Iterator(); iter.Next(); count++ {
+			}
+			assert.Equal(t, count, 11)
+		}
+		check(t, n, 1)
+
+		// the versions are kept by writing and loading.
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		check(t, n, 1)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+		check(t, n, 1)
+		assert.Equal(t, n.Seq(), 3)
+
+		// raising the watermark drops the versions nothing can read.
+		n.SetWatermark(2)
+		_, err = n.Write(nil)
+		assert.NoError(t, err)
+		check(t, n, 2)
+
+		n.SetWatermark(3)
+		assert.That(t, n.Insert([]byte("key"), []byte("v3"), 0, 3))
+		check(t, n, 3)
+	})
+
+	t.Run("Fanout", func(t *testing.T) {
+		n1 := New(0)
+		n1.SetFanout(8)
+		for i := 0; i < 100; i++ {
+			assert.That(t, n1.Insert(numbers[i], numbers[i], 0, 0))
+		}
+		assert.Equal(t, n1.entries.Fanout(), 8)
+
+		buf, err := n1.Write(nil)
+		assert.NoError(t, err)
+		n2, err := Load(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, n2.entries.Fanout(), 8)
+		assert.Equal(t, n2.Count(), n1.Count())
+
+		// frozen nodes use the fanout once they are modified.
+		var bu Bulk
+		assert.That(t, bu.Append([]byte("a"), nil, false, 0, 0))
+		n3 := bu.Done(0)
+		n3.SetFanout(8)
+		assert.That(t, n3.Insert([]byte("b"), nil, 0, 0))
+		assert.Equal(t, n3.entries.Fanout(), 8)
+
+		// small fanouts make small nodes.
+		n4 := New(0)
+		n4.SetFanout(btree.MinFanout)
+		assert.That(t, n4.Insert([]byte("key"), []byte("value"), 0, 0))
+		buf, err = n4.Write(nil)
+		assert.NoError(t, err)
+		assert.That(t, uint64(len(buf)) < btree.NodeLength(btree.MinFanout)+128)
+	})
+
+	t.Run("Bloom", func(t *testing.T) {
+		key := func(i int) []byte { return []byte(fmt.Sprintf("prefix/%04d", i)) }
+
+		n := New(0)
+		n.SetBloomBits(10)
+		for i := 0; i < 1000; i += 2 {
+			assert.That(t, n.Insert(key(i), nil, 0, 0))
+		}
+		assert.That(t, n.MayContain(key(1)))
+
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		n, err = Load(buf)
+		assert.NoError(t, err)
+
+		// every key is kept, and most missing ones are skipped.
+		check := func(t *testing.T, n *T, present func(i int) bool) {
+			t.Helper()
+			positives := 0
+			for i := 0; i < 1000; i++ {
+				if present(i) {
+					assert.That(t, n.MayContain(key(i)))
+				} else if n.MayContain(key(i)) {
+					positives++
+				}
+			}
+			assert.That(t, positives < 50)
+		}
+		check(t, n, func(i int) bool { return i%2 == 0 })
+		assert.That(t, !n.MayContain([]byte("other")))
+
+		// inserts are added to a copy of the filter.
+		assert.That(t, n.Insert(key(1), nil, 0, 0))
+		assert.That(t, n.MayContain(key(1)))
+		assert.That(t, !sameStorage(n.bloom, buf))
+
+		// shrinking the prefix drops the filter.
+		assert.That(t, n.Insert([]byte("other"), nil, 0, 0))
+		assert.That(t, n.bloom == nil)
+		assert.That(t, n.MayContain(key(3)))
+
+		// writing builds it again, which only happens if asked for.
+		n.SetBloomBits(10)
+		_, err = n.Write(nil)
+		assert.NoError(t, err)
+		check(t, n, func(i int) bool { return i%2 == 0 || i == 1 })
+		assert.That(t, n.MayContain([]byte("other")))
+	})
+
 	t.Run("Write+Load", func(t *testing.T) {
 		run := func(t *testing.T, count uint64) {
 			n1 := New(0)
 			for n := uint64(0); count == 0 || n < count; n++ {
 				d := numbers[gen.Intn(numbersSize)&numbersMask]
-				n1.Insert(d, d, 0)
+				n1.Insert(d, d, 0, 0)
 				if n1.Length() > bufferSize {
 					break
 				}
@@ -45,20 +569,18 @@ func TestNode(t *testing.T) {
 			assert.NoError(t, err)
 
 			var keys1, values1 []string
-			base1 := n1.buf[n1.base:]
-			n1.entries.Iter(func(ent *entry.T) bool {
-				keys1 = append(keys1, string(ent.ReadKey(base1)))
-				values1 = append(values1, string(ent.ReadValue(base1)))
-				return true
-			})
+			iter1 := n1.Iterator()
+			for iter1.Next() {
+				keys1 = append(keys1, string(iter1.Key()))
+				values1 = append(values1, string(iter1.Value()))
+			}
 
 			var keys2, values2 []string
-			base2 := n2.buf[n2.base:]
-			n2.entries.Iter(func(ent *entry.T) bool {
-				keys2 = append(keys2, string(ent.ReadKey(base2)))
-				values2 = append(values2, string(ent.ReadValue(base2)))
-				return true
-			})
+			iter2 := n2.Iterator()
+			for iter2.Next() {
+				keys2 = append(keys2, string(iter2.Key()))
+				values2 = append(values2, string(iter2.Value()))
+			}
 
 			assert.Equal(t, len(keys1), len(keys2))
 			assert.Equal(t, len(values2), len(values2))
@@ -66,6 +588,7 @@ func TestNode(t *testing.T) {
 			for i := 0; i < len(keys1); i++ {
 				assert.Equal(t, keys1[i], keys2[i])
 				assert.Equal(t, values1[i], values2[i])
+				assert.Equal(t, keys2[i], values2[i])
 			}
 		}
 
@@ -84,7 +607,7 @@ func BenchmarkNode(b *testing.B) {
 			b.ResetTimer()
 
 			for i := 0; i < b.N; i++ {
-				n.Insert(numbers[i&numbersMask], v, 0)
+				n.Insert(numbers[i&numbersMask], v, 0, 0)
 				if n.Length() > bufferSize {
 					n.Reset()
 				}
@@ -101,7 +624,7 @@ func BenchmarkNode(b *testing.B) {
 		run := func(b *testing.B, v []byte) {
 			n := New(0)
 			for {
-				n.Insert(numbers[gen.Intn(numbersSize)&numbersMask], v, 0)
+				n.Insert(numbers[gen.Intn(numbersSize)&numbersMask], v, 0, 0)
 				if n.Length() > bufferSize {
 					break
 				}
@@ -128,7 +651,7 @@ func BenchmarkNode(b *testing.B) {
 		run := func(b *testing.B, v []byte) {
 			n := New(0)
 			for {
-				n.Insert(numbers[gen.Intn(numbersSize)&numbersMask], v, 0)
+				n.Insert(numbers[gen.Intn(numbersSize)&numbersMask], v, 0, 0)
 				if n.Length() > bufferSize {
 					break
 				}
@@ -0,0 +1,597 @@
+package node
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node/btree"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// ReaderAt reads up to n bytes of a written node starting at the offset. It
+// returns fewer bytes only if the node ends first.
+type ReaderAt func(off, n uint32) ([]byte, error)
+
+// Partial is a written node that is read only as much as needed to look up
+// keys. Loading it reads the header, the Bloom filter, the fences and the
+// index of a frozen node, and a lookup reads the btree pages or the frozen
+// stride along the path to the key and the keys it compares against. Values
+// are only read when asked for. Compressed nodes can't be read in parts, so
+// they are loaded in full instead.
+type Partial struct {
+	read   ReaderAt
+	h      header
+	prefix []byte
+	bloom  bloom
+	lo, hi []byte
+	tree   btree.Header // btree of the entries (unused if frozen)
+	index  []byte       // offsets of every frozenStride'th entry if frozen
+	full   *T           // the loaded node if it could not be read in parts
+}
+
+var nodeLoadPartialThunk mon.Thunk // timing info for node.LoadPartial
+
+// LoadPartial reads the parts of the node that describe it from the reader.
+func LoadPartial(read ReaderAt) (*Partial, error) {
+	timer := nodeLoadPartialThunk.Start()
+
+	// read the header, and then again with the prefix if there is one.
+	buf, err := read(0, nodeHeaderSize)
+	if err != nil {
+		timer.Stop()
+		return nil, Error.Wrap(err)
+	}
+	if len(buf) >= nodeHeaderSize {
+		if n := binary.BigEndian.Uint16(buf[29:31]); n > 0 {
+			buf, err = read(0, nodeHeaderSize+uint32(n))
+			if err != nil {
+				timer.Stop()
+				return nil, Error.Wrap(err)
+			}
+		}
+	}
+	h, err := readHeader(buf)
+	if err != nil {
+		timer.Stop()
+		return nil, err
+	}
+
+	if h.codec != CodecNone {
+		buf, err := read(0, math.MaxUint32)
+		if err != nil {
+			timer.Stop()
+			return nil, Error.Wrap(err)
+		}
+		full, err := Load(buf)
+		if err != nil {
+			timer.Stop()
+			return nil, err
+		}
+
+		timer.Stop()
+		return &Partial{read: read, h: h, full: full}, nil
+	}
+
+	p := &Partial{
+		read:   read,
+		h:      h,
+		prefix: buf[nodeHeaderSize : nodeHeaderSize+h.prefixLen],
+	}
+
+	// the Bloom filter, fences and frozen index are next to each other
+	// after the btree.
+	size := h.bloomSize + h.fenceSize + h.indexSize
+	meta, err := p.readExact(h.padded()+h.btreeSize, size)
+	if err != nil {
+		timer.Stop()
+		return nil, err
+	}
+	if h.bloomSize > 0 {
+		p.bloom = bloom(meta[:h.bloomSize])
+	}
+	p.lo, p.hi, err = readFences(meta[h.bloomSize:h.bloomSize+h.fenceSize], p.prefix)
+	if err != nil {
+		timer.Stop()
+		return nil, Error.Wrap(err)
+	}
+
+	// frozen nodes omit the btree and are searched with their index.
+	if h.btreeSize == 0 {
+		p.index = meta[h.bloomSize+h.fenceSize:]
+		if len(p.index)%4 != 0 {
+			timer.Stop()
+			return nil, Error.New("invalid frozen index size: %d", len(p.index))
+		}
+
+		timer.Stop()
+		return p, nil
+	}
+
+	hdr, err := p.readExact(h.padded(), btree.HeaderSize)
+	if err != nil {
+		timer.Stop()
+		return nil, err
+	}
+	p.tree, err = btree.ReadHeader(hdr)
+	if err != nil {
+		timer.Stop()
+		return nil, Error.Wrap(err)
+	}
+	if btree.HeaderSize+p.tree.PageSize()*uint64(p.tree.Nodes) > h.btreeSize {
+		timer.Stop()
+		return nil, Error.New("btree too large for node: %d", h.btreeSize)
+	}
+
+	timer.Stop()
+	return p, nil
+}
+
+// readExact reads exactly n bytes at the offset.
+func (p *Partial) readExact(off, n uint64) ([]byte, error) {
+	if off+n > math.MaxUint32 {
+		return nil, Error.New("read past end of node: %d", off+n)
+	}
+	buf, err := p.read(uint32(off), uint32(n))
+	if err != nil {
+		return nil, Error.Wrap(err)
+	} else if uint64(len(buf)) < n {
+		return nil, Error.New("truncated node: %d < %d", len(buf), n)
+	}
+	return buf[:n], nil
+}
+
+// readData reads n bytes at the offset into the entries of the node.
+func (p *Partial) readData(off, n uint32) ([]byte, error) {
+	return p.readExact(p.h.base()+uint64(off), uint64(n))
+}
+
+// Height returns the height of the node.
+func (p *Partial) Height() uint32 { return p.h.height }
+
+// Next returns the next pointer of the node.
+func (p *Partial) Next() uint32 { return p.h.next }
+
+// Pivot returns the pivot of the node.
+func (p *Partial) Pivot() uint32 { return p.h.pivot }
+
+// Seq returns the largest sequence number of any entry in the node.
+func (p *Partial) Seq() uint64 { return p.h.seq }
+
+// MayContain returns false if the node definitely has no entry for the key.
+func (p *Partial) MayContain(key []byte) bool {
+	if p.full != nil {
+		return p.full.MayContain(key)
+	}
+	if !bytes.HasPrefix(key, p.prefix) || p.lo == nil ||
+		bytes.Compare(key, p.lo) < 0 || bytes.Compare(key, p.hi) > 0 {
+		return false
+	}
+	return p.bloom == nil || p.bloom.mayContain(key[len(p.prefix):])
+}
+
+// key reads the key of the entry, which has the prefix of the node removed.
+func (p *Partial) key(ent entry.T) ([]byte, error) {
+	return p.readData(ent.Offset(), ent.Key())
+}
+
+// page reads the btree page with the id.
+func (p *Partial) page(id uint32) (btree.Page, error) {
+	if id >= p.tree.Nodes {
+		return btree.Page{}, Error.New("btree node out of range: %d", id)
+	}
+	buf, err := p.readExact(p.h.padded()+p.tree.PageOffset(id), p.tree.PageSize())
+	if err != nil {
+		return btree.Page{}, err
+	}
+	page, err := p.tree.LoadPage(buf)
+	if err != nil {
+		return btree.Page{}, Error.Wrap(err)
+	}
+	return page, nil
+}
+
+// compare compares the key to the key of the entry, checking the prefix
+// stored in the entry before reading the key.
+func (p *Partial) compare(key []byte, ent entry.T) (int, error) {
+	var prefix [4]byte
+	copy(prefix[:], key)
+	if cmp := bytes.Compare(prefix[:], ent.Prefix[:]); cmp != 0 {
+		return cmp, nil
+	}
+	entKey, err := p.key(ent)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.Compare(key, entKey), nil
+}
+
+// position is the location of an entry in a leaf page of the btree, or in
+// a stride of a frozen node, which are read one at a time.
+type position struct {
+	ents []entry.T // entries of the page or stride
+	keys [][]byte  // keys of the entries of a stride (nil for pages)
+	idx  int       // index of the entry in ents
+	prev uint32    // id of the page or stride before this one
+	more bool      // if there is a page or stride before this one
+}
+
+// compareAt compares the key to the key of the i'th entry of the position.
+func (p *Partial) compareAt(key []byte, pos *position, i int) (int, error) {
+	if pos.keys != nil {
+		return bytes.Compare(key, pos.keys[i]), nil
+	}
+	return p.compare(key, pos.ents[i])
+}
+
+// leaf reads the leaf page with the id, positioned past its last entry.
+func (p *Partial) leaf(id uint32) (position, error) {
+	page, err := p.page(id)
+	if err != nil {
+		return position{}, err
+	} else if !page.Leaf() {
+		return position{}, Error.New("expected btree leaf: %d", id)
+	}
+
+	ents := make([]entry.T, page.Count())
+	for i := range ents {
+		ents[i] = page.Entry(uint16(i))
+	}
+	prev, more := page.Prev()
+	return position{ents: ents, idx: len(ents), prev: prev, more: more}, nil
+}
+
+// strides returns how many strides the index of a frozen node has.
+func (p *Partial) strides() uint32 { return uint32(len(p.index) / 4) }
+
+// recordStart returns the offset of the record of the i'th index entry.
+func (p *Partial) recordStart(i uint32) (uint32, error) {
+	off := binary.BigEndian.Uint32(p.index[4*i:])
+	if off < entry.HeaderSize {
+		return 0, Error.New("invalid frozen index offset: %d", off)
+	}
+	return off - entry.HeaderSize, nil
+}
+
+// stride reads the records of the i'th stride of a frozen node, positioned
+// past its last entry.
+func (p *Partial) stride(i uint32) (position, error) {
+	size := p.h.payload + p.h.padded()
+	if size < p.h.base() || size-p.h.base() > math.MaxUint32 {
+		return position{}, Error.New("invalid payload size: %d", p.h.payload)
+	}
+
+	start, end := uint32(0), uint32(size-p.h.base())
+	if i > 0 {
+		var err error
+		if start, err = p.recordStart(i); err != nil {
+			return position{}, err
+		}
+	}
+	if i+1 < p.strides() {
+		var err error
+		if end, err = p.recordStart(i + 1); err != nil {
+			return position{}, err
+		}
+	}
+	if start > end {
+		return position{}, Error.New("invalid frozen stride: %d > %d", start, end)
+	}
+
+	buf, err := p.readData(start, end-start)
+	if err != nil {
+		return position{}, err
+	}
+
+	var pos position
+	for off := uint32(0); off < uint32(len(buf)); {
+		ent, next, ok := entry.ReadRecord(buf, off)
+		if !ok {
+			return position{}, Error.New("truncated record at offset: %d", start+off)
+		}
+		if !ent.Superseded() {
+			pos.keys = append(pos.keys, ent.ReadKey(buf))
+			ent.SetOffset(ent.Offset() + start)
+			pos.ents = append(pos.ents, ent)
+		}
+		off = next
+	}
+	pos.idx = len(pos.ents)
+	pos.prev, pos.more = i-1, i > 0
+	return pos, nil
+}
+
+// seek returns the position of the first entry with a key greater than or
+// equal to the key, which has the prefix of the node removed. The position
+// is past the end of the entries it read if there is no such entry in the
+// page or stride that would hold the key.
+func (p *Partial) seek(key []byte) (position, error) {
+	var pos position
+	var err error
+	if p.index != nil {
+		pos, err = p.seekFrozen(key)
+	} else {
+		pos, err = p.seekTree(key)
+	}
+	if err != nil {
+		return position{}, err
+	}
+
+	i, j := 0, len(pos.ents)
+	for i < j {
+		h := int(uint(i+j) >> 1)
+		cmp, err := p.compareAt(key, &pos, h)
+		if err != nil {
+			return position{}, err
+		}
+		if cmp > 0 {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	pos.idx = i
+	return pos, nil
+}
+
+// seekTree walks the btree pages down to the leaf for the key.
+func (p *Partial) seekTree(key []byte) (position, error) {
+	id := p.tree.Root
+	for {
+		page, err := p.page(id)
+		if err != nil {
+			return position{}, err
+		}
+		if page.Leaf() {
+			return p.leaf(id)
+		}
+
+		// find the first separator larger than the key.
+		i, j := uint16(0), page.Count()
+		for i < j {
+			h := (i + j) >> 1
+			cmp, err := p.compare(key, page.Entry(h))
+			if err != nil {
+				return position{}, err
+			}
+			if cmp >= 0 {
+				i = h + 1
+			} else {
+				j = h
+			}
+		}
+		id = page.Child(i)
+	}
+}
+
+// seekFrozen finds the stride of a frozen node that would hold the key by
+// searching the full keys of the index.
+func (p *Partial) seekFrozen(key []byte) (position, error) {
+	if p.strides() == 0 {
+		return position{}, nil
+	}
+
+	// find the first stride that starts with a key greater than the key.
+	lo, hi := uint32(0), p.strides()
+	for lo < hi {
+		mid := (lo + hi) >> 1
+		start, err := p.recordStart(mid)
+		if err != nil {
+			return position{}, err
+		}
+		hdr, err := p.readData(start, entry.HeaderSize)
+		if err != nil {
+			return position{}, err
+		}
+		length, _ := entry.RecordLength(hdr)
+		rec, err := p.readData(start, length)
+		if err != nil {
+			return position{}, err
+		}
+		ent, _, ok := entry.ReadRecord(rec, 0)
+		if !ok {
+			return position{}, Error.New("truncated record at offset: %d", start)
+		}
+		if bytes.Compare(ent.ReadKey(rec), key) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return p.stride(0)
+	}
+	return p.stride(lo - 1)
+}
+
+// last returns the position past the last entry of the node.
+func (p *Partial) last() (position, error) {
+	if p.index != nil {
+		if p.strides() == 0 {
+			return position{}, nil
+		}
+		return p.stride(p.strides() - 1)
+	}
+
+	id := p.tree.Root
+	for {
+		page, err := p.page(id)
+		if err != nil {
+			return position{}, err
+		}
+		if page.Leaf() {
+			return p.leaf(id)
+		}
+		id = page.Child(page.Count())
+	}
+}
+
+// before moves the position to the entry before it, reading the previous
+// page or stride if necessary. It returns false if there is none.
+func (p *Partial) before(pos *position) (bool, error) {
+	for pos.idx == 0 {
+		if !pos.more {
+			return false, nil
+		}
+
+		var err error
+		if p.index != nil {
+			*pos, err = p.stride(pos.prev)
+		} else {
+			*pos, err = p.leaf(pos.prev)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	pos.idx--
+	return true, nil
+}
+
+// around returns the position of the entry for the key and true if there is
+// one, and otherwise the position of the first entry after it. It handles
+// keys without the prefix of the node, which sort before or after every
+// entry.
+func (p *Partial) around(key []byte) (position, bool, error) {
+	if !bytes.HasPrefix(key, p.prefix) {
+		if bytes.Compare(key, p.prefix) < 0 {
+			return position{}, false, nil
+		}
+		pos, err := p.last()
+		return pos, false, err
+	}
+
+	suffix := key[len(p.prefix):]
+	pos, err := p.seek(suffix)
+	if err != nil || pos.idx >= len(pos.ents) {
+		return pos, false, err
+	}
+	cmp, err := p.compareAt(suffix, &pos, pos.idx)
+	return pos, cmp == 0, err
+}
+
+var partialFindThunk mon.Thunk // timing info for Partial.Find
+
+// Find returns the newest version of the entry for the key as of the
+// sequence number, and false if there is none. Its value can be read with
+// Value.
+func (p *Partial) Find(key []byte, seq uint64) (ent entry.T, ok bool, err error) {
+	timer := partialFindThunk.Start()
+
+	if p.full != nil {
+		iter := p.full.Iterator()
+		if iter.Seek(key) && bytes.Equal(iter.Key(), key) {
+			ent, _, ok = iter.At(seq)
+		}
+		timer.Stop()
+		return ent, ok, nil
+	}
+	if !p.MayContain(key) {
+		timer.Stop()
+		return entry.T{}, false, nil
+	}
+
+	pos, ok, err := p.around(key)
+	if ok {
+		ent = pos.ents[pos.idx]
+	}
+	for err == nil && ok && ent.Seq() > seq {
+		ent, ok, err = p.prev(ent)
+	}
+	if err != nil {
+		timer.Stop()
+		return entry.T{}, false, err
+	}
+
+	timer.Stop()
+	return ent, ok, nil
+}
+
+// ChildOf returns the block of the child of the node whose range contains
+// the key. It is the pivot of the last entry at or before the key that has
+// one, or the pivot of the node if there is no such entry.
+func (p *Partial) ChildOf(key []byte) (uint32, error) {
+	if p.full != nil {
+		return p.full.ChildOf(key), nil
+	}
+
+	pos, ok, err := p.around(key)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if pivot := pos.ents[pos.idx].Pivot(); pivot > 0 {
+			return pivot, nil
+		}
+	}
+	for {
+		ok, err := p.before(&pos)
+		if err != nil {
+			return 0, err
+		} else if !ok {
+			return p.h.pivot, nil
+		}
+		if pivot := pos.ents[pos.idx].Pivot(); pivot > 0 {
+			return pivot, nil
+		}
+	}
+}
+
+// Covering returns the sequence number of the newest range tombstone in the
+// node as of the sequence number that contains the key, and false if there
+// is none. Nodes without any range tombstones are answered without reading
+// any entries. Since range tombstones may overlap, nodes with some are
+// loaded in full to answer it.
+func (p *Partial) Covering(key []byte, seq uint64) (uint64, bool, error) {
+	if p.full == nil && p.h.ranges == 0 {
+		return 0, false, nil
+	}
+	if p.full == nil {
+		buf, err := p.read(0, math.MaxUint32)
+		if err != nil {
+			return 0, false, Error.Wrap(err)
+		}
+		if p.full, err = Load(buf); err != nil {
+			return 0, false, err
+		}
+	}
+	rseq, ok := p.full.Covering(key, seq)
+	return rseq, ok, nil
+}
+
+// prev reads the previous version of the entry, if it has one.
+func (p *Partial) prev(ent entry.T) (entry.T, bool, error) {
+	hdr, err := p.readData(ent.Offset()-entry.HeaderSize, entry.HeaderSize)
+	if err != nil {
+		return entry.T{}, false, err
+	}
+	prev := binary.BigEndian.Uint32(hdr[16:20])
+	if prev < entry.HeaderSize {
+		return entry.T{}, false, nil
+	}
+
+	hdr, err = p.readData(prev-entry.HeaderSize, entry.HeaderSize)
+	if err != nil {
+		return entry.T{}, false, err
+	}
+	length, _ := entry.RecordLength(hdr)
+	rec, err := p.readData(prev-entry.HeaderSize, length)
+	if err != nil {
+		return entry.T{}, false, err
+	}
+	pent, _, ok := entry.ReadRecord(rec, 0)
+	if !ok {
+		return entry.T{}, false, Error.New("truncated record at offset: %d", prev)
+	}
+	pent.SetOffset(prev)
+	return pent, true, nil
+}
+
+// Value reads the value of an entry returned by Find.
+func (p *Partial) Value(ent entry.T) ([]byte, error) {
+	if p.full != nil {
+		return p.full.data().Value(ent), nil
+	}
+	return p.readData(ent.Offset()+ent.Key(), ent.Value())
+}
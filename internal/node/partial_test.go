@@ -0,0 +1,248 @@
+package node
+
+import (
+	"compress/flate"
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+func TestPartial(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("prefix/%04d", i)) }
+
+	// reader returns a ReaderAt over buf that counts how many bytes it read.
+	reader := func(buf []byte, read *int) ReaderAt {
+		return func(off, n uint32) ([]byte, error) {
+			if uint64(off) > uint64(len(buf)) {
+				off = uint32(len(buf))
+			}
+			end := uint64(off) + uint64(n)
+			if end > uint64(len(buf)) {
+				end = uint64(len(buf))
+			}
+			*read += int(end - uint64(off))
+			return buf[off:end], nil
+		}
+	}
+
+	t.Run("Find", func(t *testing.T) {
+		n := New(0)
+		n.SetFanout(8)
+		n.SetBloomBits(10)
+		for i := 0; i < 1000; i++ {
+			assert.That(t, n.Insert(key(i), numbers[i], 0, 1))
+		}
+		assert.That(t, n.Insert(key(500), []byte("v2"), 0, 2))
+		assert.That(t, n.Insert(key(500), []byte("v3"), 0, 3))
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+
+		var read int
+		p, err := LoadPartial(reader(buf, &read))
+		assert.NoError(t, err)
+		assert.Equal(t, p.Height(), 0)
+		assert.Equal(t, p.Seq(), 3)
+
+		for i := 0; i < 1000; i++ {
+			if i == 500 {
+				continue
+			}
+			read = 0
+			ent, ok, err := p.Find(key(i), 3)
+			assert.NoError(t, err)
+			assert.That(t, ok)
+			assert.Equal(t, ent.Seq(), 1)
+			assert.That(t, read < len(buf)/10)
+
+			value, err := p.Value(ent)
+			assert.NoError(t, err)
+			assert.Equal(t, string(value), string(numbers[i]))
+		}
+
+		for seq, want := range []string{"", string(numbers[500]), "v2", "v3", "v3"} {
+			ent, ok, err := p.Find(key(500), uint64(seq))
+			assert.NoError(t, err)
+			assert.Equal(t, ok, want != "")
+			if ok {
+				value, err := p.Value(ent)
+				assert.NoError(t, err)
+				assert.Equal(t, string(value), want)
+			}
+		}
+
+		// keys outside of the fences are rejected without reading.
+		read = 0
+		_, ok, err := p.Find(key(1000), 3)
+		assert.NoError(t, err)
+		assert.That(t, !ok)
+		assert.That(t, !p.MayContain([]byte("other")))
+		assert.Equal(t, read, 0)
+
+		_, ok, err = p.Find([]byte("prefix/0500x"), 3)
+		assert.NoError(t, err)
+		assert.That(t, !ok)
+	})
+
+	t.Run("Frozen", func(t *testing.T) {
+		var bu Bulk
+		for i := 0; i < 1000; i++ {
+			assert.That(t, bu.Append(key(i), numbers[i], false, 0, 1))
+		}
+		buf, err := bu.Done(0).Write(nil)
+		assert.NoError(t, err)
+
+		var read int
+		p, err := LoadPartial(reader(buf, &read))
+		assert.NoError(t, err)
+		assert.Nil(t, p.full)
+
+		for i := 0; i < 1000; i++ {
+			read = 0
+			ent, ok, err := p.Find(key(i), 1)
+			assert.NoError(t, err)
+			assert.That(t, ok)
+			assert.That(t, read < len(buf)/10)
+
+			value, err := p.Value(ent)
+			assert.NoError(t, err)
+			assert.Equal(t, string(value), string(numbers[i]))
+
+			_, ok, err = p.Find(append(key(i), 'x'), 1)
+			assert.NoError(t, err)
+			assert.That(t, !ok)
+		}
+		_, ok, err := p.Find(key(1000), 1)
+		assert.NoError(t, err)
+		assert.That(t, !ok)
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		n := New(0)
+		n.SetCompressor(NewFlateCompressor(flate.BestSpeed))
+		for i := 0; i < 100; i++ {
+			assert.That(t, n.Insert(key(i), numbers[i], 0, 1))
+		}
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+
+		var read int
+		p, err := LoadPartial(reader(buf, &read))
+		assert.NoError(t, err)
+		assert.NotNil(t, p.full)
+
+		for i := 0; i < 100; i++ {
+			ent, ok, err := p.Find(key(i), 1)
+			assert.NoError(t, err)
+			assert.That(t, ok)
+			value, err := p.Value(ent)
+			assert.NoError(t, err)
+			assert.Equal(t, string(value), string(numbers[i]))
+		}
+		_, ok, err := p.Find(key(100), 1)
+		assert.NoError(t, err)
+		assert.That(t, !ok)
+	})
+
+	// probes are keys before, at, between and after the keys of the nodes
+	// below, including ones without their prefix.
+	probes := [][]byte{[]byte("a"), []byte("prefix/"), []byte("z")}
+	for i := 0; i <= 1000; i++ {
+		probes = append(probes, key(i), append(key(i), 'x'))
+	}
+
+	t.Run("ChildOf", func(t *testing.T) {
+		pivot := func(i int) uint32 {
+			if i%37 == 5 {
+				return uint32(i + 100)
+			}
+			return 0
+		}
+
+		n := New(1)
+		n.SetFanout(8)
+		n.SetPivot(7)
+		var bu Bulk
+		for i := 0; i < 1000; i++ {
+			assert.That(t, n.Insert(key(i), numbers[i], pivot(i), 1))
+			assert.That(t, bu.Append(key(i), numbers[i], false, pivot(i), 1))
+		}
+		frozen := bu.Done(1)
+		frozen.SetPivot(7)
+
+		for _, n := range []*T{n, frozen} {
+			buf, err := n.Write(nil)
+			assert.NoError(t, err)
+
+			var read int
+			p, err := LoadPartial(reader(buf, &read))
+			assert.NoError(t, err)
+			assert.Nil(t, p.full)
+
+			for _, probe := range probes {
+				child, err := p.ChildOf(probe)
+				assert.NoError(t, err)
+				assert.Equal(t, child, n.ChildOf(probe))
+			}
+		}
+	})
+
+	t.Run("Covering", func(t *testing.T) {
+		n := New(1)
+		n.SetFanout(8)
+		for i := 0; i < 1000; i++ {
+			assert.That(t, n.Insert(key(i), numbers[i], 0, 1))
+		}
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+
+		// without range tombstones, no entries are read.
+		var read int
+		p, err := LoadPartial(reader(buf, &read))
+		assert.NoError(t, err)
+		read = 0
+		_, ok, err := p.Covering(key(500), entry.MaxSeq)
+		assert.NoError(t, err)
+		assert.That(t, !ok)
+		assert.Equal(t, read, 0)
+
+		assert.That(t, n.DeleteRange(key(100), key(200), 2))
+		assert.That(t, n.DeleteRange(key(500), append(key(500), 'x'), 3))
+		assert.That(t, n.DeleteRange(key(900), []byte("z"), 4))
+		assert.That(t, n.Insert(key(150), numbers[150], 0, 5))
+		buf, err = n.Write(nil)
+		assert.NoError(t, err)
+
+		p, err = LoadPartial(reader(buf, &read))
+		assert.NoError(t, err)
+		assert.Equal(t, p.h.ranges, 3)
+		for _, probe := range append(probes, []byte("y")) {
+			for _, at := range []uint64{2, 3, entry.MaxSeq} {
+				seq, ok, err := p.Covering(probe, at)
+				assert.NoError(t, err)
+				wseq, wok := n.Covering(probe, at)
+				assert.Equal(t, ok, wok)
+				assert.Equal(t, seq, wseq)
+			}
+		}
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		n := New(0)
+		for i := 0; i < 100; i++ {
+			assert.That(t, n.Insert(key(i), numbers[i], 0, 1))
+		}
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+
+		var read int
+		p, err := LoadPartial(reader(buf[:len(buf)-1], &read))
+		assert.NoError(t, err)
+		ent, ok, err := p.Find(key(99), 1)
+		assert.NoError(t, err)
+		assert.That(t, ok)
+		_, err = p.Value(ent)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,46 @@
+package node
+
+import (
+	"bytes"
+	"sort"
+)
+
+// pivots is the sorted list of the keys in a node whose entries have a
+// pivot, along with the pivots, built the first time a child is looked up
+// so that finding one does not have to walk back over every entry before
+// the key. Overwriting an entry keeps its pivot, so the list only changes
+// when an entry with a pivot is added or removed.
+type pivots struct {
+	keys   [][]byte
+	blocks []uint32
+}
+
+// childOf returns the pivot of the last key at or before the key, and
+// false if there is none.
+func (p *pivots) childOf(key []byte) (uint32, bool) {
+	i := sort.Search(len(p.keys), func(i int) bool {
+		return bytes.Compare(p.keys[i], key) > 0
+	}) - 1
+	if i < 0 {
+		return 0, false
+	}
+	return p.blocks[i], true
+}
+
+// pivotIndex returns the pivots in the node, building them if necessary.
+func (t *T) pivotIndex() *pivots {
+	if t.pivots != nil {
+		return t.pivots
+	}
+
+	p := new(pivots)
+	iter := t.Iterator()
+	for iter.Next() {
+		if pivot := iter.Entry().Pivot(); pivot > 0 {
+			p.keys = append(p.keys, append([]byte(nil), iter.Key()...))
+			p.blocks = append(p.blocks, pivot)
+		}
+	}
+	t.pivots = p
+	return p
+}
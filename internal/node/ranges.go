@@ -0,0 +1,199 @@
+package node
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// A range tombstone at or below the watermark cannot be told apart from the
+// older entries and range tombstones it covers by any read, so inserting
+// one removes them, combining it with the range tombstones, and inserting
+// over the start of one moves the rest of it to start just after the key.
+// Range tombstones above the watermark leave what they cover in place,
+// keeping any entry at their start as an older version, so range
+// tombstones may overlap, and an entry is only hidden by a range tombstone
+// that covers it if the range tombstone is newer.
+
+// ranges is the sorted list of every version of the range tombstones in a
+// node, built the first time it is needed so that loading a node does not
+// have to.
+type ranges struct {
+	starts [][]byte
+	ends   [][]byte
+	seqs   []uint64
+}
+
+// Len returns the number of range tombstones.
+func (r *ranges) Len() int { return len(r.starts) }
+
+// Less returns if the i'th range tombstone starts before the j'th.
+func (r *ranges) Less(i, j int) bool { return bytes.Compare(r.starts[i], r.starts[j]) < 0 }
+
+// Swap swaps the i'th and j'th range tombstones.
+func (r *ranges) Swap(i, j int) {
+	r.starts[i], r.starts[j] = r.starts[j], r.starts[i]
+	r.ends[i], r.ends[j] = r.ends[j], r.ends[i]
+	r.seqs[i], r.seqs[j] = r.seqs[j], r.seqs[i]
+}
+
+// last returns the index of the last range tombstone that starts at or
+// before the key, and if it contains the key.
+func (r *ranges) last(key []byte) (int, bool) {
+	i := sort.Search(len(r.starts), func(i int) bool {
+		return bytes.Compare(r.starts[i], key) > 0
+	}) - 1
+	return i, i >= 0 && bytes.Compare(key, r.ends[i]) < 0
+}
+
+// covering returns the sequence number of the newest range tombstone as of
+// the sequence number that contains the key, and false if there is none.
+func (r *ranges) covering(key []byte, seq uint64) (rseq uint64, ok bool) {
+	i, _ := r.last(key)
+	for ; i >= 0; i-- {
+		if r.seqs[i] <= seq && r.seqs[i] >= rseq && bytes.Compare(key, r.ends[i]) < 0 {
+			rseq, ok = r.seqs[i], true
+		}
+	}
+	return rseq, ok
+}
+
+// rangeIndex returns the range tombstones in the node, building them if
+// necessary.
+func (t *T) rangeIndex() *ranges {
+	if t.ranges != nil {
+		return t.ranges
+	}
+
+	r := new(ranges)
+	iter := t.Iterator()
+	for iter.Next() {
+		for ent, ok := iter.Entry(), true; ok; ent, ok = iter.buf.Prev(ent) {
+			if ent.Range() {
+				r.starts = append(r.starts, append([]byte(nil), iter.Key()...))
+				r.ends = append(r.ends, append([]byte(nil), iter.buf.Value(ent)...))
+				r.seqs = append(r.seqs, ent.Seq())
+			}
+		}
+	}
+	sort.Stable(r)
+	t.ranges = r
+	return r
+}
+
+// hasRange returns true if the entry or any of its older versions is a
+// range tombstone.
+func (t *T) hasRange(ent entry.T) bool {
+	data := t.data()
+	for ok := true; ok; ent, ok = data.Prev(ent) {
+		if ent.Range() {
+			return true
+		}
+	}
+	return false
+}
+
+// Covered returns true if a range tombstone in the node contains the key.
+func (t *T) Covered(key []byte) bool {
+	_, ok := t.Covering(key, entry.MaxSeq)
+	return ok
+}
+
+// Covering returns the sequence number of the newest range tombstone in the
+// node as of the sequence number that contains the key, and false if there
+// is none. It hides any entry for the key that is older than it.
+func (t *T) Covering(key []byte, seq uint64) (uint64, bool) {
+	return t.rangeIndex().covering(key, seq)
+}
+
+var nodeDeleteRangeThunk mon.Thunk // timing info for node.DeleteRange
+
+// DeleteRange removes every key from start up to but not including end
+// from the node, and adds a range tombstone for them so that they are
+// deleted from the children as well. A leaf has no children, so it only
+// removes the keys. If the range tombstone is above the watermark, the
+// keys are only hidden from reads at or after it instead, and a leaf
+// keeps the range tombstone to hide them. If wrote is false, there was
+// not enough space, and the node should be flushed.
+func (t *T) DeleteRange(start, end []byte, seq uint64) (wrote bool) {
+	timer := nodeDeleteRangeThunk.Start()
+
+	if bytes.Compare(start, end) >= 0 {
+		timer.Stop()
+		return true
+	}
+
+	// reads before a range tombstone above the watermark may still find the
+	// entries it covers.
+	if seq > t.mark {
+		wrote = t.insertRange(start, end, seq)
+		timer.Stop()
+		return wrote
+	}
+
+	// remove the older entries that are covered, remembering how far any
+	// range tombstones among them extended.
+	var keys [][]byte
+	extend := end
+	iter := t.Iterator()
+	for ok := iter.Seek(start); ok && bytes.Compare(iter.Key(), end) < 0; ok = iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+		if iter.Entry().Range() && bytes.Compare(iter.Value(), extend) > 0 {
+			extend = append([]byte(nil), iter.Value()...)
+		}
+	}
+
+	// if a range tombstone before the start already covers it, extend that
+	// one instead to keep them disjoint.
+	r := t.rangeIndex()
+	if i, ok := r.last(start); ok {
+		start = r.starts[i]
+		if bytes.Compare(r.ends[i], extend) > 0 {
+			extend = r.ends[i]
+		}
+	}
+
+	for _, key := range keys {
+		t.Remove(key)
+	}
+	if t.height == 0 {
+		timer.Stop()
+		return true
+	}
+
+	wrote = t.insertRange(start, extend, seq)
+	timer.Stop()
+	return wrote
+}
+
+// insertRange inserts a range tombstone from start up to end as of the
+// sequence number.
+func (t *T) insertRange(start, end []byte, seq uint64) bool {
+	t.thaw()
+	key := t.suffix(start)
+	ent := entry.New(key, end, true, 0)
+	ent.SetSeq(seq)
+	return t.insert(key, end, ent)
+}
+
+// shiftRange inserts the rest of a range tombstone up to end that was
+// overwritten by an entry for the key, so that it starts just after the
+// key. It skips over any entries that already follow the key.
+func (t *T) shiftRange(key, end []byte, seq uint64) bool {
+	start := append(key[:len(key):len(key)], 0)
+	for bytes.Compare(start, end) < 0 {
+		iter := t.Iterator()
+		if iter.Seek(start) && bytes.Equal(iter.Key(), start) {
+			start = append(start, 0)
+			continue
+		}
+
+		suffix := t.suffix(start)
+		ent := entry.New(suffix, end, true, 0)
+		ent.SetSeq(seq)
+		return t.insert(suffix, end, ent)
+	}
+	return true
+}
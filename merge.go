@@ -0,0 +1,149 @@
+package wosl
+
+import (
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// MergeOperator combines the operands written by Merge with the values they
+// apply to. Operands are combined as they move down the skip list and when
+// the key is read, so writing one never has to read the existing value.
+type MergeOperator interface {
+	// Merge returns the value that results from applying the operand to the
+	// existing value for the key, which is nil if there is none. It must not
+	// modify or retain either slice.
+	Merge(key, existing, operand []byte) []byte
+
+	// Combine returns a single operand with the same effect as applying the
+	// older operand and then the newer one. It must not modify or retain
+	// either slice.
+	Combine(key, older, newer []byte) []byte
+}
+
+// SetMergeOperator configures the operator that combines the operands
+// written by Merge. It must be called before any other method, and must be
+// the same for every use of the same backing store once any operands have
+// been written.
+func (t *T) SetMergeOperator(op MergeOperator) {
+	t.merge = op
+}
+
+var mergeThunk mon.Thunk // timing for Merge
+
+// Merge records the operand to be combined with the value for the key by
+// the merge operator, without reading the value first. It is not safe to
+// modify the key or operand slices.
+func (t *T) Merge(key, operand []byte) error {
+	timer := mergeThunk.Start()
+
+	if t.merge == nil {
+		timer.Stop()
+		return Error.New("no merge operator configured")
+	}
+
+	if err := t.grow(key); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+	if err := t.mergeInto(t.root, key, operand, t.next()); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+	if err := t.flushIfFull(); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return nil
+}
+
+// mergeBase is what merge operands are combined with in a node.
+type mergeBase struct {
+	missing bool   // the node has no entry, so the operand is stored alone
+	merge   bool   // the value is an operand to combine with
+	value   []byte // the existing value or operand
+	expiry  int64  // when the existing value expires, or zero
+}
+
+// baseOf returns what a merge operand is combined with in the node: the
+// newest entry for the key as of the sequence number.
+func (t *T) baseOf(n *node.T, key []byte, seq uint64) (mergeBase, error) {
+	ent, value, _, ok := search(n, key, seq)
+	rseq, covered := n.Covering(key, seq)
+	switch {
+	case covered && (!ok || ent.Seq() < rseq):
+		// a range tombstone in the node hides any older value.
+		return mergeBase{}, nil
+
+	case !ok:
+		return mergeBase{missing: true}, nil
+
+	case ent.Merge():
+		return mergeBase{merge: true, value: append([]byte(nil), value...)}, nil
+
+	case ent.Tombstone(), t.expired(ent, value):
+		return mergeBase{}, nil
+	}
+
+	// the result expires when the value it was merged into would have.
+	expiry, _, err := splitExpiry(ent, value)
+	if err != nil {
+		return mergeBase{}, Error.Wrap(err)
+	}
+	value, err = t.entryValue(ent, value)
+	if err != nil {
+		return mergeBase{}, Error.Wrap(err)
+	}
+	return mergeBase{value: append([]byte(nil), value...), expiry: expiry}, nil
+}
+
+// mergeInto combines the operand with the entry for the key in the node,
+// if it has one, and stores the result in the node as of the sequence
+// number. Only when the node has no entry does the operand have to be
+// stored by itself.
+func (t *T) mergeInto(n *node.T, key, operand []byte, seq uint64) error {
+	base, err := t.baseOf(n, key, entry.MaxSeq)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	return t.mergeOnto(n, key, base, operand, seq)
+}
+
+// mergeOnto combines the operand with the base and stores the result in the
+// node as of the sequence number.
+func (t *T) mergeOnto(n *node.T, key []byte, base mergeBase, operand []byte, seq uint64) error {
+	if t.merge == nil {
+		return Error.New("found merge operand without a merge operator")
+	}
+
+	switch {
+	case base.missing:
+		if !n.InsertMerge(key, operand, 0, seq) {
+			return Error.New("entry too large to fit")
+		}
+		return nil
+
+	case base.merge:
+		operand = t.merge.Combine(key, base.value, operand)
+		if !n.InsertMerge(key, operand, 0, seq) {
+			return Error.New("entry too large to fit")
+		}
+		return nil
+	}
+
+	return t.put(n, key, t.merge.Merge(key, base.value, operand), base.expiry, seq)
+}
+
+// applyOperands returns the value that results from applying the operands,
+// which are ordered newest first, to the existing value for the key.
+func (t *T) applyOperands(key, value []byte, operands [][]byte) ([]byte, error) {
+	if t.merge == nil {
+		return nil, Error.New("found merge operand without a merge operator")
+	}
+	for i := len(operands) - 1; i >= 0; i-- {
+		value = t.merge.Merge(key, value, operands[i])
+	}
+	return value, nil
+}
@@ -0,0 +1,70 @@
+package wosl
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+// counter is a merge operator that adds big endian uint64 operands.
+type counter struct{}
+
+func (counter) Merge(key, existing, operand []byte) []byte {
+	return counter{}.Combine(key, existing, operand)
+}
+
+func (counter) Combine(key, older, newer []byte) []byte {
+	var sum uint64
+	if len(older) == 8 {
+		sum = binary.BigEndian.Uint64(older)
+	}
+	sum += binary.BigEndian.Uint64(newer)
+	return binary.BigEndian.AppendUint64(nil, sum)
+}
+
+func TestMerge(t *testing.T) {
+	sl, _ := newTestList(t)
+
+	count := func(n uint64) []byte { return binary.BigEndian.AppendUint64(nil, n) }
+
+	assert.Error(t, sl.Merge(testKey(0), count(1)))
+	sl.SetMergeOperator(counter{})
+
+	// give half of the keys a starting value.
+	for i := 0; i < 1000; i += 2 {
+		assert.NoError(t, sl.Insert(testKey(i), count(100)))
+	}
+
+	// increment every key enough times to cause some flushes.
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 1000; i++ {
+			assert.NoError(t, sl.Merge(testKey(i), count(1)))
+		}
+	}
+
+	expect := func(i int) uint64 {
+		if i%2 == 0 {
+			return 104
+		}
+		return 4
+	}
+
+	for i := 0; i < 1000; i++ {
+		got, err := sl.Read(testKey(i))
+		assert.NoError(t, err)
+		assert.Equal(t, binary.BigEndian.Uint64(got), expect(i))
+
+		skey, svalue, err := sl.Successor(testKey(i-1), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, string(skey), string(testKey(i)))
+		assert.Equal(t, binary.BigEndian.Uint64(svalue), expect(i))
+	}
+
+	// an insert replaces any earlier operands.
+	assert.NoError(t, sl.Insert(testKey(1), count(7)))
+	assert.NoError(t, sl.Merge(testKey(1), count(1)))
+	got, err := sl.Read(testKey(1))
+	assert.NoError(t, err)
+	assert.Equal(t, binary.BigEndian.Uint64(got), 8)
+}
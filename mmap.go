@@ -0,0 +1,396 @@
+//go:build linux
+// +build linux
+
+package wosl
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/cespare/xxhash"
+	"github.com/zeebo/mon"
+)
+
+const mmapRecordHeaderSize = (0 +
+	8 + // hash of the rest of the record
+	4 + // block
+	4 + // data length and delete flag
+	0)
+
+// mmapDeleted is set in the length of a record that deletes its block.
+const mmapDeleted = 1 << 31
+
+// mmapMinSize is the smallest size the data file is grown to.
+const mmapMinSize = 1 << 20
+
+// MmapDisk is a Disk that stores blocks in a single memory mapped file. The
+// file is an append only log of records, each starting on a page boundary,
+// that either write or delete a block, and the latest record for every
+// block is indexed when the file is opened. A record is only observed if
+// its hash matches, so a torn write and everything after it is ignored.
+// The space of overwritten and deleted blocks is never reclaimed.
+//
+// Read returns slices directly into a private mapping of the file, so
+// loading a node does not copy it. Pages of the mapping are copied when
+// they are first modified, so mutating a loaded node never changes the
+// file. Mappings are never unmapped until the disk is closed, so the
+// slices stay valid when the file grows. It is safe for concurrent use.
+type MmapDisk struct {
+	mu     sync.Mutex
+	fh     *os.File
+	size   uint32            // natural block size
+	page   uint64            // alignment of records
+	shared []byte            // writable mapping for appending records
+	priv   []byte            // copy on write mapping that reads alias
+	old    [][]byte          // earlier private mappings that may be aliased
+	index  map[uint32]uint64 // offset of the latest record for each block
+	tail   uint64            // offset to append the next record at
+	max    uint32            // largest block ever written
+}
+
+var (
+	_ RangeDisk = (*MmapDisk)(nil)
+	_ ListDisk  = (*MmapDisk)(nil)
+)
+
+// OpenMmapDisk opens or creates the data file at path and maps it into
+// memory, indexing any records already in it.
+func OpenMmapDisk(path string, blockSize uint32) (*MmapDisk, error) {
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	info, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return nil, Error.Wrap(err)
+	}
+
+	m := &MmapDisk{
+		fh:    fh,
+		size:  blockSize,
+		page:  uint64(os.Getpagesize()),
+		index: make(map[uint32]uint64),
+	}
+	if err := m.remap(uint64(info.Size())); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	m.recover()
+	return m, nil
+}
+
+// recover walks the records from the start of the file, indexing them
+// until it finds one that was not completely written.
+func (m *MmapDisk) recover() {
+	for off := uint64(0); ; {
+		block, length, ok := m.record(off)
+		if !ok {
+			m.tail = off
+			return
+		}
+		if length&mmapDeleted == 0 {
+			m.index[block] = off
+			if block > m.max {
+				m.max = block
+			}
+		} else {
+			delete(m.index, block)
+		}
+		off = m.align(off + mmapRecordHeaderSize + uint64(length&^mmapDeleted))
+	}
+}
+
+// record returns the block and length of the record at the offset, and
+// false if there is no valid record there.
+func (m *MmapDisk) record(off uint64) (block, length uint32, ok bool) {
+	if off+mmapRecordHeaderSize > uint64(len(m.priv)) {
+		return 0, 0, false
+	}
+	hdr := m.priv[off : off+mmapRecordHeaderSize]
+	block = binary.BigEndian.Uint32(hdr[8:12])
+	length = binary.BigEndian.Uint32(hdr[12:16])
+
+	end := off + mmapRecordHeaderSize + uint64(length&^mmapDeleted)
+	if end > uint64(len(m.priv)) {
+		return 0, 0, false
+	}
+	if xxhash.Sum64(m.priv[off+8:end]) != binary.BigEndian.Uint64(hdr[0:8]) {
+		return 0, 0, false
+	}
+	return block, length, true
+}
+
+// align rounds the offset up to the next page boundary.
+func (m *MmapDisk) align(off uint64) uint64 {
+	return (off + m.page - 1) / m.page * m.page
+}
+
+// remap grows the file to at least size bytes and maps all of it. The old
+// private mapping is kept because reads may still alias it.
+func (m *MmapDisk) remap(size uint64) error {
+	size = m.align(size)
+	if size < mmapMinSize {
+		size = mmapMinSize
+	}
+	if size > uint64(^uint(0)>>1) {
+		return Error.New("data file too large: %d", size)
+	}
+
+	if size > uint64(len(m.shared)) {
+		if err := m.fh.Truncate(int64(size)); err != nil {
+			return Error.Wrap(err)
+		}
+		// the new size must be durable for records past the old size to be.
+		if err := m.fh.Sync(); err != nil {
+			return Error.Wrap(err)
+		}
+	}
+
+	fd := int(m.fh.Fd())
+	shared, err := syscall.Mmap(fd, 0, int(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	priv, err := syscall.Mmap(fd, 0, int(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		syscall.Munmap(shared)
+		return Error.Wrap(err)
+	}
+
+	if m.shared != nil {
+		if err := syscall.Munmap(m.shared); err != nil {
+			syscall.Munmap(shared)
+			syscall.Munmap(priv)
+			return Error.Wrap(err)
+		}
+	}
+	if m.priv != nil {
+		m.old = append(m.old, m.priv)
+	}
+	m.shared, m.priv = shared, priv
+	return nil
+}
+
+// BlockSize returns the natural block size of the disk.
+func (m *MmapDisk) BlockSize() uint32 { return m.size }
+
+// MaxBlock returns the largest block ever written.
+func (m *MmapDisk) MaxBlock() (uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.max, nil
+}
+
+// Blocks returns the blocks that have data, in increasing order.
+func (m *MmapDisk) Blocks() ([]uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blocks := make([]uint32, 0, len(m.index))
+	for block := range m.index {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	return blocks, nil
+}
+
+var mmapReadThunk mon.Thunk // timing info for MmapDisk.Read
+
+// Read returns the data for the block without copying it, or nil if the
+// block does not exist. Modifying the returned slice does not change the
+// file, and it stays valid until the disk is closed.
+func (m *MmapDisk) Read(block uint32) ([]byte, error) {
+	timer := mmapReadThunk.Start()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.fh == nil {
+		timer.Stop()
+		return nil, Error.New("disk is closed")
+	}
+
+	off, ok := m.index[block]
+	if !ok {
+		timer.Stop()
+		return nil, nil
+	}
+	length := uint64(binary.BigEndian.Uint32(m.priv[off+12 : off+16]))
+	start := off + mmapRecordHeaderSize
+	end := start + length
+
+	timer.Stop()
+	return m.priv[start:end:end], nil
+}
+
+var mmapReadAtThunk mon.Thunk // timing info for MmapDisk.ReadAt
+
+// ReadAt returns up to n bytes of the data for the block starting at the
+// offset without copying it, or nil if the block does not exist. Like Read,
+// the returned slice may be modified and stays valid until the disk is
+// closed.
+func (m *MmapDisk) ReadAt(block uint32, off, n uint32) ([]byte, error) {
+	timer := mmapReadAtThunk.Start()
+
+	buf, err := m.Read(block)
+	if err != nil || buf == nil {
+		timer.Stop()
+		return nil, err
+	}
+	if uint64(off) > uint64(len(buf)) {
+		off = uint32(len(buf))
+	}
+	end := uint64(off) + uint64(n)
+	if end > uint64(len(buf)) {
+		end = uint64(len(buf))
+	}
+
+	timer.Stop()
+	return buf[off:end:end], nil
+}
+
+var mmapWriteThunk mon.Thunk // timing info for MmapDisk.Write
+
+// Write appends a record storing the data for the block, and returns once
+// it is durable.
+func (m *MmapDisk) Write(block uint32, data []byte) error {
+	timer := mmapWriteThunk.Start()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if uint64(len(data)) >= mmapDeleted {
+		timer.Stop()
+		return Error.New("data too large: %d", len(data))
+	}
+	off, err := m.append(block, data, uint32(len(data)))
+	if err != nil {
+		timer.Stop()
+		return err
+	}
+
+	m.index[block] = off
+	if block > m.max {
+		m.max = block
+	}
+
+	timer.Stop()
+	return nil
+}
+
+var mmapDeleteThunk mon.Thunk // timing info for MmapDisk.Delete
+
+// Delete appends a record removing the block, and returns once it is
+// durable. It does nothing if the block does not exist.
+func (m *MmapDisk) Delete(block uint32) error {
+	timer := mmapDeleteThunk.Start()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.index[block]; !ok {
+		timer.Stop()
+		return nil
+	}
+	if _, err := m.append(block, nil, mmapDeleted); err != nil {
+		timer.Stop()
+		return err
+	}
+	delete(m.index, block)
+
+	timer.Stop()
+	return nil
+}
+
+// append writes a record at the tail through the shared mapping, growing
+// the file if necessary, and syncs it. It returns the offset of the record.
+func (m *MmapDisk) append(block uint32, data []byte, length uint32) (uint64, error) {
+	if m.fh == nil {
+		return 0, Error.New("disk is closed")
+	}
+
+	// leave room for clearing the header after the record so that stale
+	// bytes from a torn write are never read as a record.
+	off := m.tail
+	end := off + mmapRecordHeaderSize + uint64(len(data))
+	next := m.align(end)
+	if need := next + mmapRecordHeaderSize; need > uint64(len(m.shared)) {
+		if double := 2 * uint64(len(m.shared)); need < double {
+			need = double
+		}
+		if err := m.remap(need); err != nil {
+			return 0, err
+		}
+	}
+
+	rec := m.shared[off:end]
+	binary.BigEndian.PutUint32(rec[8:12], block)
+	binary.BigEndian.PutUint32(rec[12:16], length)
+	copy(rec[mmapRecordHeaderSize:], data)
+	binary.BigEndian.PutUint64(rec[0:8], xxhash.Sum64(rec[8:]))
+
+	zero := m.shared[next : next+mmapRecordHeaderSize]
+	for i := range zero {
+		zero[i] = 0
+	}
+
+	if err := msync(m.shared[off : next+mmapRecordHeaderSize]); err != nil {
+		return 0, Error.Wrap(err)
+	}
+	m.tail = next
+	return off, nil
+}
+
+// msync synchronously flushes the pages of the mapping containing buf.
+func msync(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	// the start address must be page aligned.
+	addr := uintptr(unsafe.Pointer(&buf[0]))
+	page := uintptr(os.Getpagesize())
+	start := addr &^ (page - 1)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+		start, addr-start+uintptr(len(buf)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Close unmaps the file and closes it. Slices returned by Read must not be
+// used after it is closed.
+func (m *MmapDisk) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.fh == nil {
+		return nil
+	}
+
+	// keep going after an error so that as much as possible is released.
+	var err error
+	for _, buf := range append(m.old, m.shared, m.priv) {
+		if merr := syscall.Munmap(buf); err == nil {
+			err = merr
+		}
+	}
+	if cerr := m.fh.Close(); err == nil {
+		err = cerr
+	}
+
+	m.fh, m.shared, m.priv, m.old = nil, nil, nil, nil
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	return nil
+}
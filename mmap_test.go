@@ -0,0 +1,216 @@
+//go:build linux
+// +build linux
+
+package wosl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/internal/node"
+)
+
+func TestMmapDisk(t *testing.T) {
+	open := func(t *testing.T, path string) *MmapDisk {
+		disk, err := OpenMmapDisk(path, 4<<10)
+		assert.NoError(t, err)
+		return disk
+	}
+
+	t.Run("Contract", func(t *testing.T) {
+		disk := open(t, filepath.Join(t.TempDir(), "data"))
+		defer disk.Close()
+		checkDisk(t, disk)
+	})
+
+	t.Run("Basic", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data")
+		disk := open(t, path)
+
+		for i := 1; i <= 100; i++ {
+			assert.NoError(t, disk.Write(uint32(i), numbers[i]))
+		}
+		assert.NoError(t, disk.Write(50, []byte("rewritten")))
+		assert.NoError(t, disk.Delete(60))
+		assert.NoError(t, disk.Delete(1000))
+
+		check := func(disk *MmapDisk) {
+			for i := 1; i <= 100; i++ {
+				buf, err := disk.Read(uint32(i))
+				assert.NoError(t, err)
+				switch i {
+				case 50:
+					assert.Equal(t, string(buf), "rewritten")
+				case 60:
+					assert.Nil(t, buf)
+				default:
+					assert.Equal(t, string(buf), string(numbers[i]))
+				}
+			}
+			maxBlock, err := disk.MaxBlock()
+			assert.NoError(t, err)
+			assert.Equal(t, maxBlock, uint32(100))
+
+			blocks, err := disk.Blocks()
+			assert.NoError(t, err)
+			assert.Equal(t, len(blocks), 99)
+			for i, block := range blocks {
+				want := uint32(i + 1)
+				if want >= 60 {
+					want++
+				}
+				assert.Equal(t, block, want)
+			}
+		}
+
+		check(disk)
+		assert.NoError(t, disk.Close())
+
+		disk = open(t, path)
+		defer disk.Close()
+		check(disk)
+	})
+
+	t.Run("ZeroCopy", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data")
+		disk := open(t, path)
+
+		n := node.New(0)
+		for i := 0; i < 100; i++ {
+			assert.That(t, n.Insert(numbers[i], numbers[i], 0, uint64(i+1)))
+		}
+		orig, err := n.Write(nil)
+		assert.NoError(t, err)
+		assert.NoError(t, disk.Write(1, orig))
+
+		buf, err := disk.Read(1)
+		assert.NoError(t, err)
+		again, err := disk.Read(1)
+		assert.NoError(t, err)
+		assert.Equal(t, &buf[0], &again[0])
+		assert.Equal(t, cap(buf), len(orig))
+
+		// mutating the loaded node must not change what is stored.
+		loaded, err := node.Load(buf)
+		assert.NoError(t, err)
+		for i := 100; i < 200; i++ {
+			loaded.Insert(numbers[i], numbers[i], 0, uint64(i+1))
+		}
+		for i := range buf {
+			buf[i] = 0
+		}
+		assert.NoError(t, disk.Close())
+
+		disk = open(t, path)
+		defer disk.Close()
+		buf, err = disk.Read(1)
+		assert.NoError(t, err)
+		assert.Equal(t, string(buf), string(orig))
+	})
+
+	t.Run("ReadAt", func(t *testing.T) {
+		disk := open(t, filepath.Join(t.TempDir(), "data"))
+		defer disk.Close()
+
+		assert.NoError(t, disk.Write(1, []byte("0123456789")))
+
+		for _, c := range []struct {
+			off, n uint32
+			want   string
+		}{
+			{0, 10, "0123456789"},
+			{2, 3, "234"},
+			{8, 5, "89"},
+			{10, 1, ""},
+			{20, 1, ""},
+		} {
+			buf, err := disk.ReadAt(1, c.off, c.n)
+			assert.NoError(t, err)
+			assert.NotNil(t, buf)
+			assert.Equal(t, string(buf), c.want)
+			assert.Equal(t, cap(buf), len(buf))
+		}
+
+		buf, err := disk.ReadAt(2, 0, 10)
+		assert.NoError(t, err)
+		assert.Nil(t, buf)
+	})
+
+	t.Run("Partial", func(t *testing.T) {
+		disk := open(t, filepath.Join(t.TempDir(), "data"))
+		defer disk.Close()
+
+		n := node.New(0)
+		for i := 0; i < 1000; i++ {
+			n.Insert(numbers[i], numbers[i], 0, 1)
+		}
+		buf, err := n.Write(nil)
+		assert.NoError(t, err)
+		assert.NoError(t, disk.Write(1, buf))
+
+		p, err := node.LoadPartial(func(off, n uint32) ([]byte, error) {
+			return disk.ReadAt(1, off, n)
+		})
+		assert.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			ent, ok, err := p.Find(numbers[i], 1)
+			assert.NoError(t, err)
+			assert.That(t, ok)
+			value, err := p.Value(ent)
+			assert.NoError(t, err)
+			assert.Equal(t, string(value), string(numbers[i]))
+		}
+	})
+
+	t.Run("Grow", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data")
+		disk := open(t, path)
+		defer disk.Close()
+
+		assert.NoError(t, disk.Write(1, numbers[1]))
+		first, err := disk.Read(1)
+		assert.NoError(t, err)
+
+		// enough full blocks to remap the file a few times.
+		for i := 2; i < 1000; i++ {
+			assert.NoError(t, disk.Write(uint32(i), kilobuf))
+		}
+		assert.That(t, len(disk.old) > 0)
+
+		assert.Equal(t, string(first), string(numbers[1]))
+		buf, err := disk.Read(1)
+		assert.NoError(t, err)
+		assert.Equal(t, string(buf), string(numbers[1]))
+	})
+
+	t.Run("Torn", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data")
+		disk := open(t, path)
+
+		assert.NoError(t, disk.Write(1, []byte("first")))
+		assert.NoError(t, disk.Write(2, []byte("second")))
+		assert.NoError(t, disk.Write(1, []byte("third")))
+		off := disk.index[1]
+		assert.NoError(t, disk.Close())
+
+		// corrupt the data of the last record as if it was never synced.
+		fh, err := os.OpenFile(path, os.O_RDWR, 0)
+		assert.NoError(t, err)
+		_, err = fh.WriteAt([]byte("x"), int64(off+mmapRecordHeaderSize))
+		assert.NoError(t, err)
+		assert.NoError(t, fh.Close())
+
+		disk = open(t, path)
+		defer disk.Close()
+
+		buf, err := disk.Read(1)
+		assert.NoError(t, err)
+		assert.Equal(t, string(buf), "first")
+
+		// appending overwrites the torn record.
+		assert.NoError(t, disk.Write(3, []byte("fourth")))
+		assert.Equal(t, disk.index[3], off)
+	})
+}
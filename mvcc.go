@@ -0,0 +1,102 @@
+package wosl
+
+import (
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// Every write to the skip list is stamped with a sequence number one larger
+// than the last. A node keeps the older versions of an entry that it has
+// overwritten for as long as they may still be read, which is until the
+// version that overwrote them is at or below the watermark. Since entries
+// only ever move down the skip list, a node that has no version of an entry
+// old enough for a read leaves it to its children.
+
+// Seq returns the sequence number of the last write to the skip list.
+func (t *T) Seq() uint64 { return t.seq }
+
+// Watermark returns the oldest sequence number that the skip list can still
+// be read at.
+func (t *T) Watermark() uint64 {
+	if t.mark < t.seq {
+		return t.mark
+	}
+	return t.seq
+}
+
+// SetWatermark sets the oldest sequence number that the skip list must still
+// be able to be read at. Older versions of entries are discarded as nodes
+// are written once nothing at or after the watermark can read them. By
+// default, it is always the sequence number of the last write, so no older
+// versions are kept. Lowering it does not restore discarded versions.
+func (t *T) SetWatermark(seq uint64) {
+	if seq > entry.MaxSeq {
+		seq = entry.MaxSeq
+	}
+	t.mark = seq
+	t.root.SetWatermark(t.Watermark())
+}
+
+// next returns the sequence number to stamp the next write with.
+func (t *T) next() uint64 {
+	t.seq++
+	t.root.SetWatermark(t.Watermark())
+	return t.seq
+}
+
+// checkSeq returns an error if the skip list can no longer be read at the
+// sequence number.
+func (t *T) checkSeq(seq uint64) error {
+	if mark := t.Watermark(); seq < mark {
+		return Error.New("sequence number %d is below the watermark %d", seq, mark)
+	}
+	return nil
+}
+
+var readAtThunk mon.Thunk // timing for ReadAt
+
+// ReadAt returns the data for k as of the sequence number if it existed.
+// Otherwise, it returns nil. The sequence number must not be below the
+// watermark. It is not safe to modify the returned slice.
+func (t *T) ReadAt(key []byte, seq uint64) ([]byte, error) {
+	timer := readAtThunk.Start()
+
+	if err := t.checkSeq(seq); err != nil {
+		timer.Stop()
+		return nil, err
+	}
+
+	value, _, err := t.read(key, seq)
+	if err != nil {
+		timer.Stop()
+		return nil, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return value, nil
+}
+
+var successorAtThunk mon.Thunk // timing for SuccessorAt
+
+// SuccessorAt returns the entry that sorts after key but still has the
+// prefix as of the sequence number if one existed. Otherwise, it returns
+// nil, nil. Calling it repeatedly with the returned key iterates over the
+// skip list as of the sequence number, which must not be below the
+// watermark. It is not safe to modify the returned slices.
+func (t *T) SuccessorAt(key, prefix []byte, seq uint64) ([]byte, []byte, error) {
+	timer := successorAtThunk.Start()
+
+	if err := t.checkSeq(seq); err != nil {
+		timer.Stop()
+		return nil, nil, err
+	}
+
+	skey, value, err := t.successorAt(key, prefix, seq)
+	if err != nil {
+		timer.Stop()
+		return nil, nil, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return skey, value, nil
+}
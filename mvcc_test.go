@@ -0,0 +1,145 @@
+package wosl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestReadAt(t *testing.T) {
+	sl, _ := newTestList(t)
+	sl.SetMergeOperator(counter{})
+
+	value := func(i, v int) []byte { return []byte(fmt.Sprint("value", i, "-", v)) }
+	count := func(n uint64) []byte { return binary.BigEndian.AppendUint64(nil, n) }
+
+	// by default, only the latest version can be read.
+	assert.NoError(t, sl.Insert(testKey(0), value(0, 0)))
+	assert.Equal(t, sl.Seq(), 1)
+	assert.Equal(t, sl.Watermark(), 1)
+	assert.NoError(t, sl.Insert(testKey(0), value(0, 1)))
+	_, err := sl.ReadAt(testKey(0), 1)
+	assert.Error(t, err)
+
+	for i := 0; i < 300; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), value(i, 1)))
+	}
+	assert.NoError(t, sl.Merge(testKey(1000), count(1)))
+	v1 := sl.Seq()
+	sl.SetWatermark(v1)
+
+	// overwrite or delete every other key, and bump the counter.
+	for i := 0; i < 300; i += 2 {
+		if i%4 == 0 {
+			assert.NoError(t, sl.Insert(testKey(i), value(i, 2)))
+		} else {
+			assert.NoError(t, sl.Delete(testKey(i)))
+		}
+	}
+	assert.NoError(t, sl.Merge(testKey(1000), count(1)))
+	v2 := sl.Seq()
+
+	check := func(t *testing.T, seq uint64, n int) {
+		t.Helper()
+
+		want := func(i int) []byte {
+			switch {
+			case i >= 300 && seq <= v2:
+				return nil
+			case i >= 300 || seq == v1 || i%2 == 1:
+				return value(i, 1)
+			case i%4 == 0:
+				return value(i, 2)
+			}
+			return nil
+		}
+
+		for i := 0; i < n; i++ {
+			got, err := sl.ReadAt(testKey(i), seq)
+			assert.NoError(t, err)
+			assert.Equal(t, string(got), string(want(i)))
+		}
+
+		got, err := sl.ReadAt(testKey(1000), seq)
+		assert.NoError(t, err)
+		if seq == v1 {
+			assert.Equal(t, string(got), string(count(1)))
+		} else {
+			assert.Equal(t, string(got), string(count(2)))
+		}
+
+		var last []byte
+		for i := 0; i < n; i++ {
+			if want(i) == nil {
+				continue
+			}
+			skey, svalue, err := sl.SuccessorAt(last, []byte("k0"), seq)
+			assert.NoError(t, err)
+			assert.Equal(t, string(skey), string(testKey(i)))
+			assert.Equal(t, string(svalue), string(want(i)))
+			last = skey
+		}
+	}
+	check(t, v1, 300)
+	check(t, v2, 300)
+
+	// flushing more keys moves the versions down the skip list.
+	for i := 300; i < 1000; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), value(i, 1)))
+	}
+	check(t, v1, 1000)
+	check(t, v2, 1000)
+	check(t, sl.Seq(), 1000)
+
+	// raising the watermark makes the older versions unreadable.
+	sl.SetWatermark(v2)
+	_, err = sl.ReadAt(testKey(0), v1)
+	assert.Error(t, err)
+	_, _, err = sl.SuccessorAt(nil, nil, v1)
+	assert.Error(t, err)
+	check(t, v2, 1000)
+}
+
+func TestReadAtDeleteRange(t *testing.T) {
+	sl, _ := newTestList(t)
+	sl.SetWatermark(0)
+
+	assert.NoError(t, sl.Insert([]byte("b"), []byte("value")))
+	s1 := sl.Seq()
+	assert.NoError(t, sl.DeleteRange([]byte("a"), []byte("c")))
+	s2 := sl.Seq()
+
+	check := func(t *testing.T) {
+		t.Helper()
+
+		got, err := sl.ReadAt([]byte("b"), s1)
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), "value")
+		skey, svalue, err := sl.SuccessorAt(nil, nil, s1)
+		assert.NoError(t, err)
+		assert.Equal(t, string(skey), "b")
+		assert.Equal(t, string(svalue), "value")
+
+		got, err = sl.ReadAt([]byte("b"), s2)
+		assert.NoError(t, err)
+		assert.Nil(t, got)
+		skey, _, err = sl.SuccessorAt(nil, []byte("b"), s2)
+		assert.NoError(t, err)
+		assert.Nil(t, skey)
+	}
+	check(t)
+
+	// flushing more keys moves the range and the version it covers down.
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	check(t)
+
+	// raising the watermark past the range lets it drop what it covers.
+	sl.SetWatermark(s2)
+	got, err := sl.ReadAt([]byte("b"), s2)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
@@ -0,0 +1,56 @@
+package wosl
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestDeleteRange(t *testing.T) {
+	sl, _ := newTestList(t)
+
+	for i := 0; i < 500; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+
+	// delete a range, and then write some keys back into it.
+	assert.NoError(t, sl.DeleteRange(testKey(100), testKey(300)))
+	assert.NoError(t, sl.DeleteRange(testKey(200), testKey(200)))
+	for i := 150; i < 200; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+
+	live := func(i int) bool { return i < 100 || i >= 300 || (i >= 150 && i < 200) }
+
+	check := func(t *testing.T, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			got, err := sl.Read(testKey(i))
+			assert.NoError(t, err)
+			if live(i) {
+				assert.Equal(t, string(got), string(testValue(i)))
+			} else {
+				assert.Nil(t, got)
+			}
+
+			next := i + 1
+			for next < n && !live(next) {
+				next++
+			}
+			skey, _, err := sl.Successor(testKey(i), nil)
+			assert.NoError(t, err)
+			if next < n {
+				assert.Equal(t, string(skey), string(testKey(next)))
+			} else {
+				assert.Nil(t, skey)
+			}
+		}
+	}
+	check(t, 500)
+
+	// flushing more keys moves the range tombstone into the children.
+	for i := 500; i < 1500; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	check(t, 1500)
+}
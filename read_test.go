@@ -0,0 +1,136 @@
+package wosl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/internal/node"
+	"github.com/zeebo/wosl/lease"
+)
+
+func TestRead(t *testing.T) {
+	sl, _ := newTestList(t)
+
+	for i := 0; i < 500; i++ {
+		assert.NoError(t, sl.Insert([]byte(fmt.Sprint(i)), numbers[i]))
+	}
+	assert.That(t, sl.root.Height() > 0)
+
+	for i := 0; i < 500; i++ {
+		value, err := sl.Read([]byte(fmt.Sprint(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, string(value), string(numbers[i]))
+	}
+
+	value, err := sl.Read([]byte("missing"))
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+}
+
+func TestPartialReads(t *testing.T) {
+	disk := &rangeDisk{memDisk: newMemDisk(1 << 15)}
+	sl, err := New(newDiskCache(disk))
+	assert.NoError(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	assert.NoError(t, sl.DeleteRange(testKey(100), testKey(300)))
+	for i := 150; i < 200; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	for i := 500; i < 1500; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	for i := 0; i < 1500; i += 7 {
+		assert.NoError(t, sl.Delete(testKey(i)))
+	}
+	assert.That(t, sl.root.Height() > 1)
+
+	type result struct {
+		value []byte
+		seq   uint64
+	}
+	results := func() (out []result) {
+		for i := 0; i < 1510; i++ {
+			value, err := sl.Read(testKey(i))
+			assert.NoError(t, err)
+			seq, err := sl.lastWrite(testKey(i))
+			assert.NoError(t, err)
+			out = append(out, result{value: value, seq: seq})
+		}
+		return out
+	}
+	want := results()
+
+	// the children are only read in parts from the disk.
+	reads, ranged := disk.reads, disk.ranged
+	sl.SetPartialReads(true)
+	got := results()
+	assert.Equal(t, disk.reads, reads)
+	assert.That(t, disk.ranged > ranged)
+
+	for i := range want {
+		assert.Equal(t, string(got[i].value), string(want[i].value))
+		assert.Equal(t, got[i].seq, want[i].seq)
+	}
+}
+
+func TestPartialReadsCached(t *testing.T) {
+	disk := &rangeDisk{memDisk: newMemDisk(1 << 15)}
+	cache := newPeekCache(disk)
+	sl, err := New(cache)
+	assert.NoError(t, err)
+	sl.SetPartialReads(true)
+
+	for i := 0; i < 1500; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	assert.Equal(t, sl.root.Height(), 2)
+
+	// change the child that a new key belongs in without writing it back.
+	key := []byte("missing")
+	n, ok := cache.nodes[sl.root.ChildOf(key)]
+	assert.That(t, ok)
+	assert.That(t, n.Insert(key, []byte("cached"), 0, sl.next()))
+
+	// reads go through the node the cache holds instead of the disk.
+	value, err := sl.Read(key)
+	assert.NoError(t, err)
+	assert.Equal(t, string(value), "cached")
+	for i := 0; i < 1500; i++ {
+		value, err := sl.Read(testKey(i))
+		assert.NoError(t, err)
+		assert.Equal(t, string(value), string(testValue(i)))
+	}
+}
+
+// peekCache is a memCache that never writes the nodes it holds back to the
+// disk, and returns them without reading the disk.
+type peekCache struct {
+	*memCache
+}
+
+var _ PeekCache = (*peekCache)(nil)
+
+func newPeekCache(disk Disk) *peekCache {
+	m := newDiskCache(disk)
+	m.cb = func(n *node.T, block uint32) error {
+		if m.leases[block]--; m.leases[block] == 0 {
+			delete(m.leases, block)
+		}
+		m.nodes[block] = n
+		return nil
+	}
+	return &peekCache{memCache: m}
+}
+
+func (p *peekCache) Peek(block uint32) (lease.T, bool) {
+	n, ok := p.nodes[block]
+	if !ok {
+		return lease.T{}, false
+	}
+	p.leases[block]++
+	return lease.New(n, block, p.cb), true
+}
@@ -0,0 +1,140 @@
+package wosl
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+var readManyThunk mon.Thunk // timing for ReadMany
+
+// ReadMany returns the data for each of the keys, or nil for the keys that do
+// not exist, in the same order as the keys. It descends the skip list once
+// for all of them, visiting each node a single time, so it is much cheaper
+// than calling Read for each key. It is not safe to modify the returned
+// slices.
+func (t *T) ReadMany(keys [][]byte) ([][]byte, error) {
+	timer := readManyThunk.Start()
+
+	idxs := make([]int, len(keys))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	sort.SliceStable(idxs, func(i, j int) bool {
+		return bytes.Compare(keys[idxs[i]], keys[idxs[j]]) < 0
+	})
+
+	r := &manyReader{
+		t:        t,
+		keys:     keys,
+		operands: make([][][]byte, len(keys)),
+		values:   make([][]byte, len(keys)),
+	}
+
+	t.lower.Lock()
+	err := r.read(t.root, t.queued(), idxs)
+	t.lower.Unlock()
+	if err != nil {
+		timer.Stop()
+		return nil, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return r.values, nil
+}
+
+// manyReader keeps track of the state of the keys in a ReadMany.
+type manyReader struct {
+	t        *T
+	keys     [][]byte
+	operands [][][]byte // merge operands found so far, newest first
+	values   [][]byte
+}
+
+// read resolves the keys at the indexes, which are sorted, using the node,
+// and then reads the rest from the queued roots, if any, which are newest
+// first, or from the children of the node, getting each child once for all
+// of the keys that are routed to it.
+func (r *manyReader) read(n *node.T, queued []*node.T, idxs []int) error {
+	var pending []int
+	var child uint32
+
+	for _, i := range idxs {
+		key := r.keys[i]
+		ent, value, c, ok := search(n, key, entry.MaxSeq)
+
+		// a range tombstone in the node hides everything older than it,
+		// including the entries in the node itself.
+		rseq, covered := n.Covering(key, entry.MaxSeq)
+		if covered && ok && ent.Seq() < rseq {
+			ok = false
+		}
+
+		if ok && ent.Merge() {
+			r.operands[i] = append(r.operands[i], append([]byte(nil), value...))
+			c, ok = n.ChildOf(key), false
+		}
+		if !ok && covered {
+			ent, value, ok = entry.New(key, nil, true, 0), nil, true
+		}
+
+		// resolve the key while the node is still leased if possible.
+		switch {
+		case len(queued) > 0 && !ok:
+			pending = append(pending, i)
+			continue
+		case ok, n.Height() == 0, c == noBlock, c == invalidBlock:
+			if err := r.resolve(i, ent, value, ok); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// keys are sorted, so the keys for a child are all adjacent.
+		if c != child && len(pending) > 0 {
+			if err := r.readChild(n, child, pending); err != nil {
+				return err
+			}
+			pending = pending[:0]
+		}
+		child, pending = c, append(pending, i)
+	}
+
+	switch {
+	case len(queued) > 0:
+		return r.read(queued[0], queued[1:], pending)
+	case len(pending) > 0:
+		return r.readChild(n, child, pending)
+	}
+	return nil
+}
+
+// readChild reads the keys at the indexes from the child block of the node.
+func (r *manyReader) readChild(n *node.T, child uint32, idxs []int) error {
+	le, err := r.t.cache.Get(child)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	defer le.Close()
+
+	if le.Node().Height() != n.Height()-1 {
+		return Error.New(
+			"invalid child height at block %d: %d != %d",
+			child, le.Node().Height(), n.Height()-1)
+	}
+	return r.read(le.Node(), nil, idxs)
+}
+
+// resolve stores the value for the key at the index from the entry found for
+// it and the merge operands found above it.
+func (r *manyReader) resolve(i int, ent entry.T, value []byte, ok bool) error {
+	value, _, err := r.t.resolve(r.keys[i], ent, value, r.operands[i], ok)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	r.values[i], r.operands[i] = value, nil
+	return nil
+}
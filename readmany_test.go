@@ -0,0 +1,71 @@
+package wosl
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"github.com/zeebo/wosl/lease"
+)
+
+// countCache counts how many times each block is gotten from the cache.
+type countCache struct {
+	*memCache
+	gets map[uint32]int
+}
+
+func (c *countCache) Get(block uint32) (lease.T, error) {
+	c.gets[block]++
+	return c.memCache.Get(block)
+}
+
+func TestReadMany(t *testing.T) {
+	m := &countCache{memCache: newTestCache(), gets: make(map[uint32]int)}
+	sl, err := New(m)
+	assert.NoError(t, err)
+	sl.SetMergeOperator(counter{})
+
+	count := func(n uint64) []byte { return binary.BigEndian.AppendUint64(nil, n) }
+
+	for i := 0; i < 1500; i += 2 {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	for i := 0; i < 1500; i += 10 {
+		assert.NoError(t, sl.Delete(testKey(i)))
+	}
+	assert.NoError(t, sl.DeleteRange(testKey(100), testKey(120)))
+	assert.NoError(t, sl.Merge(testKey(4), count(1)))
+	assert.NoError(t, sl.Merge(testKey(5), count(1)))
+
+	// ask for every key, backwards, with some duplicates and missing keys.
+	var keys [][]byte
+	for i := 1600; i >= 0; i-- {
+		keys = append(keys, testKey(i))
+		if i%100 == 0 {
+			keys = append(keys, testKey(i))
+		}
+	}
+
+	m.gets = make(map[uint32]int)
+	values, err := sl.ReadMany(keys)
+	assert.NoError(t, err)
+	assert.Equal(t, len(values), len(keys))
+	assert.That(t, len(m.gets) > 0)
+	for _, gets := range m.gets {
+		assert.Equal(t, gets, 1)
+	}
+
+	m.gets = make(map[uint32]int)
+	for i, k := range keys {
+		want, err := sl.Read(k)
+		assert.NoError(t, err)
+		assert.Equal(t, string(values[i]), string(want))
+	}
+	for _, gets := range m.gets {
+		assert.That(t, gets > 1)
+	}
+
+	values, err = sl.ReadMany(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, len(values), 0)
+}
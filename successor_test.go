@@ -0,0 +1,52 @@
+package wosl
+
+import (
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestSuccessor(t *testing.T) {
+	sl, _ := newTestList(t)
+
+	// insert only the even keys, enough to cause some flushes.
+	for i := 0; i < 2000; i += 2 {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+
+	for i := 0; i < 2000; i++ {
+		got, err := sl.Read(testKey(i))
+		assert.NoError(t, err)
+		if i%2 == 0 {
+			assert.Equal(t, string(got), string(testValue(i)))
+		} else {
+			assert.Nil(t, got)
+		}
+
+		next := (i + 2) / 2 * 2
+		skey, svalue, err := sl.Successor(testKey(i), nil)
+		assert.NoError(t, err)
+		if next < 2000 {
+			assert.Equal(t, string(skey), string(testKey(next)))
+			assert.Equal(t, string(svalue), string(testValue(next)))
+		} else {
+			assert.Nil(t, skey)
+		}
+	}
+
+	// successors must have the prefix.
+	skey, _, err := sl.Successor(testKey(1090), []byte("k109"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(skey), "k1092")
+	skey, _, err = sl.Successor(testKey(1098), []byte("k109"))
+	assert.NoError(t, err)
+	assert.Nil(t, skey)
+
+	// keys before the prefix find the first key with the prefix.
+	skey, _, err = sl.Successor([]byte("a"), []byte("k12"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(skey), "k1200")
+	skey, _, err = sl.Successor([]byte("a"), []byte("k121"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(skey), "k1210")
+}
@@ -0,0 +1,195 @@
+package wosl
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Placement maps the height of a node to the index of the tier it is stored
+// in. For example, a placement returning 0 for heights of at least 1 and 1
+// for leaves keeps the upper levels on the first disk and the leaves, which
+// hold most of the data, on the second.
+type Placement func(height uint32) int
+
+// TieredDisk is a Disk that spreads blocks over several disks, choosing the
+// disk for a node with a placement policy on its height. Block numbers are
+// interleaved over the tiers: with n tiers, block b is stored in tier
+// (b-1)%n as block (b-1)/n+1 of that tier's disk, so the largest block
+// stays close to n times the number of blocks in the fullest tier, and
+// blocks from every tier can be used with the same Cache. Block 1 is block
+// 1 of the first tier, so the root is kept there regardless of its height.
+//
+// Use it with SetAllocator so that new nodes are placed by the policy.
+type TieredDisk struct {
+	place Placement
+	tiers []Disk
+
+	mu   sync.Mutex // held while writing or deleting, and protects max
+	max  []uint32   // largest block allocated in each tier
+	init bool       // if max has been read from the tiers
+}
+
+var (
+	_ ListDisk  = (*TieredDisk)(nil)
+	_ Allocator = (*TieredDisk)(nil)
+)
+
+// NewTieredDisk returns a disk that stores nodes on the tier the placement
+// returns for their height. At least one tier must be provided, and the
+// placement must return the index of one of them for every height. The
+// natural block size is the block size of the first tier.
+func NewTieredDisk(place Placement, tiers ...Disk) (*TieredDisk, error) {
+	if len(tiers) == 0 {
+		return nil, Error.New("invalid number of tiers: %d", len(tiers))
+	}
+	if place == nil {
+		return nil, Error.New("no placement provided")
+	}
+	return &TieredDisk{
+		place: place,
+		tiers: tiers,
+		max:   make([]uint32, len(tiers)),
+	}, nil
+}
+
+// block returns the block number of the local block in the tier.
+func (d *TieredDisk) block(tier int, local uint32) (uint32, error) {
+	block := (uint64(local)-1)*uint64(len(d.tiers)) + uint64(tier) + 1
+	if local == 0 || block >= math.MaxUint32 {
+		return 0, Error.New("invalid block %d for tier %d", local, tier)
+	}
+	return uint32(block), nil
+}
+
+// split returns the disk and local block number of the block.
+func (d *TieredDisk) split(block uint32) (Disk, uint32, error) {
+	if block == 0 || block == invalidBlock {
+		return nil, 0, Error.New("invalid tiered block: %d", block)
+	}
+	n := uint32(len(d.tiers))
+	return d.tiers[(block-1)%n], (block-1)/n + 1, nil
+}
+
+// BlockSize returns the natural block size of the first tier.
+func (d *TieredDisk) BlockSize() uint32 { return d.tiers[0].BlockSize() }
+
+// Read returns the data for the block from its tier.
+func (d *TieredDisk) Read(block uint32) ([]byte, error) {
+	disk, local, err := d.split(block)
+	if err != nil {
+		return nil, err
+	}
+	return disk.Read(local)
+}
+
+// Write stores the data for the block on its tier. Writes and deletes are
+// issued to the tiers one at a time, in the order they are made, so they
+// are serial across every block as long as each tier's disk makes a write
+// or delete observable before returning from it.
+func (d *TieredDisk) Write(block uint32, data []byte) error {
+	disk, local, err := d.split(block)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return disk.Write(local, data)
+}
+
+// Delete removes the block from its tier. It is serial with writes in the
+// same way as Write.
+func (d *TieredDisk) Delete(block uint32) error {
+	disk, local, err := d.split(block)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return disk.Delete(local)
+}
+
+// MaxBlock returns the largest block number of the largest block ever
+// written to any tier.
+func (d *TieredDisk) MaxBlock() (uint32, error) {
+	var max uint32
+	for tier, disk := range d.tiers {
+		local, err := disk.MaxBlock()
+		if err != nil {
+			return 0, Error.Wrap(err)
+		} else if local == 0 {
+			continue
+		}
+
+		block, err := d.block(tier, local)
+		if err != nil {
+			return 0, err
+		} else if block > max {
+			max = block
+		}
+	}
+	return max, nil
+}
+
+// Blocks returns the blocks that have data on any tier, in increasing order.
+// Tiers that can't list their blocks have every block up to their largest
+// one included.
+func (d *TieredDisk) Blocks() ([]uint32, error) {
+	var blocks []uint32
+	for tier, disk := range d.tiers {
+		err := eachBlock(disk, func(local uint32) error {
+			block, err := d.block(tier, local)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	return blocks, nil
+}
+
+// Alloc returns an unused block number for a node of the height on the
+// tier the placement chooses. Blocks are allocated after the largest block
+// written to the tier when it is first called.
+func (d *TieredDisk) Alloc(height uint32) (uint32, error) {
+	tier := d.place(height)
+	if tier < 0 || tier >= len(d.tiers) {
+		return 0, Error.New("placement returned invalid tier %d for height %d",
+			tier, height)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.init {
+		for i, disk := range d.tiers {
+			local, err := disk.MaxBlock()
+			if err != nil {
+				return 0, Error.Wrap(err)
+			}
+			d.max[i] = local
+		}
+		d.init = true
+	}
+
+	// the first tier always has the root in block 1.
+	local := d.max[tier] + 1
+	if tier == 0 && local <= rootBlock {
+		local = rootBlock + 1
+	}
+	block, err := d.block(tier, local)
+	if err != nil {
+		return 0, Error.New("tier %d is out of blocks", tier)
+	}
+	d.max[tier] = local
+	return block, nil
+}
@@ -0,0 +1,210 @@
+package wosl
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+func TestTieredDisk(t *testing.T) {
+	// leaves and height 1 nodes go on the second tier.
+	place := func(height uint32) int {
+		if height >= 2 {
+			return 0
+		}
+		return 1
+	}
+
+	t.Run("Routing", func(t *testing.T) {
+		fast, slow := newMemDisk(1<<10), newMemDisk(1<<12)
+		disk, err := NewTieredDisk(place, fast, slow)
+		assert.NoError(t, err)
+		assert.Equal(t, disk.BlockSize(), 1<<10)
+
+		maxBlock, err := disk.MaxBlock()
+		assert.NoError(t, err)
+		assert.Equal(t, maxBlock, 0)
+
+		// blocks alternate between the tiers.
+		assert.NoError(t, disk.Write(3, []byte("fast")))
+		assert.NoError(t, disk.Write(4, []byte("slow")))
+		assert.Equal(t, string(fast.blocks[2]), "fast")
+		assert.Equal(t, string(slow.blocks[2]), "slow")
+
+		buf, err := disk.Read(4)
+		assert.NoError(t, err)
+		assert.Equal(t, string(buf), "slow")
+		buf, err = disk.Read(2)
+		assert.NoError(t, err)
+		assert.Nil(t, buf)
+
+		maxBlock, err = disk.MaxBlock()
+		assert.NoError(t, err)
+		assert.Equal(t, maxBlock, 4)
+
+		// the largest block is dense even if only one tier is used.
+		assert.NoError(t, disk.Write(9, []byte("fast")))
+		maxBlock, err = disk.MaxBlock()
+		assert.NoError(t, err)
+		assert.Equal(t, maxBlock, 9)
+
+		// tiers that can't list their blocks include every block up to
+		// their largest.
+		blocks, err := disk.Blocks()
+		assert.NoError(t, err)
+		assert.DeepEqual(t, blocks, []uint32{1, 2, 3, 4, 5, 7, 9})
+
+		listed, err := NewTieredDisk(place, &listDisk{memDisk: fast}, &listDisk{memDisk: slow})
+		assert.NoError(t, err)
+		blocks, err = listed.Blocks()
+		assert.NoError(t, err)
+		assert.DeepEqual(t, blocks, []uint32{3, 4, 9})
+
+		assert.NoError(t, disk.Delete(4))
+		assert.Equal(t, len(slow.blocks), 0)
+
+		_, err = disk.Read(0)
+		assert.Error(t, err)
+		_, err = disk.Read(invalidBlock)
+		assert.Error(t, err)
+	})
+
+	t.Run("Alloc", func(t *testing.T) {
+		fast, slow := newMemDisk(1<<10), newMemDisk(1<<10)
+		assert.NoError(t, slow.Write(5, nil))
+
+		disk, err := NewTieredDisk(place, fast, slow)
+		assert.NoError(t, err)
+
+		maxBlock, err := disk.MaxBlock()
+		assert.NoError(t, err)
+		assert.Equal(t, maxBlock, 10)
+
+		block, err := disk.Alloc(3)
+		assert.NoError(t, err)
+		assert.Equal(t, block, 3)
+		block, err = disk.Alloc(2)
+		assert.NoError(t, err)
+		assert.Equal(t, block, 5)
+		block, err = disk.Alloc(0)
+		assert.NoError(t, err)
+		assert.Equal(t, block, 12)
+
+		bad, err := NewTieredDisk(func(uint32) int { return 2 }, fast, slow)
+		assert.NoError(t, err)
+		_, err = bad.Alloc(0)
+		assert.Error(t, err)
+
+		_, err = NewTieredDisk(place)
+		assert.Error(t, err)
+	})
+
+	t.Run("SkipList", func(t *testing.T) {
+		fast, slow := newMemDisk(1<<15), newMemDisk(1<<15)
+		disk, err := NewTieredDisk(place, fast, slow)
+		assert.NoError(t, err)
+
+		sl, err := New(newDiskCache(disk))
+		assert.NoError(t, err)
+		sl.SetAllocator(disk)
+		for i := 0; i < 500; i++ {
+			assert.NoError(t, sl.Insert([]byte(fmt.Sprint(i)), numbers[i]))
+		}
+		assert.That(t, sl.root.Height() >= 2)
+		assert.NoError(t, sl.writeNode(sl.root, rootBlock))
+
+		// the skip list tracks the largest block that was allocated.
+		maxBlock, err := disk.MaxBlock()
+		assert.NoError(t, err)
+		assert.Equal(t, sl.maxBlock, maxBlock)
+
+		// the old root at height 1 is on the slow tier, and the root and the
+		// rest of the old roots are on the fast one.
+		assert.Equal(t, len(slow.blocks), 1)
+		assert.Equal(t, len(fast.blocks), int(sl.root.Height())-1)
+
+		// reopening reads the nodes back through both tiers.
+		sl, err = New(newDiskCache(disk))
+		assert.NoError(t, err)
+		sl.SetAllocator(disk)
+		for i := 0; i < 500; i++ {
+			value, err := sl.Read([]byte(fmt.Sprint(i)))
+			assert.NoError(t, err)
+			assert.Equal(t, string(value), string(numbers[i]))
+		}
+	})
+
+	t.Run("Serial", func(t *testing.T) {
+		fast := &serialDisk{memDisk: newMemDisk(1 << 10)}
+		slow := &serialDisk{memDisk: newMemDisk(1 << 10)}
+		disk, err := NewTieredDisk(place, fast, slow)
+		assert.NoError(t, err)
+
+		// writes and deletes to different tiers never overlap.
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for block := uint32(1); block <= 100; block++ {
+					if i%2 == 0 {
+						assert.NoError(t, disk.Write(block, numbers[block]))
+					} else {
+						assert.NoError(t, disk.Delete(block))
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		assert.That(t, !fast.overlap && !slow.overlap)
+	})
+}
+
+// serialDisk is a memDisk that records if a write or delete ever started
+// while another one was still running on any serialDisk.
+type serialDisk struct {
+	*memDisk
+	mu      sync.Mutex
+	overlap bool
+}
+
+var serialActive int32
+
+func (s *serialDisk) op(fn func() error) error {
+	if atomic.AddInt32(&serialActive, 1) > 1 {
+		s.mu.Lock()
+		s.overlap = true
+		s.mu.Unlock()
+	}
+	defer atomic.AddInt32(&serialActive, -1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	time.Sleep(time.Microsecond)
+	return fn()
+}
+
+func (s *serialDisk) Write(block uint32, data []byte) error {
+	return s.op(func() error { return s.memDisk.Write(block, data) })
+}
+
+func (s *serialDisk) Delete(block uint32) error {
+	return s.op(func() error { return s.memDisk.Delete(block) })
+}
+
+func (s *serialDisk) Read(block uint32) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memDisk.Read(block)
+}
+
+func (s *serialDisk) MaxBlock() (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.memDisk.MaxBlock()
+}
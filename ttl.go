@@ -0,0 +1,84 @@
+package wosl
+
+import (
+	"math"
+	"time"
+
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// Clock tells the skip list what time it is when deciding if an entry
+// inserted with a time to live has expired.
+type Clock interface {
+	Now() time.Time
+}
+
+// SetClock configures the clock used to expire entries inserted with a time
+// to live. By default, the system clock is used.
+func (t *T) SetClock(clock Clock) {
+	t.clock = clock
+}
+
+// now returns the current time in nanoseconds since the unix epoch.
+func (t *T) now() int64 {
+	if t.clock == nil {
+		return time.Now().UnixNano()
+	}
+	return t.clock.Now().UnixNano()
+}
+
+var insertWithTTLThunk mon.Thunk // timing for InsertWithTTL
+
+// InsertWithTTL associates value with key in the skip list until the time
+// to live has passed, after which the key is treated as if it was deleted.
+// Expired entries are dropped as they are flushed through the skip list.
+// The time to live must be positive. Times to live so large that the entry
+// would expire after the latest representable time never expire.
+func (t *T) InsertWithTTL(key, value []byte, ttl time.Duration) error {
+	timer := insertWithTTLThunk.Start()
+
+	if ttl <= 0 {
+		timer.Stop()
+		return Error.New("invalid time to live: %v", ttl)
+	}
+
+	if err := t.insert(key, value, expiryAfter(t.now(), ttl), t.next()); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return nil
+}
+
+// expiryAfter returns the time the ttl after now, saturating at the latest
+// representable time instead of overflowing.
+func expiryAfter(now int64, ttl time.Duration) int64 {
+	if now > math.MaxInt64-int64(ttl) {
+		return math.MaxInt64
+	}
+	return now + int64(ttl)
+}
+
+// expired returns true if the entry expires and that time has passed.
+func (t *T) expired(ent entry.T, value []byte) bool {
+	if !ent.Expires() {
+		return false
+	}
+	expiry, _, ok := entry.ReadExpiry(value)
+	return ok && expiry <= t.now()
+}
+
+// splitExpiry returns the time the entry expires at, or zero if it does not,
+// and the rest of the value.
+func splitExpiry(ent entry.T, value []byte) (int64, []byte, error) {
+	if !ent.Expires() {
+		return 0, value, nil
+	}
+	expiry, value, ok := entry.ReadExpiry(value)
+	if !ok {
+		return 0, nil, Error.New("expiring value too short")
+	}
+	return expiry, value, nil
+}
@@ -0,0 +1,119 @@
+package wosl
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/zeebo/assert"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (f *fakeClock) Now() time.Time { return f.now }
+
+func TestInsertWithTTL(t *testing.T) {
+	sl, _ := newTestList(t)
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	sl.SetClock(clock)
+
+	// every odd key expires, and the first of them were already inserted
+	// without a time to live, which must not come back once they expire.
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	for i := 0; i < 500; i++ {
+		if i%2 == 1 {
+			assert.NoError(t, sl.InsertWithTTL(testKey(i), testValue(i), time.Minute))
+		} else if i >= 100 {
+			assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+		}
+	}
+
+	check := func(t *testing.T, n int, expired bool) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			got, err := sl.Read(testKey(i))
+			assert.NoError(t, err)
+			if expired && i%2 == 1 && i < 500 {
+				assert.Nil(t, got)
+			} else {
+				assert.Equal(t, string(got), string(testValue(i)))
+			}
+		}
+
+		var last []byte
+		for count := 0; ; count++ {
+			skey, _, err := sl.Successor(last, nil)
+			assert.NoError(t, err)
+			if skey == nil {
+				if expired {
+					assert.Equal(t, count, n-250)
+				} else {
+					assert.Equal(t, count, n)
+				}
+				break
+			}
+			last = skey
+		}
+	}
+	check(t, 500, false)
+
+	clock.now = clock.now.Add(time.Minute - 1)
+	check(t, 500, false)
+
+	clock.now = clock.now.Add(1)
+	check(t, 500, true)
+
+	// flushing more keys drops the expired entries as they move down.
+	for i := 500; i < 1500; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), testValue(i)))
+	}
+	check(t, 1500, true)
+
+	// expired keys can be inserted again.
+	assert.NoError(t, sl.Insert(testKey(1), testValue(1)))
+	got, err := sl.Read(testKey(1))
+	assert.NoError(t, err)
+	assert.Equal(t, string(got), string(testValue(1)))
+}
+
+func TestInsertWithTTLLargeKey(t *testing.T) {
+	sl, _ := newTestList(t)
+
+	// keys may be up to 32KB, even though expiring entries need a flag.
+	key := make([]byte, 20000)
+	assert.NoError(t, sl.Insert(key, []byte("value")))
+	key[0] = 1
+	assert.NoError(t, sl.InsertWithTTL(key, []byte("expiring"), time.Hour))
+
+	got, err := sl.Read(key)
+	assert.NoError(t, err)
+	assert.Equal(t, string(got), "expiring")
+	key[0] = 0
+	got, err = sl.Read(key)
+	assert.NoError(t, err)
+	assert.Equal(t, string(got), "value")
+}
+
+func TestInsertWithTTLLimits(t *testing.T) {
+	sl, _ := newTestList(t)
+
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	sl.SetClock(clock)
+
+	// huge times to live saturate instead of wrapping into the past.
+	assert.NoError(t, sl.InsertWithTTL([]byte("forever"), []byte("value"), math.MaxInt64))
+	clock.now = time.Unix(0, math.MaxInt64-1)
+	got, err := sl.Read([]byte("forever"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(got), "value")
+
+	// times to live that are not positive are rejected.
+	assert.Error(t, sl.InsertWithTTL([]byte("zero"), []byte("value"), 0))
+	assert.Error(t, sl.InsertWithTTL([]byte("negative"), []byte("value"), -time.Second))
+	got, err = sl.Read([]byte("zero"))
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+}
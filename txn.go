@@ -0,0 +1,227 @@
+package wosl
+
+import (
+	"sort"
+
+	"github.com/zeebo/errs"
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+// ConflictError is the class of errors returned when a transaction fails to
+// commit because a key it read or wrote changed after it began.
+var ConflictError = errs.Class("conflict")
+
+// Txn is an optimistic transaction against a skip list. Writes are buffered
+// until Commit, and reads observe them. Like the skip list, it is not thread
+// safe, and it is not safe to use the skip list concurrently with it.
+type Txn struct {
+	t      *T
+	start  uint64
+	reads  map[string]struct{}
+	writes map[string]txnWrite
+	done   bool
+}
+
+// txnWrite is a buffered write in a transaction.
+type txnWrite struct {
+	value  []byte
+	delete bool
+}
+
+// Begin starts a transaction on the skip list.
+func (t *T) Begin() *Txn {
+	return &Txn{
+		t:      t,
+		start:  t.seq,
+		reads:  make(map[string]struct{}),
+		writes: make(map[string]txnWrite),
+	}
+}
+
+// check returns an error if the transaction has already been finished.
+func (x *Txn) check() error {
+	if x.done {
+		return Error.New("transaction already finished")
+	}
+	return nil
+}
+
+var txnGetThunk mon.Thunk // timing for Txn.Get
+
+// Get returns the data for the key, including any writes buffered in the
+// transaction, or nil if it does not exist. The key is checked for changes
+// when the transaction commits. It is not safe to modify the returned slice.
+func (x *Txn) Get(key []byte) ([]byte, error) {
+	timer := txnGetThunk.Start()
+
+	if err := x.check(); err != nil {
+		timer.Stop()
+		return nil, err
+	}
+
+	if w, ok := x.writes[string(key)]; ok {
+		timer.Stop()
+		if w.delete {
+			return nil, nil
+		}
+		return w.value, nil
+	}
+
+	x.reads[string(key)] = struct{}{}
+	value, err := x.t.Read(key)
+	if err != nil {
+		timer.Stop()
+		return nil, err
+	}
+
+	timer.Stop()
+	return value, nil
+}
+
+// Put buffers associating the value with the key. It is not safe to modify
+// the value slice.
+func (x *Txn) Put(key, value []byte) error {
+	if err := x.check(); err != nil {
+		return err
+	}
+	x.writes[string(key)] = txnWrite{value: value}
+	return nil
+}
+
+// Delete buffers removing the key.
+func (x *Txn) Delete(key []byte) error {
+	if err := x.check(); err != nil {
+		return err
+	}
+	x.writes[string(key)] = txnWrite{delete: true}
+	return nil
+}
+
+// Discard finishes the transaction without applying any of its writes.
+func (x *Txn) Discard() {
+	x.done = true
+	x.reads, x.writes = nil, nil
+}
+
+var txnCommitThunk mon.Thunk // timing for Txn.Commit
+
+// Commit applies the buffered writes to the skip list with a single sequence
+// number. If any key that was read or written has been written since the
+// transaction began, including by a merge or a range delete, nothing is
+// applied and an error of class ConflictError is returned. The transaction
+// is finished either way.
+func (x *Txn) Commit() error {
+	timer := txnCommitThunk.Start()
+
+	if err := x.check(); err != nil {
+		timer.Stop()
+		return err
+	}
+	defer x.Discard()
+
+	if err := x.validate(); err != nil {
+		timer.Stop()
+		return err
+	}
+	if len(x.writes) == 0 {
+		timer.Stop()
+		return nil
+	}
+
+	// apply the writes in key order so that the root is the same no matter
+	// how the map iterates.
+	keys := make([]string, 0, len(x.writes))
+	for key, w := range x.writes {
+		if !x.t.fits([]byte(key), w.value) {
+			timer.Stop()
+			return Error.New("entry too large to fit")
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := x.t.grow([]byte(key)); err != nil {
+			timer.Stop()
+			return Error.Wrap(err)
+		}
+	}
+
+	// append any large values to the value log before changing the root,
+	// so that failing to do so leaves none of the writes applied.
+	values := make([][]byte, len(keys))
+	pointers := make([]bool, len(keys))
+	for i, key := range keys {
+		if w := x.writes[key]; !w.delete {
+			var err error
+			values[i], pointers[i], err = x.t.separate([]byte(key), w.value)
+			if err != nil {
+				timer.Stop()
+				return Error.Wrap(err)
+			}
+		}
+	}
+
+	seq := x.t.next()
+	for i, key := range keys {
+		if x.writes[key].delete {
+			if !x.t.root.Delete([]byte(key), seq) {
+				timer.Stop()
+				return Error.New("entry too large to fit")
+			}
+		} else if err := x.t.place(x.t.root, []byte(key), values[i], pointers[i], 0, seq); err != nil {
+			timer.Stop()
+			return Error.Wrap(err)
+		}
+	}
+
+	if err := x.t.flushIfFull(); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return nil
+}
+
+// validate returns a conflict error if any key the transaction read or
+// wrote has been written since it began.
+func (x *Txn) validate() error {
+	check := func(key string) error {
+		seq, err := x.t.lastWrite([]byte(key))
+		if err != nil {
+			return Error.Wrap(err)
+		} else if seq > x.start {
+			return ConflictError.New("key %q changed at sequence number %d", key, seq)
+		}
+		return nil
+	}
+
+	for key := range x.reads {
+		if err := check(key); err != nil {
+			return err
+		}
+	}
+	for key := range x.writes {
+		if _, ok := x.reads[key]; ok {
+			continue
+		}
+		if err := check(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fits returns true if the key and value can be stored in a node, putting
+// the value in the value log if it is configured and the value is large.
+func (t *T) fits(key, value []byte) bool {
+	if len(key) > entry.KeyMask {
+		return false
+	}
+	if t.vlog != nil && uint64(len(value)) > uint64(t.vthresh) {
+		return true
+	}
+	return len(value) <= entry.ValueMask
+}
@@ -0,0 +1,179 @@
+package wosl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+	"github.com/zeebo/errs"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+func TestTxn(t *testing.T) {
+	sl, _ := newTestList(t)
+	sl.SetMergeOperator(counter{})
+
+	value := func(i, v int) []byte { return []byte(fmt.Sprint("value", i, "-", v)) }
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, sl.Insert(testKey(i), value(i, 0)))
+	}
+
+	get := func(t *testing.T, x *Txn, k []byte) string {
+		t.Helper()
+		got, err := x.Get(k)
+		assert.NoError(t, err)
+		return string(got)
+	}
+
+	t.Run("ReadYourWrites", func(t *testing.T) {
+		x := sl.Begin()
+		assert.NoError(t, x.Put(testKey(0), value(0, 1)))
+		assert.NoError(t, x.Delete(testKey(1)))
+		assert.NoError(t, x.Put(testKey(200), value(200, 1)))
+		assert.Equal(t, get(t, x, testKey(0)), string(value(0, 1)))
+		assert.Equal(t, get(t, x, testKey(1)), "")
+		assert.Equal(t, get(t, x, testKey(2)), string(value(2, 0)))
+
+		// nothing is visible until it commits.
+		got, err := sl.Read(testKey(0))
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(value(0, 0)))
+
+		// keep the older versions around to check the commit was atomic.
+		sl.SetWatermark(sl.Seq())
+		assert.NoError(t, x.Commit())
+		seq := sl.Seq()
+		for k, want := range map[int][]byte{0: value(0, 1), 1: nil, 200: value(200, 1)} {
+			got, err := sl.Read(testKey(k))
+			assert.NoError(t, err)
+			assert.Equal(t, string(got), string(want))
+		}
+
+		// every write was applied with the same sequence number.
+		got, err = sl.ReadAt(testKey(0), seq-1)
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(value(0, 0)))
+		got, err = sl.ReadAt(testKey(1), seq-1)
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(value(1, 0)))
+		got, err = sl.ReadAt(testKey(200), seq-1)
+		assert.NoError(t, err)
+		assert.Nil(t, got)
+		sl.SetWatermark(entry.MaxSeq)
+
+		// finished transactions cannot be used.
+		assert.Error(t, x.Commit())
+		assert.Error(t, x.Put(testKey(0), nil))
+		_, err = x.Get(testKey(0))
+		assert.Error(t, err)
+	})
+
+	t.Run("Conflicts", func(t *testing.T) {
+		conflict := func(t *testing.T, x *Txn, write func()) {
+			t.Helper()
+			write()
+			err := x.Commit()
+			assert.Error(t, err)
+			assert.That(t, ConflictError.Has(err))
+		}
+
+		// write-write.
+		x := sl.Begin()
+		assert.NoError(t, x.Put(testKey(10), value(10, 1)))
+		conflict(t, x, func() { assert.NoError(t, sl.Insert(testKey(10), value(10, 2))) })
+		got, err := sl.Read(testKey(10))
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(value(10, 2)))
+
+		// read-write, including reads of missing keys.
+		x = sl.Begin()
+		get(t, x, testKey(11))
+		assert.NoError(t, x.Put(testKey(12), value(12, 1)))
+		conflict(t, x, func() { assert.NoError(t, sl.Delete(testKey(11))) })
+		got, err = sl.Read(testKey(12))
+		assert.NoError(t, err)
+		assert.Equal(t, string(got), string(value(12, 0)))
+
+		x = sl.Begin()
+		assert.Equal(t, get(t, x, testKey(300)), "")
+		conflict(t, x, func() { assert.NoError(t, sl.Insert(testKey(300), value(300, 0))) })
+
+		// merges and range deletes are writes too.
+		x = sl.Begin()
+		get(t, x, testKey(13))
+		conflict(t, x, func() {
+			assert.NoError(t, sl.Merge(testKey(13), binary.BigEndian.AppendUint64(nil, 1)))
+		})
+
+		x = sl.Begin()
+		get(t, x, testKey(25))
+		conflict(t, x, func() { assert.NoError(t, sl.DeleteRange(testKey(20), testKey(30))) })
+
+		// writes to other keys do not conflict.
+		x = sl.Begin()
+		get(t, x, testKey(50))
+		assert.NoError(t, x.Put(testKey(51), value(51, 1)))
+		assert.NoError(t, sl.Insert(testKey(52), value(52, 1)))
+		assert.NoError(t, sl.DeleteRange(testKey(60), testKey(70)))
+		assert.NoError(t, x.Commit())
+	})
+
+	t.Run("Flushed", func(t *testing.T) {
+		x := sl.Begin()
+		get(t, x, testKey(5))
+		assert.NoError(t, x.Put(testKey(6), value(6, 1)))
+
+		// moving the entries down the skip list is not a write.
+		for i := 1000; i < 2000; i++ {
+			assert.NoError(t, sl.Insert(testKey(i), value(i, 0)))
+		}
+		assert.NoError(t, x.Commit())
+
+		// but writes that were flushed down are still seen.
+		x = sl.Begin()
+		get(t, x, testKey(5))
+		assert.NoError(t, sl.Insert(testKey(5), value(5, 1)))
+		for i := 2000; i < 3000; i++ {
+			assert.NoError(t, sl.Insert(testKey(i), value(i, 0)))
+		}
+		err := x.Commit()
+		assert.Error(t, err)
+		assert.That(t, ConflictError.Has(err))
+	})
+
+	t.Run("ValueLogFailure", func(t *testing.T) {
+		sl, _ := newTestList(t)
+		disk := &failDisk{memDisk: newMemDisk(1 << 10)}
+		assert.NoError(t, sl.SetValueLog(disk, 64))
+
+		// the second large value starts a new segment, writing the first.
+		x := sl.Begin()
+		assert.NoError(t, x.Put(testKey(1), value(1, 1)))
+		assert.NoError(t, x.Put(testKey(2), kilobuf[:600]))
+		assert.NoError(t, x.Put(testKey(3), kilobuf[:600]))
+		disk.fail = true
+		assert.Error(t, x.Commit())
+
+		// none of the writes were applied.
+		for i := 1; i <= 3; i++ {
+			got, err := sl.Read(testKey(i))
+			assert.NoError(t, err)
+			assert.Nil(t, got)
+		}
+	})
+}
+
+// failDisk is a memDisk whose writes fail once fail is set.
+type failDisk struct {
+	*memDisk
+	fail bool
+}
+
+func (f *failDisk) Write(block uint32, data []byte) error {
+	if f.fail {
+		return errs.New("write failed")
+	}
+	return f.memDisk.Write(block, data)
+}
@@ -0,0 +1,255 @@
+package wosl
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/zeebo/mon"
+	"github.com/zeebo/wosl/internal/node/entry"
+)
+
+const vlogPointerSize = (0 +
+	4 + // segment
+	4 + // offset
+	4 + // value length
+	0)
+
+const vlogRecordHeaderSize = (0 +
+	2 + // key length
+	4 + // value length
+	0)
+
+// vlogPointer is a reference to a value stored in some segment of the
+// value log.
+type vlogPointer struct {
+	segment uint32
+	offset  uint32
+	length  uint32
+}
+
+// readPointer parses the pointer out of the buffer.
+func readPointer(buf []byte) (vlogPointer, error) {
+	if len(buf) != vlogPointerSize {
+		return vlogPointer{}, Error.New("invalid value log pointer length: %d", len(buf))
+	}
+	return vlogPointer{
+		segment: binary.BigEndian.Uint32(buf[0:4]),
+		offset:  binary.BigEndian.Uint32(buf[4:8]),
+		length:  binary.BigEndian.Uint32(buf[8:12]),
+	}, nil
+}
+
+// Write marshals the pointer into the provided buffer.
+func (p vlogPointer) Write(buf []byte) []byte {
+	var tmp [vlogPointerSize]byte
+	binary.BigEndian.PutUint32(tmp[0:4], p.segment)
+	binary.BigEndian.PutUint32(tmp[4:8], p.offset)
+	binary.BigEndian.PutUint32(tmp[8:12], p.length)
+	return append(buf, tmp[:]...)
+}
+
+// valueLog is an append only log of values that are too large to be copied
+// through the buffers of the skip list at every flush. Values are packed
+// along with their keys into segments, which are stored as the blocks of
+// some Disk. It is safe for concurrent use so that background flushes can
+// move values into it.
+type valueLog struct {
+	mu   sync.Mutex
+	disk Disk
+	size uint32 // target size of a segment
+	head uint32 // oldest segment that may still have live values
+	tail uint32 // segment currently being appended to
+	buf  []byte // contents of the tail segment
+}
+
+// newValueLog opens a value log stored on the disk, appending to a fresh
+// segment after any that already exist.
+func newValueLog(disk Disk) (*valueLog, error) {
+	maxBlock, err := disk.MaxBlock()
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	if maxBlock == invalidBlock {
+		return nil, Error.New("value log is out of segments")
+	}
+
+	// segments are deleted in order by garbage collection, so the head is
+	// the first segment that still exists.
+	head := maxBlock + 1
+	for segment := uint32(1); segment <= maxBlock; segment++ {
+		if buf, err := disk.Read(segment); err != nil {
+			return nil, Error.Wrap(err)
+		} else if buf != nil {
+			head = segment
+			break
+		}
+	}
+
+	return &valueLog{
+		disk: disk,
+		size: disk.BlockSize(),
+		head: head,
+		tail: maxBlock + 1,
+	}, nil
+}
+
+var vlogAppendThunk mon.Thunk // timing info for valueLog.Append
+
+// Append adds the key and value to the log and returns a pointer that can
+// be used to read the value back.
+func (v *valueLog) Append(key, value []byte) (vlogPointer, error) {
+	timer := vlogAppendThunk.Start()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(key) > entry.KeyMask || uint64(len(value)) > math.MaxUint32 {
+		timer.Stop()
+		return vlogPointer{}, Error.New("entry too large to fit")
+	}
+
+	// if the record would push the tail over the segment size, start a
+	// new segment. a segment always contains at least one record.
+	size := uint64(vlogRecordHeaderSize + len(key) + len(value))
+	if len(v.buf) > 0 && uint64(len(v.buf))+size > uint64(v.size) {
+		if err := v.sync(); err != nil {
+			timer.Stop()
+			return vlogPointer{}, Error.Wrap(err)
+		}
+		if v.tail == invalidBlock-1 {
+			timer.Stop()
+			return vlogPointer{}, Error.New("value log is out of segments")
+		}
+		v.tail++
+		v.buf = nil
+	}
+	if uint64(len(v.buf))+size > math.MaxUint32 {
+		timer.Stop()
+		return vlogPointer{}, Error.New("entry too large to fit")
+	}
+
+	var hdr [vlogRecordHeaderSize]byte
+	binary.BigEndian.PutUint16(hdr[0:2], uint16(len(key)))
+	binary.BigEndian.PutUint32(hdr[2:6], uint32(len(value)))
+
+	v.buf = append(v.buf, hdr[:]...)
+	v.buf = append(v.buf, key...)
+	ptr := vlogPointer{
+		segment: v.tail,
+		offset:  uint32(len(v.buf)),
+		length:  uint32(len(value)),
+	}
+	v.buf = append(v.buf, value...)
+
+	timer.Stop()
+	return ptr, nil
+}
+
+// Read returns the value that the pointer refers to, reading only the value
+// from the disk if it supports ranged reads. It is not safe to modify the
+// returned slice.
+func (v *valueLog) Read(ptr vlogPointer) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	end := uint64(ptr.offset) + uint64(ptr.length)
+	if ptr.segment == v.tail {
+		if end > uint64(len(v.buf)) {
+			return nil, Error.New("value log pointer out of range: %d > %d",
+				end, len(v.buf))
+		}
+		return v.buf[ptr.offset:end], nil
+	}
+
+	value, err := readAt(v.disk, ptr.segment, ptr.offset, ptr.length)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	} else if value == nil {
+		return nil, Error.New("missing value log segment: %d", ptr.segment)
+	} else if uint32(len(value)) < ptr.length {
+		return nil, Error.New("value log pointer out of range: %d > %d",
+			end, uint64(ptr.offset)+uint64(len(value)))
+	}
+	return value, nil
+}
+
+// Sync writes the tail segment to the disk.
+func (v *valueLog) Sync() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.sync()
+}
+
+// sync writes the tail segment to the disk while holding the mutex.
+func (v *valueLog) sync() error {
+	if len(v.buf) == 0 {
+		return nil
+	}
+	return Error.Wrap(v.disk.Write(v.tail, v.buf))
+}
+
+// Head returns the oldest segment in the log, and false if there are no
+// segments other than the tail.
+func (v *valueLog) Head() (uint32, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.head, v.head < v.tail
+}
+
+// Iterate calls the callback with every record in the segment, along with
+// a pointer to the value. It stops if the callback returns an error.
+func (v *valueLog) Iterate(segment uint32,
+	cb func(key, value []byte, ptr vlogPointer) error) error {
+
+	v.mu.Lock()
+	seg, err := v.disk.Read(segment)
+	v.mu.Unlock()
+	if err != nil {
+		return Error.Wrap(err)
+	}
+
+	for offset := uint64(0); offset < uint64(len(seg)); {
+		rec := seg[offset:]
+		if len(rec) < vlogRecordHeaderSize {
+			return Error.New("truncated value log record in segment: %d", segment)
+		}
+		klen := uint64(binary.BigEndian.Uint16(rec[0:2]))
+		vlen := uint64(binary.BigEndian.Uint32(rec[2:6]))
+		if uint64(len(rec)) < vlogRecordHeaderSize+klen+vlen {
+			return Error.New("truncated value log record in segment: %d", segment)
+		}
+
+		key := rec[vlogRecordHeaderSize : vlogRecordHeaderSize+klen]
+		value := rec[vlogRecordHeaderSize+klen : vlogRecordHeaderSize+klen+vlen]
+		ptr := vlogPointer{
+			segment: segment,
+			offset:  uint32(offset + vlogRecordHeaderSize + klen),
+			length:  uint32(vlen),
+		}
+		if err := cb(key, value, ptr); err != nil {
+			return err
+		}
+
+		offset += vlogRecordHeaderSize + klen + vlen
+	}
+
+	return nil
+}
+
+// Delete removes the head segment from the disk, reclaiming its space.
+func (v *valueLog) Delete() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.head >= v.tail {
+		return Error.New("cannot delete the tail segment")
+	}
+	if err := v.disk.Delete(v.head); err != nil {
+		return Error.Wrap(err)
+	}
+	v.head++
+	return nil
+}
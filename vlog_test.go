@@ -0,0 +1,200 @@
+package wosl
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/zeebo/assert"
+)
+
+func TestValueLog(t *testing.T) {
+	t.Run("Basic", func(t *testing.T) {
+		disk := newMemDisk(1 << 10)
+		vlog, err := newValueLog(disk)
+		assert.NoError(t, err)
+
+		var ptrs []vlogPointer
+		for i := 0; i < 100; i++ {
+			ptr, err := vlog.Append(numbers[i], kilobuf[:i])
+			assert.NoError(t, err)
+			ptrs = append(ptrs, ptr)
+		}
+		assert.That(t, vlog.tail > 1)
+
+		for i, ptr := range ptrs {
+			value, err := vlog.Read(ptr)
+			assert.NoError(t, err)
+			assert.Equal(t, len(value), i)
+		}
+	})
+
+	t.Run("Iterate", func(t *testing.T) {
+		disk := newMemDisk(1 << 10)
+		vlog, err := newValueLog(disk)
+		assert.NoError(t, err)
+
+		var ptrs []vlogPointer
+		for i := 0; i < 100; i++ {
+			ptr, err := vlog.Append(numbers[i], kilobuf[:i])
+			assert.NoError(t, err)
+			ptrs = append(ptrs, ptr)
+		}
+
+		i := 0
+		for segment := uint32(1); segment < vlog.tail; segment++ {
+			assert.NoError(t, vlog.Iterate(segment, func(key, value []byte, ptr vlogPointer) error {
+				assert.Equal(t, string(key), string(numbers[i]))
+				assert.Equal(t, len(value), i)
+				assert.Equal(t, ptr, ptrs[i])
+				i++
+				return nil
+			}))
+		}
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		disk := newMemDisk(1 << 10)
+		vlog, err := newValueLog(disk)
+		assert.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			_, err := vlog.Append(numbers[i], kilobuf[:i])
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, vlog.Sync())
+		assert.NoError(t, vlog.Delete())
+		assert.NoError(t, vlog.Delete())
+
+		vlog2, err := newValueLog(disk)
+		assert.NoError(t, err)
+		assert.Equal(t, vlog2.head, vlog.head)
+		assert.Equal(t, vlog2.tail, vlog.tail+1)
+	})
+
+	t.Run("Ranged", func(t *testing.T) {
+		disk := &rangeDisk{memDisk: newMemDisk(1 << 10)}
+		vlog, err := newValueLog(disk)
+		assert.NoError(t, err)
+
+		var ptrs []vlogPointer
+		for i := 0; i < 100; i++ {
+			ptr, err := vlog.Append(numbers[i], kilobuf[:i])
+			assert.NoError(t, err)
+			ptrs = append(ptrs, ptr)
+		}
+		assert.NoError(t, vlog.Sync())
+
+		for i, ptr := range ptrs {
+			value, err := vlog.Read(ptr)
+			assert.NoError(t, err)
+			assert.Equal(t, len(value), i)
+		}
+		assert.Equal(t, disk.reads, 0)
+		assert.That(t, disk.ranged > 0)
+
+		_, err = vlog.Read(vlogPointer{segment: 1, offset: 1 << 10, length: 1})
+		assert.Error(t, err)
+	})
+}
+
+// rangeDisk is a memDisk that can read part of a block, counting how many
+// whole and partial reads it does.
+type rangeDisk struct {
+	*memDisk
+	reads  int
+	ranged int
+}
+
+func (r *rangeDisk) Read(block uint32) ([]byte, error) {
+	r.reads++
+	return r.memDisk.Read(block)
+}
+
+func (r *rangeDisk) ReadAt(block uint32, off, n uint32) ([]byte, error) {
+	r.ranged++
+	buf, err := r.memDisk.Read(block)
+	if err != nil || buf == nil {
+		return nil, err
+	}
+	if uint64(off) > uint64(len(buf)) {
+		off = uint32(len(buf))
+	}
+	if uint64(off)+uint64(n) < uint64(len(buf)) {
+		return buf[off : off+n], nil
+	}
+	return buf[off:], nil
+}
+
+func TestValueLogSeparation(t *testing.T) {
+	sl, _ := newTestList(t)
+	assert.NoError(t, sl.SetValueLog(newMemDisk(1<<15), 64))
+
+	value := func(i, gen int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprint(i, gen)), i%200)
+	}
+
+	for gen := 0; gen < 3; gen++ {
+		for i := 0; i < 300; i++ {
+			assert.NoError(t, sl.Insert([]byte(fmt.Sprint(i)), value(i, gen)))
+		}
+	}
+
+	check := func() {
+		for i := 0; i < 300; i++ {
+			got, err := sl.Read([]byte(fmt.Sprint(i)))
+			assert.NoError(t, err)
+			assert.Equal(t, string(got), string(value(i, 2)))
+		}
+	}
+
+	check()
+
+	// collect every segment that existed before we started. live values
+	// are relocated to the tail, so collecting forever would never end.
+	tail := sl.vlog.tail
+	for sl.vlog.head < tail {
+		ok, err := sl.CollectValues()
+		assert.NoError(t, err)
+		assert.That(t, ok)
+	}
+	check()
+
+	// everything from the first two generations should have been reclaimed.
+	assert.That(t, len(sl.vlog.disk.(*memDisk).blocks) < int(tail-1)/2)
+}
+
+func TestCollectValuesWatermark(t *testing.T) {
+	sl, _ := newTestList(t)
+	assert.NoError(t, sl.SetValueLog(newMemDisk(1<<10), 64))
+
+	old, cur := bytes.Repeat([]byte("o"), 1000), bytes.Repeat([]byte("c"), 1000)
+
+	// keep the first version of the key readable after overwriting it.
+	assert.NoError(t, sl.Insert([]byte("key"), old))
+	seq := sl.Seq()
+	sl.SetWatermark(seq)
+	assert.NoError(t, sl.Insert([]byte("key"), cur))
+	assert.NoError(t, sl.Insert([]byte("other"), cur))
+
+	// the segment with the old value is pinned by the watermark.
+	ok, err := sl.CollectValues()
+	assert.NoError(t, err)
+	assert.That(t, !ok)
+
+	got, err := sl.ReadAt([]byte("key"), seq)
+	assert.NoError(t, err)
+	assert.Equal(t, string(got), string(old))
+
+	// once the watermark passes it, the segment can be collected.
+	sl.SetWatermark(sl.Seq())
+	ok, err = sl.CollectValues()
+	assert.NoError(t, err)
+	assert.That(t, ok)
+
+	got, err = sl.Read([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(got), string(cur))
+	_, err = sl.ReadAt([]byte("key"), seq)
+	assert.Error(t, err)
+}
@@ -1,12 +1,17 @@
 package wosl
 
 import (
+	"bytes"
 	"math"
+	"sync"
 
 	"github.com/cespare/xxhash"
 	"github.com/zeebo/errs"
 	"github.com/zeebo/mon"
 	"github.com/zeebo/wosl/internal/node"
+	"github.com/zeebo/wosl/internal/node/btree"
+	"github.com/zeebo/wosl/internal/node/entry"
+	"github.com/zeebo/wosl/lease"
 )
 
 var Error = errs.Class("wosl")
@@ -17,13 +22,34 @@ const (
 	invalidBlock uint32 = math.MaxUint32
 )
 
-// T is a write-optimized skip list. It is not thread safe.
+// T is a write-optimized skip list. It is not thread safe, even though it
+// may flush in a background goroutine.
 type T struct {
 	eps     float64
 	cache   Cache
 	disk    Disk
 	root    *node.T
-	scratch []byte
+	vlog    *valueLog     // optional log for large values
+	vthresh uint32        // values larger than this go into the vlog
+	comp    Compressor    // optional compressor for written nodes
+	limit   uint64        // root length that causes a flush
+	fanout  uint16        // entries per node in the btree of a node
+	merge   MergeOperator // optional operator for merge operands
+	clock   Clock         // optional clock for expiring entries
+	bits    uint8         // bits per key of node Bloom filters, or zero
+	seq     uint64        // sequence number of the last write
+	mark    uint64        // watermark, or entry.MaxSeq to follow seq
+	async   int           // most roots queued to be flushed, or zero
+	partial bool          // if reads load children in parts from the disk
+	alloc   Allocator     // optional chooser of blocks for new nodes
+
+	lower   sync.Mutex    // held while using anything below the root
+	mu      sync.Mutex    // protects the fields below
+	cond    *sync.Cond    // signaled when the queue or running change
+	queue   []*node.T     // roots waiting to be flushed, oldest first
+	ferr    error         // error from a background flush
+	running bool          // if the background flusher should keep going
+	done    chan struct{} // closed when the background flusher exits
 
 	maxBlock uint32 // largest stored block from disk
 	b        uint32 // block size from disk
@@ -68,13 +94,18 @@ func NewEps(eps float64, cache Cache) (*T, error) {
 	} else if root, err = node.Load(buf); err != nil {
 		return nil, Error.Wrap(err)
 	}
+	root.SetFanout(btree.DefaultFanout)
+	root.SetWatermark(root.Seq())
 
 	return &T{
-		eps:     eps,
-		cache:   cache,
-		disk:    disk,
-		root:    root,
-		scratch: make([]byte, b),
+		eps:    eps,
+		cache:  cache,
+		disk:   disk,
+		root:   root,
+		limit:  uint64(b),
+		fanout: btree.DefaultFanout,
+		seq:    root.Seq(),
+		mark:   entry.MaxSeq,
 
 		maxBlock: maxBlock,
 		b:        b,
@@ -85,6 +116,88 @@ func NewEps(eps float64, cache Cache) (*T, error) {
 	}, nil
 }
 
+// SetValueLog configures the skip list to store any value larger than the
+// threshold in a value log kept on the provided disk. Only a small pointer
+// to the value is then copied through the buffers during flushes. It must be
+// called before any other method, and must be called with the same disk for
+// every use of the same backing store once any values have been stored.
+func (t *T) SetValueLog(disk Disk, threshold uint32) error {
+	vlog, err := newValueLog(disk)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	t.vlog = vlog
+	t.vthresh = threshold
+	return nil
+}
+
+// SetCompressor configures the skip list to compress every node it writes
+// with the compressor. The root is then only flushed once its compressed
+// size reaches the block size. It must be called before any other method.
+// Nodes are always loaded with the compressor they were written with, so
+// it need not be the same for every use of the same backing store. It
+// returns an error if a different compressor with the same codec is in use.
+func (t *T) SetCompressor(comp Compressor) error {
+	if err := node.RegisterCompressor(comp); err != nil {
+		return Error.Wrap(err)
+	}
+	t.comp = comp
+	t.root.SetCompressor(comp)
+	return nil
+}
+
+// SetBloomFilter configures the skip list to build a Bloom filter with the
+// given bits per key into every node it writes, which reads use to skip
+// searching nodes that do not have the key. Ten bits per key gives about a
+// one percent false positive rate. Zero disables building them, though any
+// existing filters are still used.
+func (t *T) SetBloomFilter(bitsPerKey uint8) {
+	t.bits = bitsPerKey
+	t.root.SetBloomBits(bitsPerKey)
+}
+
+// SetFanout configures how many entries each node of the btree that indexes
+// the entries of a skip list node has room for. Larger fanouts make for
+// shallower btrees, but every insert must leave room for a few btree nodes
+// to split into, so they leave less of each block for entries. By default,
+// the fanout is 127. A fanout of 0 chooses one for the block size so that
+// the room is about a sixth of the block, which is the minimum fanout of 3
+// for blocks smaller than about 5.5KB. Otherwise, it must be at least 3. It
+// must be called before any other method. Nodes that were written with a
+// different fanout keep it.
+func (t *T) SetFanout(fanout uint16) error {
+	if fanout == 0 {
+		fanout = btree.FanoutFor(uint64(t.b) / 64)
+	} else if fanout < btree.MinFanout {
+		return Error.New("invalid fanout: %d", fanout)
+	}
+	t.fanout = fanout
+	t.root.SetFanout(fanout)
+	return nil
+}
+
+// SetPartialReads configures reads to load the nodes below the root straight
+// from the disk, reading only the parts of each node needed to find a key,
+// instead of loading every node whole through the cache. It is meant for
+// disks that implement RangeDisk, and other disks have every node read in
+// full for each part. A node the cache holds may be newer than the disk, so
+// if the cache is a PeekCache, the nodes it holds are read from it instead,
+// and otherwise it must write a node back to the disk by the time the last
+// lease on it is released. It must be called before any other method.
+func (t *T) SetPartialReads(enabled bool) {
+	t.partial = enabled
+}
+
+// SetAllocator configures the skip list to write every new node to the
+// block the allocator returns for its height, instead of the block after
+// the largest one written so far. The blocks must be on the disk of the
+// cache, and must never be block 1, which is reserved for the root. A
+// TieredDisk is an allocator that places nodes on its tiers. It must be
+// called before any other method.
+func (t *T) SetAllocator(alloc Allocator) {
+	t.alloc = alloc
+}
+
 // height returns the height of the key.
 func (t *T) height(key []byte) uint32 {
 	return height(xxhash.Sum64(key), t.rBneps, t.rBeps)
@@ -96,40 +209,159 @@ var insertThunk mon.Thunk // timing for Insert
 func (t *T) Insert(key, value []byte) error {
 	timer := insertThunk.Start()
 
+	if err := t.insert(key, value, 0, t.next()); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return nil
+}
+
+// insert associates value with key in the root as of the sequence number,
+// expiring it at the expiry in nanoseconds since the unix epoch if it is
+// not zero, and flushes the root if it has grown too large.
+func (t *T) insert(key, value []byte, expiry int64, seq uint64) error {
+	if err := t.grow(key); err != nil {
+		return Error.Wrap(err)
+	}
+	if err := t.put(t.root, key, value, expiry, seq); err != nil {
+		return Error.Wrap(err)
+	}
+	return t.flushIfFull()
+}
+
+// grow allocates new roots until the root is taller than the height of
+// the key.
+func (t *T) grow(key []byte) error {
 	// Compute the height for the key to check if we need to allocate
 	// new roots. This should be very rare, so it's ok if it's somewhat
 	// inefficient.
 	h := t.height(key)
+	if h < t.root.Height() {
+		return nil
+	}
+
+	// the queued roots must be flushed first so that they stay newer than
+	// everything below the new root.
+	if err := t.WaitFlushed(); err != nil {
+		return Error.Wrap(err)
+	}
+	t.lower.Lock()
+	defer t.lower.Unlock()
+
 	for h >= t.root.Height() {
 		if err := t.newRoot(); err != nil {
-			timer.Stop()
 			return Error.Wrap(err)
 		}
 	}
+	return nil
+}
+
+// put inserts the value into the node as of the sequence number, or a
+// pointer to it if it belongs in the value log, expiring it at the expiry
+// in nanoseconds since the unix epoch if it is not zero.
+func (t *T) put(n *node.T, key, value []byte, expiry int64, seq uint64) error {
+	value, pointer, err := t.separate(key, value)
+	if err != nil {
+		return Error.Wrap(err)
+	}
+	return t.place(n, key, value, pointer, expiry, seq)
+}
 
-	// insert the value. if it cannot be fit, then there's nothing to do.
-	if !t.root.Insert(key, value, 0) {
-		timer.Stop()
+// separate appends the value to the value log if it belongs there, and
+// returns the pointer to it and true. Otherwise, it returns the value.
+func (t *T) separate(key, value []byte) ([]byte, bool, error) {
+	if t.vlog == nil || uint64(len(value)) <= uint64(t.vthresh) {
+		return value, false, nil
+	}
+	ptr, err := t.vlog.Append(key, value)
+	if err != nil {
+		return nil, false, Error.Wrap(err)
+	}
+	return ptr.Write(make([]byte, 0, vlogPointerSize)), true, nil
+}
+
+// place inserts the value, which is a value log pointer if pointer is true,
+// into the node as of the sequence number, expiring it at the expiry in
+// nanoseconds since the unix epoch if it is not zero.
+func (t *T) place(n *node.T, key, value []byte, pointer bool, expiry int64, seq uint64) error {
+	var wrote bool
+	switch {
+	case expiry != 0:
+		wrote = n.InsertExpiring(key, value, expiry, pointer, 0, seq)
+	case pointer:
+		wrote = n.InsertPointer(key, value, 0, seq)
+	default:
+		wrote = n.Insert(key, value, 0, seq)
+	}
+
+	// if it cannot be fit, then there's nothing to do.
+	if !wrote {
 		return Error.New("entry too large to fit")
 	}
+	return nil
+}
 
+// flushIfFull flushes the root if it has grown large enough.
+func (t *T) flushIfFull() error {
 	// if we're still inside the block range, we're done!
-	if t.root.Length() < uint64(t.b) {
-		timer.Stop()
+	if full, err := t.rootFull(); err != nil {
+		return Error.Wrap(err)
+	} else if !full {
 		return nil
 	}
 
+	// a root of height 1 has no children to flush into, so it is only
+	// swapped out once it has some.
+	if t.async > 0 && t.root.Height() > 1 {
+		return t.swapRoot()
+	}
+
+	// any roots still queued from flushing asynchronously are older than
+	// the root, so they have to be flushed first.
+	t.lower.Lock()
+	defer t.lower.Unlock()
+	if err := t.drain(); err != nil {
+		return Error.Wrap(err)
+	}
+
 	// flush the root and any children that are required. it doesn't need to
 	// have a slice of parents because it can't possibly split.
 	if _, _, err := t.flush(t.root, rootBlock, nil); err != nil {
-		timer.Stop()
 		return Error.Wrap(err)
 	}
-
-	timer.Stop()
 	return nil
 }
 
+// rootFull returns true if the root has grown large enough to be flushed.
+// With a compressor, the root is only full once its estimated compressed
+// size reaches the block size, so the limit on the raw size is raised in
+// proportion to the compression ratio observed when it is reached. It is
+// raised by at least a sixteenth of a block so that the estimate is not
+// made for every insert.
+func (t *T) rootFull() (bool, error) {
+	length := t.root.Length()
+	if length < t.limit {
+		return false, nil
+	} else if t.comp == nil {
+		return true, nil
+	}
+
+	size, err := t.root.CompressedLength()
+	if err != nil {
+		return false, Error.Wrap(err)
+	} else if size >= uint64(t.b) {
+		return true, nil
+	}
+
+	t.limit = length * uint64(t.b) / size
+	if min := length + uint64(t.b)/16; t.limit < min {
+		t.limit = min
+	}
+	return false, nil
+}
+
 // newRoot allocates and writes out a new root to the rootBlock, having it
 // point to the current root (which is written to some other new block).
 func (t *T) newRoot() error {
@@ -141,12 +373,29 @@ func (t *T) newRoot() error {
 	t.cache.Add(t.root, block)
 	t.root = node.New(t.root.Height() + 1)
 	t.root.SetPivot(block)
+	t.root.SetCompressor(t.comp)
+	t.root.SetFanout(t.fanout)
+	t.root.SetBloomBits(t.bits)
+	t.root.SetWatermark(t.Watermark())
 	return nil
 }
 
 // writeNewNode saves the node to disk and returns the block number
 // it was written with.
 func (t *T) writeNewNode(n *node.T) (uint32, error) {
+	if t.alloc != nil {
+		block, err := t.alloc.Alloc(n.Height())
+		if err != nil {
+			return 0, Error.Wrap(err)
+		} else if err := t.writeNode(n, block); err != nil {
+			return 0, Error.Wrap(err)
+		}
+		if block > t.maxBlock {
+			t.maxBlock = block
+		}
+		return block, nil
+	}
+
 	block := t.maxBlock + 1
 	if err := t.writeNode(n, block); err != nil {
 		return 0, Error.Wrap(err)
@@ -157,33 +406,577 @@ func (t *T) writeNewNode(n *node.T) (uint32, error) {
 
 // writeNode saves the node to the given block.
 func (t *T) writeNode(n *node.T, block uint32) error {
-	var err error
-	if t.scratch, err = n.Write(t.scratch[:0]); err != nil {
+	// the node holds on to the buffer it was written with, so we can't
+	// reuse any scratch space.
+	buf, err := n.Write(nil)
+	if err != nil {
 		return Error.Wrap(err)
-	} else if err := t.disk.Write(block, t.scratch); err != nil {
+	} else if err := t.disk.Write(block, buf); err != nil {
 		return Error.Wrap(err)
 	}
 	return nil
 }
 
+var readThunk mon.Thunk // timing for Read
+
 // Read returns the data for k if it exists. Otherwise, it returns nil. It is
 // not safe to modify the returned slice.
 func (t *T) Read(key []byte) ([]byte, error) {
-	panic("not implemented")
+	timer := readThunk.Start()
+
+	value, _, err := t.read(key, entry.MaxSeq)
+	if err != nil {
+		timer.Stop()
+		return nil, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return value, nil
+}
+
+// read returns the value for the key as of the sequence number, and false
+// if there is none. It fetches the value from the value log if it was
+// separated, and applies any merge operands to it.
+func (t *T) read(key []byte, seq uint64) ([]byte, bool, error) {
+	ent, value, operands, ok, err := t.lookup(key, seq)
+	if err != nil {
+		return nil, false, Error.Wrap(err)
+	}
+	return t.resolve(key, ent, value, operands, ok)
+}
+
+// resolve returns the value for the key from the entry found for it, if ok,
+// and the merge operands found above it, and false if there is none.
+func (t *T) resolve(key []byte, ent entry.T, value []byte, operands [][]byte, ok bool) (
+	[]byte, bool, error) {
+
+	var err error
+	ok = ok && !ent.Tombstone() && !t.expired(ent, value)
+	if ok {
+		value, err = t.entryValue(ent, value)
+		if err != nil {
+			return nil, false, Error.Wrap(err)
+		}
+	} else {
+		value = nil
+	}
+
+	if len(operands) == 0 {
+		return value, ok, nil
+	}
+	value, err = t.applyOperands(key, value, operands)
+	if err != nil {
+		return nil, false, Error.Wrap(err)
+	}
+	return value, true, nil
+}
+
+// readPointer returns the value stored in the value log for the pointer.
+func (t *T) readPointer(buf []byte) ([]byte, error) {
+	if t.vlog == nil {
+		return nil, Error.New("found value log pointer without a value log")
+	}
+	ptr, err := readPointer(buf)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return t.vlog.Read(ptr)
+}
+
+// lookup walks down from the root to find the most recent entry for the key
+// as of the sequence number that is not a merge operand. It returns false
+// if there is no entry. The value is a slice of the buffer of whichever
+// node contained the entry. The operands of any merge entries above it are
+// returned newest first.
+func (t *T) lookup(key []byte, seq uint64) (
+	ent entry.T, value []byte, operands [][]byte, ok bool, err error) {
+
+	c := t.cursor()
+	defer c.close()
+
+	for {
+		var child uint32
+		ent, value, child, ok, err = c.search(key, seq)
+		if err != nil {
+			return entry.T{}, nil, nil, false, err
+		}
+
+		// a range tombstone in the node hides everything older than it,
+		// including the entries in the node itself.
+		rseq, covered, err := c.covering(key, seq)
+		if err != nil {
+			return entry.T{}, nil, nil, false, err
+		} else if covered && ok && ent.Seq() < rseq {
+			ok = false
+		}
+
+		if ok && ent.Merge() {
+			operands = append(operands, append([]byte(nil), value...))
+			if child, err = c.childOf(key); err != nil {
+				return entry.T{}, nil, nil, false, err
+			}
+			ok = false
+		}
+		if !ok && covered {
+			ent = entry.New(key, nil, true, 0)
+			ent.SetSeq(rseq)
+			return ent, nil, operands, true, nil
+		}
+
+		if ok {
+			return ent, value, operands, true, nil
+		} else if more, err := c.next(child); err != nil {
+			return entry.T{}, nil, nil, false, err
+		} else if !more {
+			return entry.T{}, nil, operands, false, nil
+		}
+	}
+}
+
+// lastWrite returns the sequence number of the newest write to the key,
+// including merge operands and range tombstones, or zero if it was never
+// written. Since entries only move down the skip list, it is the first one
+// found on the way down.
+func (t *T) lastWrite(key []byte) (uint64, error) {
+	c := t.cursor()
+	defer c.close()
+
+	for {
+		ent, _, child, ok, err := c.search(key, entry.MaxSeq)
+		if err != nil {
+			return 0, err
+		}
+		rseq, covered, err := c.covering(key, entry.MaxSeq)
+		if err != nil {
+			return 0, err
+		} else if covered && (!ok || ent.Seq() < rseq) {
+			return rseq, nil
+		} else if ok {
+			return ent.Seq(), nil
+		}
+		if more, err := c.next(child); err != nil || !more {
+			return 0, err
+		}
+	}
+}
+
+// descend returns the node in the child block of n, replacing the lease with
+// one on the child.
+func (t *T) descend(n *node.T, child uint32, le *lease.T) (*node.T, error) {
+	cle, err := t.cache.Get(child)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	le.Close()
+	*le = cle
+
+	if le.Node().Height() != n.Height()-1 {
+		return nil, Error.New(
+			"invalid child height at block %d: %d != %d",
+			child, le.Node().Height(), n.Height()-1)
+	}
+	return le.Node(), nil
 }
 
+// cached returns a lease on the node in the block if the cache holds it,
+// without reading it from the disk.
+func (t *T) cached(block uint32) (lease.T, bool) {
+	if pc, ok := t.cache.(PeekCache); ok {
+		return pc.Peek(block)
+	}
+	return lease.T{}, false
+}
+
+// loadPartial reads the parts of the node in the child block of a node at
+// the height that describe it, so that it can be searched with ranged reads
+// of the disk.
+func (t *T) loadPartial(height, child uint32) (*node.Partial, error) {
+	p, err := node.LoadPartial(func(off, n uint32) ([]byte, error) {
+		buf, err := readAt(t.disk, child, off, n)
+		if err != nil {
+			return nil, Error.Wrap(err)
+		} else if buf == nil {
+			return nil, Error.New("missing block: %d", child)
+		}
+		return buf, nil
+	})
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+
+	if p.Height() != height-1 {
+		return nil, Error.New(
+			"invalid child height at block %d: %d != %d",
+			child, p.Height(), height-1)
+	}
+	return p, nil
+}
+
+// search finds the newest version of the entry for the key in the node as
+// of the sequence number. If there is no such entry, it returns the block
+// of the child that would contain it.
+func search(n *node.T, key []byte, seq uint64) (
+	ent entry.T, value []byte, child uint32, ok bool) {
+
+	if !n.MayContain(key) {
+		return entry.T{}, nil, n.ChildOf(key), false
+	}
+
+	iter := n.Iterator()
+	if iter.Seek(key) && bytes.Equal(iter.Key(), key) {
+		if ent, value, ok := iter.At(seq); ok {
+			return ent, value, noBlock, true
+		}
+	}
+	return entry.T{}, nil, n.ChildOf(key), false
+}
+
+var collectValuesThunk mon.Thunk // timing for CollectValues
+
+// CollectValues reclaims the space used by the oldest segment of the value
+// log. Any values in it that are still live are first appended to the log
+// again. Values that an older version of a key can still be read with at or
+// after the watermark can't be moved, so the segment is not collected until
+// the watermark passes them. It returns false if there was no segment that
+// could be collected.
+func (t *T) CollectValues() (bool, error) {
+	timer := collectValuesThunk.Start()
+
+	if t.vlog == nil {
+		timer.Stop()
+		return false, Error.New("no value log configured")
+	}
+
+	segment, ok := t.vlog.Head()
+	if !ok {
+		timer.Stop()
+		return false, nil
+	}
+
+	// find the values that are live before moving any of them, so that
+	// nothing changes if the segment is pinned by an older version.
+	type live struct {
+		key, value []byte
+		expiry     int64
+		seq        uint64
+	}
+	var lives []live
+	pinned := false
+
+	err := t.vlog.Iterate(segment, func(key, value []byte, ptr vlogPointer) error {
+		ent, expiry, newest, ok, err := t.pointerVersion(key, ptr)
+		if err != nil || !ok {
+			return err
+		} else if !newest {
+			pinned = true
+			return nil
+		}
+
+		// it keeps the same expiration time and sequence number.
+		lives = append(lives, live{
+			key:    append([]byte(nil), key...),
+			value:  append([]byte(nil), value...),
+			expiry: expiry,
+			seq:    ent.Seq(),
+		})
+		return nil
+	})
+	if err != nil {
+		timer.Stop()
+		return false, Error.Wrap(err)
+	} else if pinned {
+		timer.Stop()
+		return false, nil
+	}
+
+	for _, l := range lives {
+		if err := t.insert(l.key, l.value, l.expiry, l.seq); err != nil {
+			timer.Stop()
+			return false, Error.Wrap(err)
+		}
+	}
+
+	if err := t.vlog.Delete(); err != nil {
+		timer.Stop()
+		return false, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return true, nil
+}
+
+// pointerVersion finds the newest version of the key that points at the
+// value log pointer and has not expired, out of the versions that can still
+// be read at or after the watermark. It returns the version, when it
+// expires, and if it is the newest version of the key.
+func (t *T) pointerVersion(key []byte, ptr vlogPointer) (
+	ent entry.T, expiry int64, newest, ok bool, err error) {
+
+	mark := t.Watermark()
+	for seq := uint64(entry.MaxSeq); ; {
+		ent, cur, _, ok, err := t.lookup(key, seq)
+		if err != nil || !ok {
+			return entry.T{}, 0, false, false, err
+		}
+
+		if ent.Pointer() && !t.expired(ent, cur) {
+			expiry, cur, err := splitExpiry(ent, cur)
+			if err != nil {
+				return entry.T{}, 0, false, false, err
+			}
+			if cptr, err := readPointer(cur); err != nil {
+				return entry.T{}, 0, false, false, err
+			} else if cptr == ptr {
+				return ent, expiry, seq == entry.MaxSeq, true, nil
+			}
+		}
+
+		// versions at or below the watermark hide any older ones.
+		if ent.Seq() <= mark {
+			return entry.T{}, 0, false, false, nil
+		}
+		seq = ent.Seq() - 1
+	}
+}
+
+// SyncValues writes any values buffered in the value log out to its disk.
+func (t *T) SyncValues() error {
+	if t.vlog == nil {
+		return nil
+	}
+	return Error.Wrap(t.vlog.Sync())
+}
+
+var deleteRangeThunk mon.Thunk // timing for DeleteRange
+
+// DeleteRange removes every key from start up to but not including end from
+// the skip list with a single range tombstone, which is moved down to the
+// children that contain the keys as the skip list is flushed. It is not
+// safe to modify the start or end slices.
+func (t *T) DeleteRange(start, end []byte) error {
+	timer := deleteRangeThunk.Start()
+
+	if bytes.Compare(start, end) >= 0 {
+		timer.Stop()
+		return nil
+	}
+
+	if err := t.grow(start); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+	if !t.root.DeleteRange(start, end, t.next()) {
+		timer.Stop()
+		return Error.New("entry too large to fit")
+	}
+	if err := t.flushIfFull(); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return nil
+}
+
+var deleteThunk mon.Thunk // timing for Delete
+
 // Delete removes the key from the skip list. It is not safe to modify the
 // key slice.
 func (t *T) Delete(key []byte) error {
+	timer := deleteThunk.Start()
+
+	if err := t.delete(key, t.next()); err != nil {
+		timer.Stop()
+		return Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return nil
+}
+
+// delete removes the key in the root as of the sequence number, and flushes
+// the root if it has grown too large.
+func (t *T) delete(key []byte, seq uint64) error {
 	// TODO(jeff): if some child has enough delete entries
 	// destined for it, we need to immediately flush.
 
-	panic("not implemented")
+	if err := t.grow(key); err != nil {
+		return Error.Wrap(err)
+	}
+	if !t.root.Delete(key, seq) {
+		return Error.New("entry too large to fit")
+	}
+	return t.flushIfFull()
 }
 
+var successorThunk mon.Thunk // timing for Successor
+
 // Successor returns the entry that sorts after key but still has the prefix
 // if one exists. Otherwise, it returns nil, nil. It is not safe to modify the
 // returned slices.
 func (t *T) Successor(key, prefix []byte) ([]byte, []byte, error) {
-	panic("not implemented")
+	timer := successorThunk.Start()
+
+	skey, value, err := t.successorAt(key, prefix, entry.MaxSeq)
+	if err != nil {
+		timer.Stop()
+		return nil, nil, Error.Wrap(err)
+	}
+
+	timer.Stop()
+	return skey, value, nil
+}
+
+// successorAt returns the entry that sorts after key but still has the
+// prefix as of the sequence number if one exists, like Successor.
+func (t *T) successorAt(key, prefix []byte, seq uint64) ([]byte, []byte, error) {
+	// if the key sorts before every key with the prefix, the prefix itself
+	// is the first key that could be returned.
+	if bytes.Compare(key, prefix) < 0 {
+		value, ok, err := t.read(prefix, seq)
+		if err != nil {
+			return nil, nil, Error.Wrap(err)
+		}
+		if ok {
+			return prefix, value, nil
+		}
+		key = prefix
+	}
+
+	// the walk over the children does not know about the queued roots, so
+	// they have to be flushed first.
+	if err := t.WaitFlushed(); err != nil {
+		return nil, nil, Error.Wrap(err)
+	}
+
+	for {
+		t.lower.Lock()
+		ent, skey, value, ok, err := t.successor(t.root, key, seq)
+		t.lower.Unlock()
+		if err != nil {
+			return nil, nil, Error.Wrap(err)
+		} else if !ok || !bytes.HasPrefix(skey, prefix) {
+			return nil, nil, nil
+		}
+
+		// skip over any deleted or expired keys.
+		if ent.Tombstone() || t.expired(ent, value) {
+			key = skey
+			continue
+		}
+
+		// merge operands have to be combined with the older entries.
+		if ent.Merge() {
+			value, _, err = t.read(skey, seq)
+			return skey, value, err
+		}
+
+		value, err = t.entryValue(ent, value)
+		return skey, value, err
+	}
+}
+
+// entryValue returns the value for the entry without any expiration time,
+// reading it from the value log if it was separated.
+func (t *T) entryValue(ent entry.T, value []byte) ([]byte, error) {
+	_, value, err := splitExpiry(ent, value)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	} else if !ent.Pointer() {
+		return value, nil
+	}
+	value, err = t.readPointer(value)
+	if err != nil {
+		return nil, Error.Wrap(err)
+	}
+	return value, nil
+}
+
+// successor returns the most recent entry as of the sequence number for the
+// smallest key strictly greater than the key in the subtree rooted at the
+// node, even if it is a tombstone. The returned key and value are copies.
+func (t *T) successor(n *node.T, key []byte, seq uint64) (
+	ent entry.T, skey, value []byte, ok bool, err error) {
+
+	// find the candidate from this node, which only has one if the key sorts
+	// before its largest key. keys that only have versions newer than the
+	// sequence number are left for the children to provide.
+	iter := n.Iterator()
+	if _, hi, has := n.Fences(); has && bytes.Compare(key, hi) < 0 {
+		ok = iter.Seek(key)
+	}
+	if ok && bytes.Equal(iter.Key(), key) {
+		ok = iter.Next()
+	}
+	for ; ok; ok = iter.Next() {
+		if vent, vvalue, vok := iter.At(seq); vok {
+			ent = vent
+			skey = append([]byte(nil), iter.Key()...)
+			value = append([]byte(nil), vvalue...)
+			break
+		}
+	}
+
+	// a range tombstone in the node hides the older entries in it.
+	if rseq, covered := n.Covering(skey, seq); ok && covered && ent.Seq() < rseq {
+		ent, value = entry.New(skey, nil, true, 0), nil
+	}
+	if n.Height() == 0 {
+		return ent, skey, value, ok, nil
+	}
+
+	// walk the children in order, starting with the one containing the key.
+	// the entries in this node are newer than any in the children, so it
+	// wins ties, and children that start after its candidate are skipped.
+	child := n.ChildOf(key)
+	citer := n.Iterator()
+	more := citer.Seek(key)
+	if more && bytes.Equal(citer.Key(), key) {
+		more = citer.Next()
+	}
+
+	for {
+		if child != noBlock && child != invalidBlock {
+			cent, ckey, cvalue, cok, err := t.childSuccessor(n, child, key, seq)
+			if err != nil {
+				return entry.T{}, nil, nil, false, err
+			} else if cok && (!ok || bytes.Compare(ckey, skey) < 0) {
+				// a range tombstone in this node hides the older entry.
+				if rseq, covered := n.Covering(ckey, seq); covered && cent.Seq() < rseq {
+					cent = entry.New(ckey, nil, true, 0)
+				}
+				return cent, ckey, cvalue, true, nil
+			} else if cok {
+				return ent, skey, value, ok, nil
+			}
+		}
+
+		for more && citer.Entry().Pivot() == 0 {
+			more = citer.Next()
+		}
+		if !more || (ok && bytes.Compare(citer.Key(), skey) >= 0) {
+			return ent, skey, value, ok, nil
+		}
+		child = citer.Entry().Pivot()
+		more = citer.Next()
+	}
+}
+
+// childSuccessor finds the successor of the key as of the sequence number
+// in the subtree rooted at the child block of the node.
+func (t *T) childSuccessor(n *node.T, child uint32, key []byte, seq uint64) (
+	ent entry.T, skey, value []byte, ok bool, err error) {
+
+	le, err := t.cache.Get(child)
+	if err != nil {
+		return entry.T{}, nil, nil, false, Error.Wrap(err)
+	}
+	defer le.Close()
+
+	if le.Node().Height() != n.Height()-1 {
+		return entry.T{}, nil, nil, false, Error.New(
+			"invalid child height at block %d: %d != %d",
+			child, le.Node().Height(), n.Height()-1)
+	}
+	return t.successor(le.Node(), key, seq)
 }